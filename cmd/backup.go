@@ -45,7 +45,7 @@ var BackupCmd = &cobra.Command{
 
 func init() {
 	// Backup
-	BackupCmd.PersistentFlags().StringP("storage", "s", "local", "Define storage: local, s3, ssh, ftp, azure")
+	BackupCmd.PersistentFlags().StringP("storage", "s", "local", "Define storage: local, s3, ssh, ftp, azure, gcs, webdav, rclone")
 	BackupCmd.PersistentFlags().StringP("path", "P", "", "Storage path without file name. e.g: /custom_path or ssh remote path `/home/foo/backup`")
 	BackupCmd.PersistentFlags().StringP("cron-expression", "e", "", "Backup cron expression (e.g., `0 0 * * *` or `@daily`)")
 	BackupCmd.PersistentFlags().StringP("config", "c", "", "Configuration file for multi database backup. (e.g: `/backup/config.yaml`)")
@@ -53,5 +53,12 @@ func init() {
 	BackupCmd.PersistentFlags().BoolP("all-databases", "a", false, "Backup all databases")
 	BackupCmd.PersistentFlags().BoolP("all-in-one", "A", false, "Backup all databases in a single file")
 	BackupCmd.PersistentFlags().StringP("custom-name", "", "", "Custom backup name")
+	BackupCmd.PersistentFlags().StringP("encryption", "", "", "Encryption method: age, gpg or envelope. Default: age when AGE_RECIPIENTS is set, envelope when KEY_PROVIDER is set, otherwise gpg")
+	BackupCmd.PersistentFlags().StringP("split-size", "", "", "Split the backup into volumes of the given size (e.g. `2GiB`)")
+	BackupCmd.PersistentFlags().StringP("concurrency", "", "", "Number of databases backed up at the same time with --all-databases or a config file. Default: 1")
+	BackupCmd.PersistentFlags().StringP("overlap-policy", "", "", "Policy of a scheduled backup due while the previous one is still running: skip, queue or delay. Default: skip")
+	BackupCmd.PersistentFlags().StringP("lock", "", "", "Lock the backups so two instances never back up the same database at the same time: none, file or database. Default: none")
+	BackupCmd.PersistentFlags().StringP("upload-rate-limit", "", "", "Limit the upload bandwidth to the storage (e.g. `20MiB/s`)")
+	BackupCmd.PersistentFlags().StringP("download-rate-limit", "", "", "Limit the download bandwidth from the storage (e.g. `20MiB/s`)")
 
 }
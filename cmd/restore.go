@@ -48,7 +48,12 @@ var RestoreCmd = &cobra.Command{
 func init() {
 	// Restore
 	RestoreCmd.PersistentFlags().StringP("file", "f", "", "File name of database")
-	RestoreCmd.PersistentFlags().StringP("storage", "s", "local", "Define storage: local, s3, ssh, ftp")
+	RestoreCmd.PersistentFlags().StringP("storage", "s", "local", "Define storage: local, s3, ssh, ftp, azure, gcs, webdav, rclone")
 	RestoreCmd.PersistentFlags().StringP("path", "P", "", "AWS S3 path without file name. eg: /custom_path or ssh remote path `/home/foo/backup`")
+	RestoreCmd.PersistentFlags().BoolP("atomic", "", false, "Restore into a staging database and swap it with the live database")
+	RestoreCmd.PersistentFlags().StringP("upload-rate-limit", "", "", "Limit the upload bandwidth to the storage (e.g. `20MiB/s`)")
+	RestoreCmd.PersistentFlags().StringP("download-rate-limit", "", "", "Limit the download bandwidth from the storage (e.g. `20MiB/s`)")
+	RestoreCmd.PersistentFlags().StringP("keep-old", "", "", "How long to keep the previous version after an atomic restore (e.g. `24h`, `7d`). Default: 24h")
+	RestoreCmd.PersistentFlags().BoolP("require-signature", "", false, "Refuse unsigned or tampered backups, signatures are verified with SIGNATURE_PUBLIC_KEY")
 
 }
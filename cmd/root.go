@@ -25,6 +25,7 @@ SOFTWARE.
 package cmd
 
 import (
+	"github.com/jkaninda/mysql-bkup/pkg"
 	"github.com/jkaninda/mysql-bkup/utils"
 	"github.com/spf13/cobra"
 	"os"
@@ -37,6 +38,9 @@ var rootCmd = &cobra.Command{
 	Long:    `MySQL Database backup and restoration tool. Backup database to AWS S3 storage or any S3 Alternatives for Object Storage.`,
 	Example: utils.MainExample,
 	Version: appVersion,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		pkg.LoadSecrets()
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -54,5 +58,11 @@ func init() {
 	rootCmd.AddCommand(BackupCmd)
 	rootCmd.AddCommand(RestoreCmd)
 	rootCmd.AddCommand(MigrateCmd)
+	rootCmd.AddCommand(TransferCmd)
+	rootCmd.AddCommand(ListCmd)
+	rootCmd.AddCommand(ReencryptCmd)
+	rootCmd.AddCommand(VerifyCmd)
+	rootCmd.AddCommand(ConfigCmd)
+	rootCmd.AddCommand(DoctorCmd)
 
 }
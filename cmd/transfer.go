@@ -0,0 +1,61 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"github.com/jkaninda/mysql-bkup/pkg"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"github.com/spf13/cobra"
+)
+
+var TransferCmd = &cobra.Command{
+	Use:     "transfer",
+	Short:   "Transfer backups from a storage to another",
+	Example: utils.TransferExample,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			pkg.StartTransfer(cmd)
+		} else {
+			utils.Fatal(`"transfer" accepts no argument %q`, args)
+
+		}
+
+	},
+}
+
+func init() {
+	// Transfer
+	TransferCmd.PersistentFlags().StringP("from", "", "", "Source storage: local, s3, ssh, ftp, azure, gcs, webdav, rclone")
+	TransferCmd.PersistentFlags().StringP("to", "", "", "Destination storage: local, s3, ssh, ftp, azure, gcs, webdav, rclone")
+	TransferCmd.PersistentFlags().StringP("from-path", "", "", "Source storage path, or directory for local storage. Default: REMOTE_PATH")
+	TransferCmd.PersistentFlags().StringP("to-path", "", "", "Destination storage path, or directory for local storage. Default: REMOTE_PATH")
+	TransferCmd.PersistentFlags().StringP("since", "", "", "Only transfer backups created within the given duration (e.g. `24h`, `7d`)")
+	TransferCmd.PersistentFlags().StringP("upload-rate-limit", "", "", "Limit the upload bandwidth to the destination storage (e.g. `20MiB/s`)")
+	TransferCmd.PersistentFlags().StringP("download-rate-limit", "", "", "Limit the download bandwidth from the source storage (e.g. `20MiB/s`)")
+	TransferCmd.PersistentFlags().BoolP("delete-source", "", false, "Delete backups from the source storage once transferred and verified")
+	_ = TransferCmd.MarkPersistentFlagRequired("from")
+	_ = TransferCmd.MarkPersistentFlagRequired("to")
+
+}
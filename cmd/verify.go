@@ -0,0 +1,57 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"github.com/jkaninda/mysql-bkup/pkg"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"github.com/spf13/cobra"
+)
+
+var VerifyCmd = &cobra.Command{
+	Use:     "verify",
+	Short:   "Verify the signature and checksum of a backup",
+	Example: utils.VerifyExample,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			pkg.StartVerify(cmd)
+		} else {
+			utils.Fatal(`"verify" accepts no argument %q`, args)
+
+		}
+
+	},
+}
+
+func init() {
+	// Verify
+	VerifyCmd.PersistentFlags().StringP("storage", "s", "local", "Define storage: local, s3, ssh, ftp, azure, gcs, webdav, rclone")
+	VerifyCmd.PersistentFlags().StringP("path", "P", "", "Storage path, or directory for local storage. Default: REMOTE_PATH")
+	VerifyCmd.PersistentFlags().StringP("file", "f", "", "Backup file name")
+	VerifyCmd.PersistentFlags().BoolP("require-signature", "", false, "Refuse unsigned backups, signatures are verified with SIGNATURE_PUBLIC_KEY")
+	VerifyCmd.PersistentFlags().StringP("download-rate-limit", "", "", "Limit the download bandwidth from the storage (e.g. `20MiB/s`)")
+	_ = VerifyCmd.MarkPersistentFlagRequired("file")
+
+}
@@ -0,0 +1,243 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	encryptionGPG = "gpg"
+	encryptionAge = "age"
+)
+
+// loadAgeRecipients loads the age recipients: X25519 and SSH public keys of AGE_RECIPIENTS and
+// the files or directories of AGE_RECIPIENTS_FILE, or the passphrase of AGE_PASSPHRASE.
+// Nil is returned when none is set.
+func loadAgeRecipients() ([]age.Recipient, error) {
+	lines := splitList(os.Getenv("AGE_RECIPIENTS"))
+	if recipientsFiles := os.Getenv("AGE_RECIPIENTS_FILE"); recipientsFiles != "" {
+		files, err := keyFiles(recipientsFiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read age recipients file: %w", err)
+		}
+		for _, recipientsFile := range files {
+			fileLines, err := readKeyLines(recipientsFile)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, fileLines...)
+		}
+	}
+	passphrase := os.Getenv("AGE_PASSPHRASE")
+	if passphrase != "" {
+		if len(lines) > 0 {
+			return nil, errors.New("AGE_PASSPHRASE can't be used with AGE_RECIPIENTS or AGE_RECIPIENTS_FILE")
+		}
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{recipient}, nil
+	}
+	recipients := make([]age.Recipient, 0, len(lines))
+	for _, line := range lines {
+		recipient, err := parseAgeRecipient(line)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// parseAgeRecipient parses an age recipient or an SSH public key
+func parseAgeRecipient(value string) (age.Recipient, error) {
+	if strings.HasPrefix(value, "ssh-") {
+		recipient, err := agessh.ParseRecipient(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SSH recipient %q: %w", value, err)
+		}
+		return recipient, nil
+	}
+	recipients, err := age.ParseRecipients(strings.NewReader(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid age recipient %q: %w", value, err)
+	}
+	return recipients[0], nil
+}
+
+// loadAgeIdentities loads the identities decrypting age backups: the identity files and directories
+// of AGE_IDENTITY_FILE, age identities or SSH private keys, and the passphrase of AGE_PASSPHRASE
+func loadAgeIdentities() ([]age.Identity, error) {
+	var identities []age.Identity
+	var files []string
+	if identityFiles := os.Getenv("AGE_IDENTITY_FILE"); identityFiles != "" {
+		var err error
+		if files, err = keyFiles(identityFiles); err != nil {
+			return nil, fmt.Errorf("failed to read age identity file: %w", err)
+		}
+	}
+	for _, identityFile := range files {
+		fileIdentities, err := parseAgeIdentityFile(identityFile)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, fileIdentities...)
+	}
+	if passphrase := os.Getenv("AGE_PASSPHRASE"); passphrase != "" {
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, nil
+}
+
+// parseAgeIdentityFile parses an age identity file or an SSH private key, a passphrase protected
+// SSH private key is decrypted with AGE_IDENTITY_PASSPHRASE
+func parseAgeIdentityFile(identityFile string) ([]age.Identity, error) {
+	data, err := os.ReadFile(identityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read age identity file: %w", err)
+	}
+	if !strings.Contains(string(data), "PRIVATE KEY-----") {
+		identities, err := age.ParseIdentities(strings.NewReader(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identity file %s: %w", identityFile, err)
+		}
+		return identities, nil
+	}
+	identity, err := agessh.ParseIdentity(data)
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) {
+		passphrase := os.Getenv("AGE_IDENTITY_PASSPHRASE")
+		if passphrase == "" || missingErr.PublicKey == nil {
+			return nil, fmt.Errorf("the SSH identity %s is encrypted, set AGE_IDENTITY_PASSPHRASE", identityFile)
+		}
+		identity, err = agessh.NewEncryptedSSHIdentity(missingErr.PublicKey, data, func() ([]byte, error) {
+			return []byte(passphrase), nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH identity %s: %w", identityFile, err)
+	}
+	return []age.Identity{identity}, nil
+}
+
+// readKeyLines returns the lines of a recipients file, empty lines and comments are ignored
+func readKeyLines(filePath string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read age recipients file: %w", err)
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			return
+		}
+	}(f)
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// ageEncrypt encrypts a file of the directory dir for the recipients
+func ageEncrypt(dir, fileName, outputName string, recipients []age.Recipient) error {
+	in, err := os.Open(filepath.Join(dir, fileName))
+	if err != nil {
+		return err
+	}
+	defer func(in *os.File) {
+		err := in.Close()
+		if err != nil {
+			return
+		}
+	}(in)
+	out, err := os.Create(filepath.Join(dir, outputName))
+	if err != nil {
+		return err
+	}
+	w, err := age.Encrypt(out, recipients...)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	if _, err = io.Copy(w, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ageDecrypt decrypts an age encrypted file to outputFile
+func ageDecrypt(filePath, outputFile string, identities []age.Identity) error {
+	if len(identities) == 0 {
+		return errors.New("AGE_IDENTITY_FILE or AGE_PASSPHRASE required for age file")
+	}
+	in, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func(in *os.File) {
+		err := in.Close()
+		if err != nil {
+			return
+		}
+	}(in)
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
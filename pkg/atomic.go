@@ -0,0 +1,450 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"strings"
+	"time"
+)
+
+// maxDatabaseNameLength is the maximum length of a MySQL database name
+const maxDatabaseNameLength = 64
+
+type trigger struct {
+	name      string
+	timing    string
+	event     string
+	table     string
+	statement string
+	definer   string
+	sqlMode   string
+}
+
+// routine is a stored procedure, a stored function or an event
+type routine struct {
+	kind      string
+	name      string
+	sqlMode   string
+	timeZone  string
+	statement string
+}
+
+type view struct {
+	name        string
+	definition  string
+	checkOption string
+	definer     string
+	security    string
+}
+
+// atomicRestore restores the backup into a staging database, validates it and swaps its tables
+// with the live database. The previous tables are kept in <db>__old_<timestamp>.
+func atomicRestore(db *dbConfig, conf *RestoreConfig, restorationFile string) {
+	timestamp := time.Now().Format(atomicTimeFormat)
+	stagingName := fmt.Sprintf("%s__restore_%s", db.dbName, timestamp)
+	oldName := fmt.Sprintf("%s__old_%s", db.dbName, timestamp)
+	if len(stagingName) > maxDatabaseNameLength {
+		utils.Fatal("Database name %s is too long for atomic restore", db.dbName)
+	}
+
+	utils.Info("Restoring database into staging database %s...", stagingName)
+	if err := createDatabaseLike(db.dbName, stagingName); err != nil {
+		utils.Fatal("Error creating staging database: %v", err)
+	}
+	staging := *db
+	staging.dbName = stagingName
+	// --one-database ignores statements targeting another database, so the live database is never touched
+	if err := importDatabaseFile(&staging, restorationFile, "--one-database"); err != nil {
+		dropDatabase(stagingName)
+		utils.Fatal("Error restoring database into staging database: %v", err)
+	}
+	utils.Info("Restoring database into staging database %s...done", stagingName)
+
+	utils.Info("Validating staging database...")
+	if err := validateDatabase(stagingName, db.dbName); err != nil {
+		dropDatabase(stagingName)
+		utils.Fatal("Staging database validation failed, %s database has not been modified: %v", db.dbName, err)
+	}
+	utils.Info("Validating staging database...done")
+
+	utils.Info("Swapping staging database with %s database...", db.dbName)
+	if err := swapDatabase(db.dbName, stagingName, oldName); err != nil {
+		dropDatabase(stagingName)
+		utils.Fatal("Error swapping databases: %v", err)
+	}
+	pruneOldDatabases(db.dbName, conf.keepOld)
+	dropDatabase(stagingName)
+	utils.Info("Swapping staging database with %s database...done", db.dbName)
+	if conf.keepOld > 0 {
+		utils.Info("Database has been restored successfully, previous version kept in %s for %s", oldName, conf.keepOld)
+	} else {
+		utils.Info("Database has been restored successfully")
+	}
+	deleteTemp()
+}
+
+// createDatabaseLike creates a database using the character set and collation of the source database
+func createDatabaseLike(source, name string) error {
+	rows, err := querySQL(fmt.Sprintf("SELECT DEFAULT_CHARACTER_SET_NAME, DEFAULT_COLLATION_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = %s", quoteString(source)))
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("CREATE DATABASE %s", quoteIdentifier(name))
+	if len(rows) == 1 && len(rows[0]) == 2 {
+		query = fmt.Sprintf("%s CHARACTER SET %s COLLATE %s", query, rows[0][0], rows[0][1])
+	}
+	return execSQL("", query+";")
+}
+
+// dropDatabase drops a database created during the atomic restore
+func dropDatabase(name string) {
+	if err := execSQL("", fmt.Sprintf("DROP DATABASE IF EXISTS %s;", quoteIdentifier(name))); err != nil {
+		utils.Error("Error dropping database %s: %v", name, err)
+	}
+}
+
+// validateDatabase checks that none of the tables of the database is corrupted. A database without tables
+// is only rejected when the live database has tables, as an empty backup would replace them.
+func validateDatabase(name, live string) error {
+	tables, err := listTables(name)
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		liveTables, err := listTables(live)
+		if err != nil {
+			return err
+		}
+		if len(liveTables) > 0 {
+			return fmt.Errorf("no table has been restored in %s while %s has %d tables", name, live, len(liveTables))
+		}
+		utils.Warn("No table has been restored in %s, the backup is empty", name)
+		return nil
+	}
+	for i := 0; i < len(tables); i += 100 {
+		end := min(i+100, len(tables))
+		names := make([]string, 0, end-i)
+		for _, table := range tables[i:end] {
+			names = append(names, fmt.Sprintf("%s.%s", quoteIdentifier(name), quoteIdentifier(table)))
+		}
+		rows, err := querySQL(fmt.Sprintf("CHECK TABLE %s QUICK", strings.Join(names, ", ")))
+		if err != nil {
+			return err
+		}
+		// Columns: Table, Op, Msg_type, Msg_text
+		for _, row := range rows {
+			if len(row) == 4 && strings.EqualFold(row[2], "error") {
+				return fmt.Errorf("table %s: %s", row[0], row[3])
+			}
+		}
+	}
+	return nil
+}
+
+// swapDatabase moves the live tables to the old database and the staging tables to the live database
+// in a single RENAME TABLE statement, then moves triggers and views which cannot be renamed across databases.
+func swapDatabase(live, staging, old string) error {
+	liveTables, err := listTables(live)
+	if err != nil {
+		return err
+	}
+	stagingTables, err := listTables(staging)
+	if err != nil {
+		return err
+	}
+	liveTriggers, err := listTriggers(live)
+	if err != nil {
+		return err
+	}
+	stagingTriggers, err := listTriggers(staging)
+	if err != nil {
+		return err
+	}
+	stagingViews, err := listViews(staging)
+	if err != nil {
+		return err
+	}
+	liveViews, err := listViews(live)
+	if err != nil {
+		return err
+	}
+	stagingRoutines, err := listRoutines(staging)
+	if err != nil {
+		return err
+	}
+	if err = createDatabaseLike(live, old); err != nil {
+		return fmt.Errorf("error creating %s database: %w", old, err)
+	}
+
+	// Tables with triggers cannot be moved to another database, writes to the live database
+	// don't run any trigger until the triggers of the backup are created after the swap
+	if len(liveTriggers) > 0 || len(stagingTriggers) > 0 {
+		utils.Warn("The triggers of the %s database are dropped during the swap, writes to the database must be stopped until the restore completes", live)
+	}
+	if err = dropTriggers(staging, stagingTriggers); err != nil {
+		dropDatabase(old)
+		return err
+	}
+	if err = dropTriggers(live, liveTriggers); err != nil {
+		dropDatabase(old)
+		return err
+	}
+
+	renames := make([]string, 0, len(liveTables)+len(stagingTables))
+	for _, table := range liveTables {
+		renames = append(renames, fmt.Sprintf("%s.%s TO %s.%s", quoteIdentifier(live), quoteIdentifier(table), quoteIdentifier(old), quoteIdentifier(table)))
+	}
+	for _, table := range stagingTables {
+		renames = append(renames, fmt.Sprintf("%s.%s TO %s.%s", quoteIdentifier(staging), quoteIdentifier(table), quoteIdentifier(live), quoteIdentifier(table)))
+	}
+	// An empty backup restored over an empty database has no table to rename
+	if len(renames) > 0 {
+		if err = execSQL("", fmt.Sprintf("RENAME TABLE %s;", strings.Join(renames, ", "))); err != nil {
+			// RENAME TABLE is atomic, the live tables are still in place
+			if rErr := createTriggers(live, liveTriggers); rErr != nil {
+				utils.Error("Error restoring triggers of %s database: %v", live, rErr)
+			}
+			dropDatabase(old)
+			return fmt.Errorf("error renaming tables: %w", err)
+		}
+	}
+
+	if err = createTriggers(old, liveTriggers); err != nil {
+		utils.Warn("Error copying triggers to %s database: %v", old, err)
+	}
+	if err = createTriggers(live, stagingTriggers); err != nil {
+		return fmt.Errorf("tables have been swapped but triggers could not be created, previous version is kept in %s: %w", old, err)
+	}
+	if err = replaceViews(live, staging, stagingViews, liveViews); err != nil {
+		return fmt.Errorf("tables have been swapped but views could not be created, previous version is kept in %s: %w", old, err)
+	}
+	// The routines and events of the backup are restored into the staging database, which is dropped
+	if err = createRoutines(live, stagingRoutines); err != nil {
+		return fmt.Errorf("tables have been swapped but routines and events could not be created, previous version is kept in %s: %w", old, err)
+	}
+	return nil
+}
+
+// pruneOldDatabases drops the previous versions of the database kept longer than keepOld
+func pruneOldDatabases(name string, keepOld time.Duration) {
+	rows, err := querySQL("SHOW DATABASES")
+	if err != nil {
+		utils.Error("Error listing databases: %v", err)
+		return
+	}
+	prefix := fmt.Sprintf("%s__old_", name)
+	for _, row := range rows {
+		if !strings.HasPrefix(row[0], prefix) {
+			continue
+		}
+		createdAt, err := time.ParseInLocation(atomicTimeFormat, strings.TrimPrefix(row[0], prefix), time.Local)
+		if err != nil {
+			continue
+		}
+		if time.Since(createdAt) >= keepOld {
+			utils.Info("Deleting previous version %s...", row[0])
+			dropDatabase(row[0])
+		}
+	}
+}
+
+// listTables returns the tables of a database, views excluded
+func listTables(schema string) ([]string, error) {
+	rows, err := querySQL(fmt.Sprintf("SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = %s AND TABLE_TYPE <> 'VIEW'", quoteString(schema)))
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(rows))
+	for _, row := range rows {
+		tables = append(tables, row[0])
+	}
+	return tables, nil
+}
+
+// listTriggers returns the triggers of a database
+func listTriggers(schema string) ([]trigger, error) {
+	rows, err := querySQL(fmt.Sprintf("SELECT TRIGGER_NAME, ACTION_TIMING, EVENT_MANIPULATION, EVENT_OBJECT_TABLE, ACTION_STATEMENT, DEFINER, SQL_MODE "+
+		"FROM information_schema.TRIGGERS WHERE TRIGGER_SCHEMA = %s ORDER BY EVENT_OBJECT_TABLE, ACTION_ORDER", quoteString(schema)))
+	if err != nil {
+		return nil, err
+	}
+	triggers := make([]trigger, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != 7 {
+			return nil, fmt.Errorf("unexpected trigger definition in %s database", schema)
+		}
+		triggers = append(triggers, trigger{name: row[0], timing: row[1], event: row[2], table: row[3], statement: row[4], definer: row[5], sqlMode: row[6]})
+	}
+	return triggers, nil
+}
+
+// listViews returns the views of a database
+func listViews(schema string) ([]view, error) {
+	rows, err := querySQL(fmt.Sprintf("SELECT TABLE_NAME, VIEW_DEFINITION, CHECK_OPTION, DEFINER, SECURITY_TYPE FROM information_schema.VIEWS WHERE TABLE_SCHEMA = %s", quoteString(schema)))
+	if err != nil {
+		return nil, err
+	}
+	views := make([]view, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != 5 || row[1] == "" {
+			return nil, fmt.Errorf("could not read view definitions of %s database, SHOW VIEW privilege is required", schema)
+		}
+		views = append(views, view{name: row[0], definition: row[1], checkOption: row[2], definer: row[3], security: row[4]})
+	}
+	return views, nil
+}
+
+// listRoutines returns the stored procedures, stored functions and events of a database
+func listRoutines(schema string) ([]routine, error) {
+	rows, err := querySQL(fmt.Sprintf("SELECT ROUTINE_TYPE, ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = %s "+
+		"UNION ALL SELECT 'EVENT', EVENT_NAME FROM information_schema.EVENTS WHERE EVENT_SCHEMA = %s", quoteString(schema), quoteString(schema)))
+	if err != nil {
+		return nil, err
+	}
+	routines := make([]routine, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != 2 {
+			return nil, fmt.Errorf("unexpected routine in %s database", schema)
+		}
+		r := routine{kind: row[0], name: row[1]}
+		definition, err := querySQL(fmt.Sprintf("SHOW CREATE %s %s.%s", r.kind, quoteIdentifier(schema), quoteIdentifier(r.name)))
+		if err != nil {
+			return nil, err
+		}
+		// Columns: name, sql_mode, statement for routines; name, sql_mode, time_zone, statement for events
+		statement := 2
+		if r.kind == "EVENT" {
+			statement = 3
+		}
+		if len(definition) != 1 || len(definition[0]) <= statement || definition[0][statement] == "NULL" {
+			return nil, fmt.Errorf("could not read the definition of %s %s of %s database", strings.ToLower(r.kind), r.name, schema)
+		}
+		r.sqlMode = definition[0][1]
+		if r.kind == "EVENT" {
+			r.timeZone = definition[0][2]
+		}
+		r.statement = definition[0][statement]
+		routines = append(routines, r)
+	}
+	return routines, nil
+}
+
+// createRoutines creates the routines and events in a database, they replace the ones with the same name
+func createRoutines(schema string, routines []routine) error {
+	if len(routines) == 0 {
+		return nil
+	}
+	var script strings.Builder
+	script.WriteString("DELIMITER ;;\n")
+	for _, r := range routines {
+		fmt.Fprintf(&script, "SET SESSION sql_mode = %s;;\n", quoteString(r.sqlMode))
+		if r.timeZone != "" {
+			fmt.Fprintf(&script, "SET SESSION time_zone = %s;;\n", quoteString(r.timeZone))
+		}
+		fmt.Fprintf(&script, "DROP %s IF EXISTS %s;;\n", r.kind, quoteIdentifier(r.name))
+		fmt.Fprintf(&script, "%s;;\n", r.statement)
+	}
+	script.WriteString("DELIMITER ;\n")
+	return execSQL(schema, script.String())
+}
+
+func dropTriggers(schema string, triggers []trigger) error {
+	if len(triggers) == 0 {
+		return nil
+	}
+	var script strings.Builder
+	for _, t := range triggers {
+		fmt.Fprintf(&script, "DROP TRIGGER %s.%s;\n", quoteIdentifier(schema), quoteIdentifier(t.name))
+	}
+	if err := execSQL("", script.String()); err != nil {
+		return fmt.Errorf("error dropping triggers of %s database: %w", schema, err)
+	}
+	return nil
+}
+
+func createTriggers(schema string, triggers []trigger) error {
+	if len(triggers) == 0 {
+		return nil
+	}
+	var script strings.Builder
+	script.WriteString("DELIMITER ;;\n")
+	for _, t := range triggers {
+		fmt.Fprintf(&script, "SET SESSION sql_mode = %s;;\n", quoteString(t.sqlMode))
+		fmt.Fprintf(&script, "CREATE DEFINER=%s TRIGGER %s %s %s ON %s FOR EACH ROW %s;;\n",
+			quoteDefiner(t.definer), quoteIdentifier(t.name), t.timing, t.event, quoteIdentifier(t.table), t.statement)
+	}
+	script.WriteString("DELIMITER ;\n")
+	return execSQL(schema, script.String())
+}
+
+// replaceViews creates the staging views in the live database and drops the live views missing from the backup.
+// View definitions reference the staging database, they are rewritten to reference the live database.
+func replaceViews(live, staging string, stagingViews, liveViews []view) error {
+	restored := make(map[string]bool, len(stagingViews))
+	pending := make([]view, 0, len(stagingViews))
+	for _, v := range stagingViews {
+		restored[v.name] = true
+		v.definition = strings.ReplaceAll(v.definition, quoteIdentifier(staging)+".", quoteIdentifier(live)+".")
+		pending = append(pending, v)
+	}
+	for _, v := range liveViews {
+		if !restored[v.name] {
+			if err := execSQL(live, fmt.Sprintf("DROP VIEW IF EXISTS %s;", quoteIdentifier(v.name))); err != nil {
+				return err
+			}
+		}
+	}
+	// Views may depend on each other, retry until no more view can be created
+	for len(pending) > 0 {
+		var failed []view
+		var lastErr error
+		for _, v := range pending {
+			query := fmt.Sprintf("CREATE OR REPLACE DEFINER=%s SQL SECURITY %s VIEW %s AS %s", quoteDefiner(v.definer), v.security, quoteIdentifier(v.name), v.definition)
+			if v.checkOption != "" && v.checkOption != "NONE" {
+				query = fmt.Sprintf("%s WITH %s CHECK OPTION", query, v.checkOption)
+			}
+			if err := execSQL(live, query+";"); err != nil {
+				failed = append(failed, v)
+				lastErr = err
+			}
+		}
+		if len(failed) == len(pending) {
+			return lastErr
+		}
+		pending = failed
+	}
+	return nil
+}
+
+// quoteDefiner quotes a user@host definer
+func quoteDefiner(definer string) string {
+	i := strings.LastIndex(definer, "@")
+	if i < 0 {
+		return quoteIdentifier(definer)
+	}
+	return fmt.Sprintf("%s@%s", quoteIdentifier(definer[:i]), quoteIdentifier(definer[i+1:]))
+}
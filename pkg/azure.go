@@ -26,103 +26,25 @@ package pkg
 
 import (
 	"fmt"
-	"github.com/jkaninda/go-storage/pkg/azure"
-	goutils "github.com/jkaninda/go-utils"
-	"github.com/jkaninda/mysql-bkup/utils"
-
-	"os"
-	"path/filepath"
-	"time"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/pkg/storage/azure"
 )
 
-func azureBackup(db *dbConfig, config *BackupConfig) {
-	utils.Info("Backup database to Azure Blob Storage")
-
-	// Backup database
-	err := BackupDatabase(db, config.backupFileName, disableCompression, config.all, config.allInOne)
+// newAzureStorage creates the Azure Blob storage from the storage settings
+func newAzureStorage(env storageEnv, localPath, remotePath string) (storage.Storage, error) {
+	azureConfig, err := loadAzureConfig(env)
 	if err != nil {
-		recoverMode(err, "Error backing up database")
-		return
-	}
-	finalFileName := config.backupFileName
-	if config.encryption {
-		encryptBackup(config)
-		finalFileName = fmt.Sprintf("%s.%s", config.backupFileName, "gpg")
-	}
-	utils.Info("Uploading backup archive to Azure Blob storage ...")
-	utils.Info("Backup name is %s", finalFileName)
-	azureConfig := loadAzureConfig()
-	azureStorage, err := azure.NewStorage(azure.Config{
-		ContainerName: azureConfig.containerName,
-		AccountName:   azureConfig.accountName,
-		AccountKey:    azureConfig.accountKey,
-		RemotePath:    config.remotePath,
-		LocalPath:     tmpPath,
-	})
-	if err != nil {
-		utils.Fatal("Error creating Azure storage: %s", err)
-	}
-	err = azureStorage.Copy(finalFileName)
-	if err != nil {
-		utils.Fatal("Error copying backup file: %s", err)
-	}
-	utils.Info("Backup saved in %s", filepath.Join(config.remotePath, finalFileName))
-	// Get backup info
-	fileInfo, err := os.Stat(filepath.Join(tmpPath, finalFileName))
-	if err != nil {
-		utils.Error("Error: %s", err)
-	}
-	backupSize = fileInfo.Size()
-	// Delete backup file from tmp folder
-	err = utils.DeleteFile(filepath.Join(tmpPath, finalFileName))
-	if err != nil {
-		utils.Error("Error deleting file: %v", err)
-
-	}
-	if config.prune {
-		err := azureStorage.Prune(config.backupRetention)
-		if err != nil {
-			utils.Fatal("Error deleting old backup from %s storage: %s ", config.storage, err)
-		}
-
-	}
-
-	utils.Info("Backup name is %s", finalFileName)
-	utils.Info("Backup size: %s", utils.ConvertBytes(uint64(backupSize)))
-	utils.Info("Uploading backup archive to Azure Blob storage ... done ")
-
-	duration := goutils.FormatDuration(time.Since(startTime), 0)
-
-	// Send notification
-	utils.NotifySuccess(&utils.NotificationData{
-		File:           finalFileName,
-		BackupSize:     utils.ConvertBytes(uint64(backupSize)),
-		Database:       db.dbName,
-		Storage:        config.storage,
-		BackupLocation: filepath.Join(config.remotePath, finalFileName),
-		Duration:       duration,
+		return nil, fmt.Errorf("error loading azure config: %w", err)
+	}
+	return azure.NewStorage(azure.Config{
+		ContainerName:    azureConfig.containerName,
+		AccountName:      azureConfig.accountName,
+		AccountKey:       azureConfig.accountKey,
+		ConnectionString: azureConfig.connectionString,
+		SASToken:         azureConfig.sasToken,
+		Endpoint:         azureConfig.endpoint,
+		AccessTier:       azureConfig.accessTier,
+		RemotePath:       remotePath,
+		LocalPath:        localPath,
 	})
-	// Delete temp
-	deleteTemp()
-	utils.Info("The backup of the %s database has been completed in %s", db.dbName, duration)
-}
-func azureRestore(db *dbConfig, conf *RestoreConfig) {
-	utils.Info("Restore database from Azure Blob storage")
-	azureConfig := loadAzureConfig()
-	azureStorage, err := azure.NewStorage(azure.Config{
-		ContainerName: azureConfig.containerName,
-		AccountName:   azureConfig.accountName,
-		AccountKey:    azureConfig.accountKey,
-		RemotePath:    conf.remotePath,
-		LocalPath:     tmpPath,
-	})
-	if err != nil {
-		utils.Fatal("Error creating SSH storage: %s", err)
-	}
-
-	err = azureStorage.CopyFrom(conf.file)
-	if err != nil {
-		utils.Fatal("Error downloading backup file: %s", err)
-	}
-	RestoreDatabase(db, conf)
 }
@@ -30,14 +30,16 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jkaninda/encryptor"
-	"github.com/jkaninda/go-storage/pkg/local"
 	goutils "github.com/jkaninda/go-utils"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
 	"github.com/jkaninda/mysql-bkup/utils"
 	"github.com/robfig/cron/v3"
 	"github.com/spf13/cobra"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -84,12 +86,19 @@ func scheduledMode(db *dbConfig, config *BackupConfig) {
 	utils.Info("Creating backup job...")
 	// Create a new cron instance
 	c := cron.New()
-
-	_, err = c.AddFunc(config.cronExpression, func() {
-		createBackupTask(db, config)
+	state := loadScheduleState()
+	name, stateName := fmt.Sprintf("the %s database", db.dbName), db.dbName
+	if config.all {
+		name, stateName = "all the databases", "all_databases"
+	}
+	job := newScheduledJob(name, config.overlapPolicy, func() {
+		if createBackupTask(db, config) {
+			state.succeeded(stateName)
+		}
 		utils.Info("Next backup time is: %v", utils.CronNextTime(config.cronExpression).Format(timeFormat))
 
 	})
+	_, err = c.AddJob(config.cronExpression, job)
 	if err != nil {
 		return
 	}
@@ -97,160 +106,198 @@ func scheduledMode(db *dbConfig, config *BackupConfig) {
 	c.Start()
 	utils.Info("Creating backup job...done")
 	utils.Info("Backup job started")
+	if missed, ok := state.catchUp(stateName, config.cronExpression); ok {
+		utils.Info("The backup of %s scheduled at %s was missed, running a catch-up backup", name, missed.Format(timeFormat))
+		go job.Run()
+	}
 	defer c.Stop()
 	select {}
 }
 
-// multiBackupTask backup multi database
-func multiBackupTask(databases []Database, bkConfig *BackupConfig) {
-	for _, db := range databases {
-		// Check if path is defined in config file
-		if db.Path != "" {
-			bkConfig.remotePath = db.Path
-		}
-		createBackupTask(getDatabase(db), bkConfig)
-	}
-}
-
-// createBackupTask backup task
-func createBackupTask(db *dbConfig, config *BackupConfig) {
+// createBackupTask backup task, it returns true when the backups succeeded
+func createBackupTask(db *dbConfig, config *BackupConfig) bool {
 	if config.all && !config.allInOne {
-		backupAll(db, config)
-	} else {
-		if db.dbName == "" && !config.all {
-			utils.Fatal("Database name is required, use DB_NAME environment variable or -d flag")
-		}
-		backupTask(db, config)
+		return backupAll(db, config)
 	}
+	if db.dbName == "" && !config.all {
+		utils.Fatal("Database name is required, use DB_NAME environment variable or -d flag")
+	}
+	return backupTask(db, config)
 }
 
-// backupAll backup all databases
-func backupAll(db *dbConfig, config *BackupConfig) {
+// backupAll backs up all the databases, config.concurrency databases at the same time, it
+// returns true when the backups succeeded
+func backupAll(db *dbConfig, config *BackupConfig) bool {
 	databases, err := listDatabases(*db)
 	if err != nil {
 		utils.Fatal("Error listing databases: %s", err)
 	}
+	var jobs []backupJob
 	for _, dbName := range databases {
 		if dbName == "information_schema" || dbName == "performance_schema" || dbName == "mysql" || dbName == "sys" || dbName == "innodb" || dbName == "Database" {
 			continue
 		}
-		db.dbName = dbName
-		config.backupFileName = fmt.Sprintf("%s_%s.sql.gz", dbName, time.Now().Format("20060102_150405"))
-		backupTask(db, config)
+		jobDb := *db
+		jobDb.dbName = dbName
+		jobs = append(jobs, backupJob{db: &jobDb, config: config})
 	}
+	return runBackupJobs(newBackupPool(config.concurrency), jobs)
+}
 
+// backupTask backs up the database, a failed backup is fatal unless the rescue mode is enabled,
+// it returns true when the backup succeeded
+func backupTask(db *dbConfig, config *BackupConfig) bool {
+	result := storageBackup(db, config)
+	if result.err != nil {
+		backupFailed(result, config)
+		return false
+	}
+	return true
 }
 
-// backupTask backup task
-func backupTask(db *dbConfig, config *BackupConfig) {
-	utils.Info("Starting backup task...")
-	startTime = time.Now()
+// backupFileName returns the name of the backup file of the database
+func backupFileName(db *dbConfig, config *BackupConfig) string {
 	prefix := db.dbName
 	if config.all && config.allInOne {
 		prefix = "all_databases"
 	}
-
-	// Generate file name
-	backupFileName := fmt.Sprintf("%s_%s.sql.gz", prefix, time.Now().Format("20060102_150405"))
+	name := fmt.Sprintf("%s_%s", prefix, time.Now().Format("20060102_150405"))
+	if config.customName != "" && config.allowCustomName && !config.all {
+		name = config.customName
+	}
 	if config.disableCompression {
-		backupFileName = fmt.Sprintf("%s_%s.sql", prefix, time.Now().Format("20060102_150405"))
+		return name + ".sql"
 	}
-	if config.customName != "" && config.allowCustomName && !config.all {
-		backupFileName = fmt.Sprintf("%s.sql.gz", config.customName)
-		if config.disableCompression {
-			backupFileName = fmt.Sprintf("%s.sql", config.customName)
-		}
+	return name + ".sql.gz"
+}
+
+// backupFailed reports a failed backup, the backup of a scheduled backup kept in the outbox is
+// uploaded on the next run, the other failures are fatal unless the rescue mode is enabled
+func backupFailed(result backupResult, config *BackupConfig) {
+	if errors.Is(result.err, errBackupLocked) {
+		utils.Warn("The backup of the %s database is skipped: %v", result.database, result.err)
+		return
 	}
-	config.backupFileName = backupFileName
-	s := strings.ToLower(config.storage)
-	switch s {
-	case "local":
-		localBackup(db, config)
-	case "s3":
-		s3Backup(db, config)
-	case "ssh", "remote", "sftp":
-		sshBackup(db, config)
-	case "ftp":
-		ftpBackup(db, config)
-	case "azure":
-		azureBackup(db, config)
-	default:
-		localBackup(db, config)
+	if errors.Is(result.err, errKeptInOutbox) && config.cronExpression != "" {
+		utils.Error("%s", result.err)
+		utils.NotifyDatabaseError(result.database, result.err.Error())
+		return
 	}
+	recoverMode(result.err, fmt.Sprintf("Error backing up the %s database", result.database))
 }
 
-// startMultiBackup start multi backup
+// startMultiBackup starts the backups of the databases of the config file, a scheduler
+// runs a job for each database with a cron expression
 func startMultiBackup(bkConfig *BackupConfig, configFile string) {
 	utils.Info("Starting Multi backup task...")
-	conf, err := readConf(configFile)
+	conf, err := loadConfig()
 	if err != nil {
 		utils.Fatal("Error reading config file: %s", err)
 	}
+	utils.Info("Config file: %s", configFile)
 	// Check if cronExpression is defined in config file
 	if conf.CronExpression != "" {
 		bkConfig.cronExpression = conf.CronExpression
 	}
+	if conf.Concurrency > 0 && bkConfig.concurrency == 0 {
+		bkConfig.concurrency = conf.Concurrency
+	}
 	if len(conf.Databases) == 0 {
 		utils.Fatal("No databases found")
 	}
-	// Check if cronExpression is defined
-	if bkConfig.cronExpression == "" {
-		multiBackupTask(conf.Databases, bkConfig)
-	} else {
-		backupRescueMode = conf.BackupRescueMode
-		// Check if cronExpression is valid
-		if utils.IsValidCronExpression(bkConfig.cronExpression) {
-			utils.Info("Running backup in Scheduled mode")
-			utils.Info("Backup cron expression:  %s", bkConfig.cronExpression)
-			utils.Info("The next scheduled time is: %v", utils.CronNextTime(bkConfig.cronExpression).Format(timeFormat))
-			utils.Info("Storage type %s ", bkConfig.storage)
-
-			// Test backup
-			utils.Info("Testing backup configurations...")
-			for _, db := range conf.Databases {
-				err = testDatabaseConnection(getDatabase(db))
-				if err != nil {
-					recoverMode(err, fmt.Sprintf("Error connecting to database: %s", db.Name))
-					continue
-				}
-			}
-			utils.Info("Testing backup configurations...done")
-			utils.Info("Creating backup job...")
-			// Create a new cron instance
-			c := cron.New()
-
-			_, err := c.AddFunc(bkConfig.cronExpression, func() {
-				multiBackupTask(conf.Databases, bkConfig)
-				utils.Info("Next backup time is: %v", utils.CronNextTime(bkConfig.cronExpression).Format(timeFormat))
-
-			})
-			if err != nil {
-				return
+	backupRescueMode = conf.BackupRescueMode
+	scheduled := false
+	jobs := make([]backupJob, 0, len(conf.Databases))
+	for _, database := range conf.Databases {
+		database, err = conf.database(database)
+		if err != nil {
+			utils.Fatal("Error reading config file: %s", err)
+		}
+		dbBackupConfig, err := databaseBackupConfig(database, bkConfig)
+		if err != nil {
+			utils.Fatal("Error loading backup config: %s", err)
+		}
+		job := backupJob{db: getDatabase(database), config: dbBackupConfig}
+		if job.config.cronExpression != "" {
+			if !utils.IsValidCronExpression(job.config.cronExpression) {
+				utils.Fatal("Cron expression of the %s database is not valid: %s", database.Name, job.config.cronExpression)
 			}
-			// Start the cron scheduler
-			c.Start()
-			utils.Info("Creating backup job...done")
-			utils.Info("Backup job started")
-			defer c.Stop()
-			select {}
-
-		} else {
-			utils.Fatal("Cron expression is not valid: %s", bkConfig.cronExpression)
+			scheduled = true
 		}
+		jobs = append(jobs, job)
+	}
+	pool := newBackupPool(bkConfig.concurrency)
+	if !scheduled {
+		runBackupJobs(pool, jobs)
+		return
 	}
+	utils.Info("Running backup in Scheduled mode")
 
+	// Test backup
+	utils.Info("Testing backup configurations...")
+	for _, job := range jobs {
+		err = testDatabaseConnection(job.db)
+		if err != nil {
+			recoverMode(err, fmt.Sprintf("Error connecting to database: %s", job.db.dbName))
+			continue
+		}
+	}
+	utils.Info("Testing backup configurations...done")
+	utils.Info("Creating backup jobs...")
+	// Create a new cron instance holding a job for each scheduled database
+	c := cron.New()
+	state := loadScheduleState()
+	var unscheduled []backupJob
+	var catchUps []*scheduledJob
+	for _, job := range jobs {
+		if job.config.cronExpression == "" {
+			unscheduled = append(unscheduled, job)
+			continue
+		}
+		name := fmt.Sprintf("the %s database", job.db.dbName)
+		scheduled := newScheduledJob(name, job.config.overlapPolicy, func() {
+			if result := pool.run(job); result.err != nil {
+				backupFailed(result, job.config)
+			} else {
+				state.succeeded(job.db.dbName)
+			}
+			utils.Info("Next backup time of the %s database is: %v", job.db.dbName, utils.CronNextTime(job.config.cronExpression).Format(timeFormat))
+		})
+		if _, err = c.AddJob(job.config.cronExpression, scheduled); err != nil {
+			utils.Fatal("Error creating backup job of the %s database: %s", job.db.dbName, err)
+		}
+		utils.Info("Backup job of the %s database: cron expression %s, storage %s, next scheduled time %v", job.db.dbName,
+			job.config.cronExpression, job.config.storage, utils.CronNextTime(job.config.cronExpression).Format(timeFormat))
+		if missed, ok := state.catchUp(job.db.dbName, job.config.cronExpression); ok {
+			utils.Info("The backup of %s scheduled at %s was missed, running a catch-up backup", name, missed.Format(timeFormat))
+			catchUps = append(catchUps, scheduled)
+		}
+	}
+	// Start the cron scheduler
+	c.Start()
+	utils.Info("Creating backup jobs...done")
+	utils.Info("Backup jobs started")
+	// The catch-up backups run in the pool with the scheduled backups
+	for _, job := range catchUps {
+		go job.Run()
+	}
+	defer c.Stop()
+	// The databases without a cron expression are backed up once
+	if len(unscheduled) > 0 {
+		runBackupJobs(pool, unscheduled)
+	}
+	select {}
 }
 
-// BackupDatabase backup database
-func BackupDatabase(db *dbConfig, backupFileName string, disableCompression, all, singleFile bool) error {
-	storagePath = os.Getenv("STORAGE_PATH")
+// BackupDatabase backs up the database into a file of the directory dir
+func BackupDatabase(db *dbConfig, dir, backupFileName string, disableCompression, all, singleFile bool) error {
 	utils.Info("Starting database backup...")
 
 	if err := testDatabaseConnection(db); err != nil {
 		return fmt.Errorf("database connection failed: %w", err)
 	}
 
-	dumpArgs := []string{fmt.Sprintf("--defaults-file=%s", mysqlClientConfig)}
+	dumpArgs := append([]string{fmt.Sprintf("--defaults-file=%s", db.clientConfigFile())}, db.dumpOptions...)
 	if all && singleFile {
 		utils.Info("Backing up all databases...")
 		dumpArgs = append(dumpArgs, "--all-databases", "--single-transaction", "--routines", "--triggers")
@@ -259,142 +306,326 @@ func BackupDatabase(db *dbConfig, backupFileName string, disableCompression, all
 		dumpArgs = append(dumpArgs, db.dbName)
 	}
 
-	backupPath := filepath.Join(tmpPath, backupFileName)
+	operation := fmt.Sprintf("Backing up %s", db.dbName)
+	if all && singleFile {
+		operation = "Backing up all databases"
+	}
+	p := newProgress(operation, estimateDatabaseSize(db, all && singleFile), true)
+	backupPath := filepath.Join(dir, backupFileName)
 	if disableCompression {
-		return runCommandAndSaveOutput("mysqldump", dumpArgs, backupPath)
+		return runCommandAndSaveOutput("mysqldump", dumpArgs, backupPath, p, db.dumpLimits)
 	}
-	return runCommandWithCompression("mysqldump", dumpArgs, backupPath)
+	return runCommandWithCompression("mysqldump", dumpArgs, backupPath, p, db.dumpLimits)
 }
 
-// runCommandAndSaveOutput runs a command and saves the output to a file
-func runCommandAndSaveOutput(command string, args []string, outputPath string) error {
-	cmd := exec.Command(command, args...)
-	output, err := cmd.Output()
+// command returns the command run with the nice and ionice priorities of the limits,
+// a priority is ignored with a warning when its tool is not installed
+func (l dumpLimits) command(name string, args ...string) *exec.Cmd {
+	if l.nice != "" {
+		if _, err := exec.LookPath("nice"); err == nil {
+			args = append([]string{"-n", l.nice, name}, args...)
+			name = "nice"
+		} else {
+			utils.Warn("nice is not installed, the dump priority is ignored")
+		}
+	}
+	if l.ioniceClass != "" {
+		if _, err := exec.LookPath("ionice"); err == nil {
+			prefix := []string{"-c", l.ioniceClass}
+			if l.ioniceLevel != "" && l.ioniceClass != "3" {
+				prefix = append(prefix, "-n", l.ioniceLevel)
+			}
+			args = append(append(prefix, name), args...)
+			name = "ionice"
+		} else {
+			utils.Warn("ionice is not installed, the dump I/O priority is ignored")
+		}
+	}
+	return exec.Command(name, args...)
+}
+
+// estimateDatabaseSize returns the data size of the database, it's used to estimate the progress of the dump
+func estimateDatabaseSize(db *dbConfig, all bool) int64 {
+	query := "SELECT COALESCE(SUM(DATA_LENGTH), 0) FROM information_schema.TABLES"
+	if !all {
+		query = fmt.Sprintf("%s WHERE TABLE_SCHEMA = %s", query, quoteString(db.dbName))
+	}
+	rows, err := queryClientSQL(db.clientConfigFile(), query)
+	if err != nil || len(rows) == 0 {
+		return 0
+	}
+	size, err := strconv.ParseInt(rows[0][0], 10, 64)
 	if err != nil {
-		return fmt.Errorf("failed to execute %s: %v, output: %s", command, err, string(output))
+		return 0
 	}
+	return size
+}
 
-	return os.WriteFile(outputPath, output, 0644)
+// runCommandAndSaveOutput runs a command and saves the output to a file
+func runCommandAndSaveOutput(command string, args []string, outputPath string, p *progress, limits dumpLimits) error {
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer func(outputFile *os.File) {
+		err := outputFile.Close()
+		if err != nil {
+			utils.Error("Error closing output file: %v", err)
+		}
+	}(outputFile)
+
+	var stderr bytes.Buffer
+	cmd := limits.command(command, args...)
+	cmd.Stdout = storage.LimitWriter(io.MultiWriter(outputFile, p.byteCounter(), p.sqlScanner()), storage.NewLimiter(limits.rateLimit))
+	cmd.Stderr = &stderr
+	p.start()
+	err = cmd.Run()
+	p.finish()
+	if err != nil {
+		return fmt.Errorf("failed to execute %s: %v, output: %s", command, err, stderr.String())
+	}
+	return nil
 }
 
 // runCommandWithCompression runs a command and compresses the output
-func runCommandWithCompression(command string, args []string, outputPath string) error {
-	cmd := exec.Command(command, args...)
+func runCommandWithCompression(command string, args []string, outputPath string, p *progress, limits dumpLimits) error {
+	cmd := limits.command(command, args...)
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
 
-	gzipCmd := exec.Command("gzip")
-	gzipCmd.Stdin = stdout
+	gzipCmd := limits.command("gzip")
+	gzipCmd.Stdin = storage.LimitReader(io.TeeReader(stdout, io.MultiWriter(p.byteCounter(), p.sqlScanner())), storage.NewLimiter(limits.rateLimit))
 	gzipFile, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("failed to create gzip file: %w", err)
 	}
 	defer func(gzipFile *os.File) {
 		err := gzipFile.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, os.ErrClosed) {
 			utils.Error("Error closing gzip file: %v", err)
 		}
 	}(gzipFile)
 	gzipCmd.Stdout = gzipFile
-
-	if err := gzipCmd.Start(); err != nil {
-		return fmt.Errorf("failed to start gzip: %w", err)
+	// removeOutput deletes the partial backup of a failed dump
+	removeOutput := func() {
+		_ = gzipFile.Close()
+		_ = os.Remove(outputPath)
 	}
-	if err := cmd.Run(); err != nil {
+
+	if err := cmd.Start(); err != nil {
+		removeOutput()
 		return fmt.Errorf("failed to execute %s: %w", command, err)
 	}
-	if err := gzipCmd.Wait(); err != nil {
-		return fmt.Errorf("failed to wait for gzip completion: %w", err)
+	p.start()
+	if err := gzipCmd.Run(); err != nil {
+		p.finish()
+		// The dump would block on the pipe nobody reads anymore
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		removeOutput()
+		return fmt.Errorf("failed to run gzip: %w", err)
+	}
+	p.finish()
+	if err := cmd.Wait(); err != nil {
+		removeOutput()
+		return fmt.Errorf("failed to execute %s: %w", command, err)
 	}
 
 	utils.Info("Database has been backed up")
 	return nil
 }
 
-// localBackup backup database to local storage
-func localBackup(db *dbConfig, config *BackupConfig) {
-	utils.Info("Backup database to local storage")
-	err := BackupDatabase(db, config.backupFileName, disableCompression, config.all, config.allInOne)
+// storageBackup backs up the database and uploads the backup to the configured storage
+func storageBackup(db *dbConfig, config *BackupConfig) backupResult {
+	utils.Info("Starting backup task...")
+	result := backupResult{database: db.dbName}
+	startTime := time.Now()
+	config.backupFileName = backupFileName(db, config)
+	lockName := db.dbName
+	if config.all && config.allInOne {
+		lockName = "all_databases"
+	}
+	release, err := acquireBackupLock(db, config, lockName)
 	if err != nil {
-		recoverMode(err, "Error backing up database")
-		return
+		result.err = err
+		result.duration = time.Since(startTime)
+		return result
+	}
+	defer release()
+	result.err = uploadBackup(db, config, &result)
+	result.duration = time.Since(startTime)
+	if result.err == nil {
+		utils.Info("The backup of the %s database has been completed in %s", db.dbName, goutils.FormatDuration(result.duration, 0))
+	}
+	return result
+}
+
+// uploadBackup backs up the database into the temporary directory of the backup, then encrypts,
+// splits, signs and uploads the backup files
+func uploadBackup(db *dbConfig, config *BackupConfig, result *backupResult) error {
+	utils.Info("Backup database to %s storage", config.storage)
+	startTime := time.Now()
+	dir := config.workDir()
+	bkStorage, err := newStorageIn(dir, config.storage, config.remotePath)
+	if err != nil {
+		return fmt.Errorf("error creating %s storage: %w", config.storage, err)
+	}
+	tags := backupTags(db, config)
+	if tagger, ok := bkStorage.(storage.Tagger); ok {
+		tagger.SetTags(tags)
+	}
+	flushOutbox()
+	err = BackupDatabase(db, dir, config.backupFileName, config.disableCompression, config.all, config.allInOne)
+	if err != nil {
+		return fmt.Errorf("error backing up database: %w", err)
 	}
 	finalFileName := config.backupFileName
 	if config.encryption {
-		encryptBackup(config)
-		finalFileName = fmt.Sprintf("%s.%s", config.backupFileName, gpgExtension)
+		if finalFileName, err = encryptFile(config, config.backupFileName); err != nil {
+			return fmt.Errorf("error encrypting backup file: %w", err)
+		}
 	}
-	fileInfo, err := os.Stat(filepath.Join(tmpPath, finalFileName))
+	fileInfo, err := os.Stat(filepath.Join(dir, finalFileName))
 	if err != nil {
-		utils.Error("Error: %s", err)
+		return err
 	}
-	backupSize = fileInfo.Size()
-	localStorage := local.NewStorage(local.Config{
-		LocalPath:  tmpPath,
-		RemotePath: storagePath,
-	})
-	err = localStorage.Copy(finalFileName)
+	result.file = finalFileName
+	result.size = fileInfo.Size()
+	manifestFile, err := writeManifest(db, config, finalFileName)
 	if err != nil {
-		utils.Fatal("Error copying backup file: %s", err)
+		return fmt.Errorf("error creating backup manifest: %w", err)
+	}
+	files := []string{finalFileName}
+	if config.splitSize > 0 && result.size > config.splitSize {
+		files, err = splitBackup(dir, finalFileName, config.splitSize)
+		if err != nil {
+			return fmt.Errorf("error splitting backup: %w", err)
+		}
 	}
+	files = append(files, manifestFile)
+	if config.signingKey != nil {
+		signatureFile, err := signManifest(dir, config.signingKey, finalFileName)
+		if err != nil {
+			return fmt.Errorf("error signing backup manifest: %w", err)
+		}
+		files = append(files, signatureFile)
+	}
+
+	utils.Info("Uploading backup archive to %s storage ...", bkStorage.Name())
+	for _, name := range files {
+		if err = bkStorage.Copy(name); err != nil {
+			return uploadFailed(config, files, tags, err)
+		}
+	}
+	utils.Info("Uploading backup archive to %s storage ... done", bkStorage.Name())
+	result.location = storageLocation(bkStorage, config.storage, config.remotePath, finalFileName)
 	utils.Info("Backup name is %s", finalFileName)
-	utils.Info("Backup size: %s", utils.ConvertBytes(uint64(backupSize)))
-	utils.Info("Backup saved in %s", filepath.Join(storagePath, finalFileName))
-	duration := goutils.FormatDuration(time.Since(startTime), 0)
+	utils.Info("Backup size: %s", utils.ConvertBytes(uint64(result.size)))
+	utils.Info("Backup saved in %s", result.location)
 
 	// Send notification
 	utils.NotifySuccess(&utils.NotificationData{
 		File:           finalFileName,
-		BackupSize:     utils.ConvertBytes(uint64(backupSize)),
+		BackupSize:     utils.ConvertBytes(uint64(result.size)),
 		Database:       db.dbName,
 		Storage:        config.storage,
-		BackupLocation: filepath.Join(storagePath, finalFileName),
-		Duration:       duration,
+		BackupLocation: result.location,
+		Duration:       goutils.FormatDuration(time.Since(startTime), 0),
 	})
 	// Delete old backup
 	if config.prune {
-		err = localStorage.Prune(config.backupRetention)
-		if err != nil {
-			utils.Fatal("Error deleting old backup from %s storage: %s ", config.storage, err)
+		err = bkStorage.Prune(config.backupRetention)
+		if errors.Is(err, storage.ErrNotSupported) {
+			utils.Warn("Old backups are not deleted from %s storage: %v", config.storage, err)
+		} else if errors.Is(err, storage.ErrRetained) {
+			utils.Warn("Old backups retained by %s storage are not deleted yet: %v", config.storage, err)
+		} else if err != nil {
+			return fmt.Errorf("error deleting old backup from %s storage: %w", config.storage, err)
 		}
-
 	}
 	// Delete temp
-	deleteTemp()
-	utils.Info("The backup of the %s database has been completed in %s", db.dbName, duration)
+	deleteTempDir(dir)
+	return nil
 }
 
-// encryptBackup encrypt backup
-func encryptBackup(config *BackupConfig) {
-	backupFile, err := os.ReadFile(filepath.Join(tmpPath, config.backupFileName))
-	outputFile := fmt.Sprintf("%s.%s", filepath.Join(tmpPath, config.backupFileName), gpgExtension)
-	if err != nil {
-		utils.Fatal("Error reading backup file: %s ", err)
+// uploadFailed keeps the backup in the outbox when it's enabled, the upload is attempted again on
+// the next run
+func uploadFailed(config *BackupConfig, files []string, tags map[string]string, err error) error {
+	if outboxDir() == "" {
+		return fmt.Errorf("error copying backup file: %w", err)
+	}
+	if outboxErr := saveToOutbox(config, files, tags); outboxErr != nil {
+		return fmt.Errorf("error copying backup file: %w, and saving it to the outbox: %v", err, outboxErr)
+	}
+	deleteTempDir(config.workDir())
+	return fmt.Errorf("error copying backup file: %w, %w %s", err, errKeptInOutbox, outboxDir())
+}
+
+// backupTags returns the tags of the uploaded backup files: database, reference and mode
+func backupTags(db *dbConfig, config *BackupConfig) map[string]string {
+	tags := map[string]string{
+		"database": db.dbName,
+		"mode":     "manual",
+	}
+	if config.all && config.allInOne {
+		tags["database"] = "all_databases"
 	}
+	if config.cronExpression != "" {
+		tags["mode"] = "scheduled"
+	}
+	if reference := os.Getenv("BACKUP_REFERENCE"); reference != "" {
+		tags["reference"] = reference
+	}
+	return tags
+}
+
+// encryptFile encrypts a file of the temporary directory for the age recipients, the gpg public keys
+// or with the gpg passphrase, and returns the name of the encrypted file
+func encryptFile(config *BackupConfig, fileName string) (string, error) {
+	dir := config.workDir()
+	if config.encryptionMethod == encryptionAge {
+		utils.Info("Encrypting backup using age...")
+		outputName := fmt.Sprintf("%s.%s", fileName, ageExtension)
+		if err := ageEncrypt(dir, fileName, outputName, config.ageRecipients); err != nil {
+			return "", err
+		}
+		utils.Info("Encrypting backup using age...done")
+		return outputName, nil
+	}
+	if config.encryptionMethod == encryptionEnvelope {
+		utils.Info("Encrypting backup using %s key provider...", config.keyProvider.Name())
+		outputName := fmt.Sprintf("%s.%s", fileName, envelopeExtension)
+		if err := envelopeEncrypt(dir, fileName, outputName, config.keyProvider); err != nil {
+			return "", err
+		}
+		utils.Info("Encrypting backup using %s key provider...done, key %s", config.keyProvider.Name(), config.keyProvider.KeyID())
+		return outputName, nil
+	}
+	outputName := fmt.Sprintf("%s.%s", fileName, gpgExtension)
 	if config.usingKey {
 		utils.Info("Encrypting backup using public key...")
-		pubKey, err := os.ReadFile(config.publicKey)
-		if err != nil {
-			utils.Fatal("Error reading public key: %s ", err)
-		}
-		err = encryptor.EncryptWithPublicKey(backupFile, fmt.Sprintf("%s.%s", filepath.Join(tmpPath, config.backupFileName), gpgExtension), pubKey)
+		keyRing, err := loadGPGKeyRing(config.publicKeys, "")
 		if err != nil {
-			utils.Fatal("Error encrypting backup file: %v ", err)
+			return "", fmt.Errorf("error reading public keys: %w", err)
 		}
-		utils.Info("Encrypting backup using public key...done")
-
-	} else if config.passphrase != "" {
-		utils.Info("Encrypting backup using passphrase...")
-		err := encryptor.Encrypt(backupFile, outputFile, config.passphrase)
-		if err != nil {
-			utils.Fatal("error during encrypting backup %v", err)
+		if err = gpgEncrypt(dir, fileName, outputName, keyRing); err != nil {
+			return "", err
 		}
-		utils.Info("Encrypting backup using passphrase...done")
-
+		utils.Info("Encrypting backup using public key...done, %d recipients", keyRing.CountEntities())
+		return outputName, nil
 	}
-
+	utils.Info("Encrypting backup using passphrase...")
+	backupFile, err := os.ReadFile(filepath.Join(dir, fileName))
+	if err != nil {
+		return "", err
+	}
+	if err = encryptor.Encrypt(backupFile, filepath.Join(dir, outputName), config.passphrase); err != nil {
+		return "", err
+	}
+	utils.Info("Encrypting backup using passphrase...done")
+	return outputName, nil
 }
 
 // listDatabases list all databases
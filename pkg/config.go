@@ -25,12 +25,21 @@ SOFTWARE.
 package pkg
 
 import (
+	"encoding/base64"
+	"filippo.io/age"
 	"fmt"
+	goutils "github.com/jkaninda/go-utils"
+	"github.com/jkaninda/mysql-bkup/pkg/envelope"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/pkg/storage/ftp"
+	"github.com/jkaninda/mysql-bkup/pkg/storage/s3"
 	"github.com/jkaninda/mysql-bkup/utils"
 	"github.com/spf13/cobra"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Database struct {
@@ -40,19 +49,89 @@ type Database struct {
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 	Path     string `yaml:"path"`
+	// DumpRateLimit, DumpNice, DumpIoniceClass and DumpIoniceLevel throttle the dump of the database
+	DumpRateLimit   string `yaml:"dumpRateLimit"`
+	DumpNice        string `yaml:"dumpNice"`
+	DumpIoniceClass string `yaml:"dumpIoniceClass"`
+	DumpIoniceLevel string `yaml:"dumpIoniceLevel"`
+	// DumpOptions are additional mysqldump options, e.g. "--single-transaction --quick"
+	DumpOptions string `yaml:"dumpOptions"`
+	// CronExpression, Storage, BackupRetentionDays, DisableCompression and the encryption
+	// settings override the global settings for the database, Storage is a storage type or
+	// a storage profile and Encryption an encryption method or an encryption profile
+	CronExpression      string   `yaml:"cronExpression"`
+	Storage             string   `yaml:"storage"`
+	BackupRetentionDays int      `yaml:"backupRetentionDays"`
+	DisableCompression  *bool    `yaml:"disableCompression"`
+	Encryption          string   `yaml:"encryption"`
+	GPGPublicKey        string   `yaml:"gpgPublicKey"`
+	GPGPassphrase       string   `yaml:"gpgPassphrase"`
+	AgeRecipients       []string `yaml:"ageRecipients"`
+	KeyProvider         string   `yaml:"keyProvider"`
+	// OverlapPolicy is the policy of a scheduled backup due while the previous one is still running
+	OverlapPolicy string `yaml:"overlapPolicy"`
 }
+
+// Config is the config file, it describes the databases with their storages, encryption and
+// notifications, the environment variables override its settings
 type Config struct {
-	CronExpression   string     `yaml:"cronExpression"`
-	BackupRescueMode bool       `yaml:"backupRescueMode"`
-	Databases        []Database `yaml:"databases"`
+	// Version is the version of the schema, see configVersion
+	Version          int    `yaml:"version"`
+	CronExpression   string `yaml:"cronExpression"`
+	BackupRescueMode bool   `yaml:"backupRescueMode"`
+	// Concurrency is the number of databases backed up at the same time
+	Concurrency int        `yaml:"concurrency"`
+	Databases   []Database `yaml:"databases"`
+	S3          S3Options  `yaml:"s3"`
+	// Defaults are the settings of the databases which don't set them
+	Defaults      Database                     `yaml:"defaults"`
+	Storages      map[string]StorageProfile    `yaml:"storages"`
+	Encryption    map[string]EncryptionProfile `yaml:"encryption"`
+	Notifications Notifications                `yaml:"notifications"`
+	// Env sets environment variables, e.g. BACKUP_REFERENCE or TZ
+	Env map[string]string `yaml:"env"`
+}
+
+// S3Options holds the S3 upload options of the config file, environment variables override them
+type S3Options struct {
+	SSE            string `yaml:"sse"`
+	SSEKMSKeyID    string `yaml:"sseKmsKeyId"`
+	SSECustomerKey string `yaml:"sseCustomerKey"`
+	StorageClass   string `yaml:"storageClass"`
+	ObjectLock     struct {
+		Mode      string `yaml:"mode"`
+		Retention string `yaml:"retention"`
+		LegalHold bool   `yaml:"legalHold"`
+	} `yaml:"objectLock"`
+	Tags map[string]string `yaml:"tags"`
 }
 
 type dbConfig struct {
-	dbHost     string
-	dbPort     string
-	dbName     string
-	dbUserName string
-	dbPassword string
+	dbHost      string
+	dbPort      string
+	dbName      string
+	dbUserName  string
+	dbPassword  string
+	dumpLimits  dumpLimits
+	dumpOptions []string
+	// clientConfig is the mysql client config file, the jobs of a backup pool have their own
+	clientConfig string
+}
+
+// clientConfigFile returns the mysql client config file of the database
+func (db *dbConfig) clientConfigFile() string {
+	if db.clientConfig != "" {
+		return db.clientConfig
+	}
+	return mysqlClientConfig
+}
+
+// dumpLimits throttles the mysqldump and compression processes
+type dumpLimits struct {
+	rateLimit   int64
+	nice        string
+	ioniceClass string
+	ioniceLevel string
 }
 type targetDbConfig struct {
 	targetDbHost     string
@@ -72,46 +151,112 @@ type BackupConfig struct {
 	prune              bool
 	remotePath         string
 	encryption         bool
+	encryptionMethod   string
+	ageRecipients      []age.Recipient
+	keyProvider        envelope.KeyProvider
+	signingKey         *signingKey
 	usingKey           bool
 	passphrase         string
-	publicKey          string
+	publicKeys         []string
 	storage            string
 	cronExpression     string
 	all                bool
 	allInOne           bool
 	customName         string
 	allowCustomName    bool
+	splitSize          int64
+	// concurrency is the number of databases backed up at the same time
+	concurrency int
+	// tmpDir is the temporary directory of the backup files, the jobs of a backup pool have their own
+	tmpDir string
+	// overlapPolicy is the policy of a scheduled backup due while the previous one is still running
+	overlapPolicy string
+	// lockMode and lockDir lock the backups of a database shared by several instances
+	lockMode string
+	lockDir  string
+}
+
+// workDir returns the temporary directory of the backup files
+func (c *BackupConfig) workDir() string {
+	if c.tmpDir != "" {
+		return c.tmpDir
+	}
+	return tmpPath
 }
+
 type FTPConfig struct {
-	host       string
-	user       string
-	password   string
-	port       int
-	remotePath string
+	host               string
+	user               string
+	password           string
+	port               int
+	remotePath         string
+	tls                string
+	caCert             string
+	insecureSkipVerify bool
+	mode               string
+	disableEPSV        bool
 }
 type AzureConfig struct {
-	accountName   string
-	accountKey    string
-	containerName string
+	accountName      string
+	accountKey       string
+	connectionString string
+	sasToken         string
+	endpoint         string
+	containerName    string
+	accessTier       string
+}
+type GCSConfig struct {
+	bucketName string
+	endpoint   string
+}
+type WebDAVConfig struct {
+	url       string
+	user      string
+	password  string
+	token     string
+	caCert    string
+	chunkSize int64
+}
+type RcloneConfig struct {
+	remote     string
+	configFile string
+	flags      []string
 }
 
 // SSHConfig holds the SSH connection details
 type SSHConfig struct {
-	user         string
-	password     string
-	hostName     string
-	port         int
-	identifyFile string
+	user                  string
+	password              string
+	hostName              string
+	port                  int
+	identifyFile          string
+	passphrase            string
+	agentSocket           string
+	knownHostsFiles       []string
+	hostKeyFingerprints   []string
+	strictHostKeyChecking bool
+	jumpHosts             []string
 }
 type AWSConfig struct {
-	endpoint       string
-	bucket         string
-	accessKey      string
-	secretKey      string
-	region         string
-	remotePath     string
-	disableSsl     bool
-	forcePathStyle bool
+	endpoint            string
+	bucket              string
+	accessKey           string
+	secretKey           string
+	region              string
+	remotePath          string
+	disableSsl          bool
+	forcePathStyle      bool
+	roleARN             string
+	externalID          string
+	roleSessionName     string
+	sse                 string
+	sseKmsKeyID         string
+	sseCustomerKey      string
+	storageClass        string
+	objectLockMode      string
+	objectLockRetention time.Duration
+	legalHold           bool
+	tags                map[string]string
 }
 
 func initDbConfig(cmd *cobra.Command) *dbConfig {
@@ -123,6 +268,8 @@ func initDbConfig(cmd *cobra.Command) *dbConfig {
 	dConf.dbName = os.Getenv("DB_NAME")
 	dConf.dbUserName = os.Getenv("DB_USERNAME")
 	dConf.dbPassword = os.Getenv("DB_PASSWORD")
+	dConf.dumpLimits = loadDumpLimits(Database{})
+	dConf.dumpOptions = strings.Fields(os.Getenv("DUMP_OPTIONS"))
 
 	err := utils.CheckEnvVars(dbHVars)
 	if err != nil {
@@ -139,19 +286,210 @@ func getDatabase(database Database) *dbConfig {
 	database.Host = getEnvOrDefault(database.Host, "DB_HOST", database.Name, "")
 	database.Port = getEnvOrDefault(database.Port, "DB_PORT", database.Name, "3306")
 	return &dbConfig{
-		dbHost:     database.Host,
-		dbPort:     database.Port,
-		dbName:     database.Name,
-		dbUserName: database.User,
-		dbPassword: database.Password,
+		dbHost:      database.Host,
+		dbPort:      database.Port,
+		dbName:      database.Name,
+		dbUserName:  database.User,
+		dbPassword:  database.Password,
+		dumpLimits:  loadDumpLimits(database),
+		dumpOptions: strings.Fields(getEnvOrDefault(database.DumpOptions, "DUMP_OPTIONS", database.Name, "")),
+	}
+}
+
+// databaseBackupConfig returns the backup config of a database of the config file, the
+// settings of the database override the global ones
+func databaseBackupConfig(database Database, config *BackupConfig) (*BackupConfig, error) {
+	dbBackupConfig := *config
+	if database.Path != "" {
+		dbBackupConfig.remotePath = database.Path
+	}
+	if database.Storage != "" {
+		dbBackupConfig.storage = database.Storage
+	}
+	if database.CronExpression != "" {
+		dbBackupConfig.cronExpression = database.CronExpression
+	}
+	if database.BackupRetentionDays > 0 {
+		dbBackupConfig.backupRetention = database.BackupRetentionDays
+		dbBackupConfig.prune = true
+	}
+	if database.DisableCompression != nil {
+		dbBackupConfig.disableCompression = *database.DisableCompression
+	}
+	if database.OverlapPolicy != "" {
+		policy, err := parseOverlapPolicy(database.OverlapPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid settings of the %s database: %w", database.Name, err)
+		}
+		dbBackupConfig.overlapPolicy = policy
+	}
+	if err := loadDatabaseEncryption(database, &dbBackupConfig); err != nil {
+		return nil, err
+	}
+	return &dbBackupConfig, nil
+}
+
+// loadDatabaseEncryption applies the encryption settings of a database of the config file,
+// the global encryption settings are kept when the database has none
+func loadDatabaseEncryption(database Database, config *BackupConfig) error {
+	method := strings.ToLower(database.Encryption)
+	if method == "" && database.GPGPublicKey == "" && database.GPGPassphrase == "" && len(database.AgeRecipients) == 0 && database.KeyProvider == "" {
+		return nil
+	}
+	if method == "none" {
+		config.encryption = false
+		return nil
+	}
+	var err error
+	if len(database.AgeRecipients) > 0 {
+		config.ageRecipients = make([]age.Recipient, 0, len(database.AgeRecipients))
+		for _, value := range database.AgeRecipients {
+			recipient, err := parseAgeRecipient(value)
+			if err != nil {
+				return fmt.Errorf("invalid age recipient of the %s database: %w", database.Name, err)
+			}
+			config.ageRecipients = append(config.ageRecipients, recipient)
+		}
+	}
+	if database.GPGPublicKey != "" {
+		config.publicKeys, err = checkPubKeyFiles(database.GPGPublicKey)
+		if err != nil {
+			return fmt.Errorf("error loading GPG public keys of the %s database: %w", database.Name, err)
+		}
+	}
+	if database.GPGPassphrase != "" {
+		if config.passphrase, err = resolveSecret(database.GPGPassphrase); err != nil {
+			return fmt.Errorf("error resolving the GPG passphrase of the %s database: %w", database.Name, err)
+		}
+	}
+	if method == "" {
+		method = encryptionGPG
+		if len(database.AgeRecipients) > 0 {
+			method = encryptionAge
+		} else if database.KeyProvider != "" {
+			method = encryptionEnvelope
+		}
+	}
+	switch method {
+	case encryptionAge:
+		if len(config.ageRecipients) == 0 {
+			return fmt.Errorf("ageRecipients required for the age encryption of the %s database", database.Name)
+		}
+	case encryptionGPG:
+		config.usingKey = len(config.publicKeys) > 0
+		if !config.usingKey && config.passphrase == "" {
+			return fmt.Errorf("gpgPassphrase or gpgPublicKey required for the gpg encryption of the %s database", database.Name)
+		}
+	case encryptionEnvelope:
+		if database.KeyProvider != "" {
+			if config.keyProvider, err = loadKeyProvider(database.KeyProvider, ""); err != nil {
+				return fmt.Errorf("error loading key provider of the %s database: %w", database.Name, err)
+			}
+		} else if config.keyProvider == nil {
+			if config.keyProvider, err = loadKeyProvider(os.Getenv("KEY_PROVIDER"), ""); err != nil {
+				return fmt.Errorf("error loading key provider of the %s database: %w", database.Name, err)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid encryption method %q of the %s database, expected age, gpg, envelope or none", method, database.Name)
+	}
+	config.encryption = true
+	config.encryptionMethod = method
+	return nil
+}
+
+// loadDumpLimits loads the dump limits of a database, environment variables suffixed with
+// the database name override the global ones
+func loadDumpLimits(database Database) dumpLimits {
+	limits, err := parseDumpLimits(database)
+	if err != nil {
+		utils.Fatal("%v", err)
+	}
+	return limits
+}
+
+// parseDumpLimits parses the dump limits of a database
+func parseDumpLimits(database Database) (dumpLimits, error) {
+	rateLimit, err := parseRate(getEnvOrDefault(database.DumpRateLimit, "DUMP_RATE_LIMIT", database.Name, ""))
+	if err != nil {
+		return dumpLimits{}, fmt.Errorf("invalid dump rate limit of %s: %w", database.Name, err)
+	}
+	limits := dumpLimits{
+		rateLimit:   rateLimit,
+		nice:        getEnvOrDefault(database.DumpNice, "DUMP_NICE", database.Name, ""),
+		ioniceClass: getEnvOrDefault(database.DumpIoniceClass, "DUMP_IONICE_CLASS", database.Name, ""),
+		ioniceLevel: getEnvOrDefault(database.DumpIoniceLevel, "DUMP_IONICE_LEVEL", database.Name, ""),
+	}
+	if limits.nice != "" {
+		if nice, err := strconv.Atoi(limits.nice); err != nil || nice < -20 || nice > 19 {
+			return dumpLimits{}, fmt.Errorf("invalid dump nice value %q, expected a number between -20 and 19", limits.nice)
+		}
+	}
+	switch strings.ToLower(limits.ioniceClass) {
+	case "", "1", "2", "3":
+	case "realtime":
+		limits.ioniceClass = "1"
+	case "best-effort":
+		limits.ioniceClass = "2"
+	case "idle":
+		limits.ioniceClass = "3"
+	default:
+		return dumpLimits{}, fmt.Errorf("invalid dump ionice class %q, expected realtime, best-effort or idle", limits.ioniceClass)
+	}
+	if limits.ioniceLevel != "" {
+		if level, err := strconv.Atoi(limits.ioniceLevel); err != nil || level < 0 || level > 7 {
+			return dumpLimits{}, fmt.Errorf("invalid dump ionice level %q, expected a number between 0 and 7", limits.ioniceLevel)
+		}
+		if limits.ioniceClass == "" {
+			limits.ioniceClass = "2"
+		}
+	}
+	return limits, nil
+}
+
+// loadRateLimits sets the upload and download rate limits of the storages
+func loadRateLimits(cmd *cobra.Command) {
+	upload, err := parseRate(utils.GetEnv(cmd, "upload-rate-limit", "UPLOAD_RATE_LIMIT"))
+	if err != nil {
+		utils.Fatal("Invalid upload rate limit: %v", err)
+	}
+	download, err := parseRate(utils.GetEnv(cmd, "download-rate-limit", "DOWNLOAD_RATE_LIMIT"))
+	if err != nil {
+		utils.Fatal("Invalid download rate limit: %v", err)
+	}
+	storage.SetRateLimits(upload, download)
+}
+
+// parseRate parses a rate in bytes per second, e.g: 20MiB/s, 500KB or 1048576, empty means no limit
+func parseRate(value string) (int64, error) {
+	return parseSize(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+}
+
+// parseSize parses a size in bytes, e.g: 2GiB, 500MB or 1048576, empty means zero
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return size, nil
+	}
+	size, err := goutils.ConvertToBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q: %w", value, err)
 	}
+	return size, nil
 }
 
 // Helper function to get environment variable or use a default value
 func getEnvOrDefault(currentValue, envKey, suffix, defaultValue string) string {
-	// Return the current value if it's already set
+	// Return the current value if it's already set, a secret reference is resolved
 	if currentValue != "" {
-		return currentValue
+		value, err := resolveSecret(currentValue)
+		if err != nil {
+			utils.Fatal("Error resolving %s of the %s database: %v", envKey, suffix, err)
+		}
+		return value
 	}
 
 	// Check for suffixed or prefixed environment variables if a suffix is provided
@@ -172,88 +510,263 @@ func getEnvOrDefault(currentValue, envKey, suffix, defaultValue string) string {
 	return utils.EnvWithDefault(envKey, defaultValue)
 }
 
-// loadSSHConfig loads the SSH configuration from environment variables
-func loadSSHConfig() (*SSHConfig, error) {
-	utils.GetEnvVariable("SSH_HOST", "SSH_HOST_NAME")
-	sshVars := []string{"SSH_USER", "SSH_HOST", "SSH_PORT", "REMOTE_PATH"}
-	err := utils.CheckEnvVars(sshVars)
+// loadSSHConfig loads the SSH configuration from the storage settings
+func loadSSHConfig(env storageEnv) (*SSHConfig, error) {
+	if err := env.check(sshVars); err != nil {
+		return nil, err
+	}
+	port, err := env.intValue("SSH_PORT")
 	if err != nil {
-		return nil, fmt.Errorf("error missing environment variables: %w", err)
+		return nil, err
 	}
 
-	return &SSHConfig{
-		user:         os.Getenv("SSH_USER"),
-		password:     os.Getenv("SSH_PASSWORD"),
-		hostName:     os.Getenv("SSH_HOST"),
-		port:         utils.GetIntEnv("SSH_PORT"),
-		identifyFile: os.Getenv("SSH_IDENTIFY_FILE"),
-	}, nil
+	sshConfig := &SSHConfig{
+		user:                env.get("SSH_USER"),
+		password:            env.get("SSH_PASSWORD"),
+		hostName:            env.variable("SSH_HOST", "SSH_HOST_NAME"),
+		port:                port,
+		identifyFile:        env.get("SSH_IDENTIFY_FILE"),
+		passphrase:          env.get("SSH_IDENTIFY_FILE_PASSPHRASE"),
+		knownHostsFiles:     splitList(env.get("SSH_KNOWN_HOSTS")),
+		hostKeyFingerprints: splitList(env.get("SSH_HOST_KEY_FINGERPRINT")),
+		jumpHosts:           splitList(env.get("SSH_JUMP_HOSTS")),
+	}
+	// Host keys are checked strictly by default once a known hosts file or a fingerprint is set
+	strict := len(sshConfig.knownHostsFiles) > 0 || len(sshConfig.hostKeyFingerprints) > 0
+	sshConfig.strictHostKeyChecking = strings.EqualFold(env.withDefault("SSH_STRICT_HOST_KEY_CHECKING", strconv.FormatBool(strict)), "true")
+	if strings.EqualFold(env.withDefault("SSH_USE_AGENT", "true"), "true") {
+		sshConfig.agentSocket = env.get("SSH_AUTH_SOCK")
+	}
+	if sshConfig.strictHostKeyChecking && len(sshConfig.knownHostsFiles) == 0 {
+		if home, err := os.UserHomeDir(); err == nil && utils.FileExists(filepath.Join(home, ".ssh", "known_hosts")) {
+			sshConfig.knownHostsFiles = []string{filepath.Join(home, ".ssh", "known_hosts")}
+		}
+	}
+	return sshConfig, nil
 }
-func loadFtpConfig() *FTPConfig {
-	// Initialize data configs
-	fConfig := FTPConfig{}
-	fConfig.host = utils.GetEnvVariable("FTP_HOST", "FTP_HOST_NAME")
-	fConfig.user = os.Getenv("FTP_USER")
-	fConfig.password = os.Getenv("FTP_PASSWORD")
-	fConfig.port = utils.GetIntEnv("FTP_PORT")
-	fConfig.remotePath = os.Getenv("REMOTE_PATH")
-	err := utils.CheckEnvVars(ftpVars)
+
+// splitList splits a comma separated list, ignoring empty values
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// loadFtpConfig loads the FTP configuration from the storage settings
+func loadFtpConfig(env storageEnv) (*FTPConfig, error) {
+	if err := env.check(ftpVars); err != nil {
+		return nil, err
+	}
+	port, err := env.intValue("FTP_PORT")
 	if err != nil {
-		utils.Error("Please make sure all required environment variables for FTP are set")
-		utils.Fatal("Error missing environment variables: %s", err)
+		return nil, err
 	}
-	return &fConfig
+	fConfig := FTPConfig{}
+	fConfig.host = env.variable("FTP_HOST", "FTP_HOST_NAME")
+	fConfig.user = env.get("FTP_USER")
+	fConfig.password = env.get("FTP_PASSWORD")
+	fConfig.port = port
+	fConfig.remotePath = env.get("REMOTE_PATH")
+	fConfig.caCert = env.get("FTP_CA_CERT")
+	fConfig.insecureSkipVerify = strings.EqualFold(env.get("FTP_TLS_SKIP_VERIFY"), "true")
+	fConfig.disableEPSV = strings.EqualFold(env.get("FTP_DISABLE_EPSV"), "true")
+	switch strings.ToLower(env.get("FTP_TLS")) {
+	case "", "false", "none":
+	case "true", ftp.TLSExplicit:
+		fConfig.tls = ftp.TLSExplicit
+	case ftp.TLSImplicit:
+		fConfig.tls = ftp.TLSImplicit
+	default:
+		return nil, fmt.Errorf("unsupported FTP_TLS %q, expected explicit or implicit", env.get("FTP_TLS"))
+	}
+	switch mode := strings.ToLower(env.withDefault("FTP_MODE", ftp.ModePassive)); mode {
+	case ftp.ModePassive, ftp.ModeActive:
+		fConfig.mode = mode
+	default:
+		return nil, fmt.Errorf("unsupported FTP_MODE %q, expected passive or active", env.get("FTP_MODE"))
+	}
+	return &fConfig, nil
 }
-func loadAzureConfig() *AzureConfig {
-	// Initialize data configs
+
+// loadAzureConfig loads the Azure Blob configuration from the storage settings
+func loadAzureConfig(env storageEnv) (*AzureConfig, error) {
 	aConfig := AzureConfig{}
-	aConfig.containerName = os.Getenv("AZURE_STORAGE_CONTAINER_NAME")
-	aConfig.accountName = os.Getenv("AZURE_STORAGE_ACCOUNT_NAME")
-	aConfig.accountKey = os.Getenv("AZURE_STORAGE_ACCOUNT_KEY")
+	aConfig.containerName = env.get("AZURE_STORAGE_CONTAINER_NAME")
+	aConfig.accountName = env.get("AZURE_STORAGE_ACCOUNT_NAME")
+	aConfig.accountKey = env.get("AZURE_STORAGE_ACCOUNT_KEY")
+	aConfig.connectionString = env.get("AZURE_STORAGE_CONNECTION_STRING")
+	aConfig.sasToken = env.get("AZURE_STORAGE_SAS_TOKEN")
+	aConfig.endpoint = env.get("AZURE_STORAGE_ENDPOINT")
 
-	err := utils.CheckEnvVars(azureVars)
-	if err != nil {
-		utils.Error("Please make sure all required environment variables for Azure Blob storage are set")
-		utils.Fatal("Error missing environment variables: %s", err)
+	if err := env.check(azureVars); err != nil {
+		return nil, err
+	}
+	if aConfig.connectionString == "" && aConfig.accountName == "" && aConfig.endpoint == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_ACCOUNT_NAME, AZURE_STORAGE_ENDPOINT or AZURE_STORAGE_CONNECTION_STRING is required")
+	}
+	if tier := env.get("AZURE_STORAGE_ACCESS_TIER"); tier != "" {
+		switch strings.ToLower(tier) {
+		case "hot", "cool", "cold", "archive":
+			aConfig.accessTier = strings.ToUpper(tier[:1]) + strings.ToLower(tier[1:])
+		default:
+			return nil, fmt.Errorf("unsupported AZURE_STORAGE_ACCESS_TIER %q, expected Hot, Cool, Cold or Archive", tier)
+		}
 	}
-	return &aConfig
+	return &aConfig, nil
 }
 
-func initAWSConfig() *AWSConfig {
-	// Initialize AWS configs
+// loadGCSConfig loads the Google Cloud Storage configuration from the storage settings
+func loadGCSConfig(env storageEnv) (*GCSConfig, error) {
+	if err := env.check(gcsVars); err != nil {
+		return nil, err
+	}
+	gConfig := GCSConfig{}
+	gConfig.bucketName = env.get("GCS_BUCKET_NAME")
+	gConfig.endpoint = env.get("GCS_ENDPOINT")
+	return &gConfig, nil
+}
+
+// loadWebDAVConfig loads the WebDAV configuration from the storage settings
+func loadWebDAVConfig(env storageEnv) (*WebDAVConfig, error) {
+	if err := env.check(webdavVars); err != nil {
+		return nil, err
+	}
+	wConfig := WebDAVConfig{}
+	wConfig.url = env.get("WEBDAV_URL")
+	wConfig.user = env.get("WEBDAV_USER")
+	wConfig.password = env.get("WEBDAV_PASSWORD")
+	wConfig.token = env.get("WEBDAV_TOKEN")
+	wConfig.caCert = env.get("WEBDAV_CA_CERT")
+	if chunkSize := env.get("WEBDAV_CHUNK_SIZE"); chunkSize != "" && chunkSize != "0" {
+		size, err := goutils.ConvertToBytes(chunkSize)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing WEBDAV_CHUNK_SIZE: %w", err)
+		}
+		wConfig.chunkSize = size
+	}
+	return &wConfig, nil
+}
+
+// loadRcloneConfig loads the rclone configuration from the storage settings
+func loadRcloneConfig(env storageEnv) (*RcloneConfig, error) {
+	if err := env.check(rcloneVars); err != nil {
+		return nil, err
+	}
+	rConfig := RcloneConfig{}
+	rConfig.remote = env.get("RCLONE_REMOTE")
+	rConfig.configFile = env.get("RCLONE_CONFIG")
+	rConfig.flags = strings.Fields(env.get("RCLONE_FLAGS"))
+	return &rConfig, nil
+}
+
+// initAWSConfig loads the AWS S3 configuration from the storage settings
+func initAWSConfig(env storageEnv) (*AWSConfig, error) {
+	if err := env.check(awsVars); err != nil {
+		return nil, err
+	}
 	aConfig := AWSConfig{}
-	aConfig.endpoint = utils.GetEnvVariable("AWS_S3_ENDPOINT", "S3_ENDPOINT")
-	aConfig.accessKey = utils.GetEnvVariable("AWS_ACCESS_KEY", "ACCESS_KEY")
-	aConfig.secretKey = utils.GetEnvVariable("AWS_SECRET_KEY", "SECRET_KEY")
-	aConfig.bucket = utils.GetEnvVariable("AWS_S3_BUCKET_NAME", "BUCKET_NAME")
-	aConfig.remotePath = utils.GetEnvVariable("AWS_S3_PATH", "S3_PATH")
-
-	aConfig.region = os.Getenv("AWS_REGION")
-	disableSsl, err := strconv.ParseBool(os.Getenv("AWS_DISABLE_SSL"))
-	if err != nil {
-		disableSsl = false
+	aConfig.endpoint = env.variable("AWS_S3_ENDPOINT", "S3_ENDPOINT")
+	aConfig.accessKey = env.variable("AWS_ACCESS_KEY", "ACCESS_KEY")
+	aConfig.secretKey = env.variable("AWS_SECRET_KEY", "SECRET_KEY")
+	aConfig.bucket = env.variable("AWS_S3_BUCKET_NAME", "BUCKET_NAME")
+	aConfig.remotePath = env.variable("AWS_S3_PATH", "S3_PATH")
+
+	aConfig.region = env.get("AWS_REGION")
+	aConfig.roleARN = env.get("AWS_ASSUME_ROLE_ARN")
+	aConfig.externalID = env.get("AWS_ASSUME_ROLE_EXTERNAL_ID")
+	aConfig.roleSessionName = env.withDefault("AWS_ASSUME_ROLE_SESSION_NAME", "mysql-bkup")
+	aConfig.disableSsl, _ = strconv.ParseBool(env.get("AWS_DISABLE_SSL"))
+	aConfig.forcePathStyle, _ = strconv.ParseBool(env.get("AWS_FORCE_PATH_STYLE"))
+	if err := loadS3Options(env, &aConfig); err != nil {
+		return nil, fmt.Errorf("error checking S3 options: %w", err)
 	}
-	forcePathStyle, err := strconv.ParseBool(os.Getenv("AWS_FORCE_PATH_STYLE"))
-	if err != nil {
-		forcePathStyle = false
+	return &aConfig, nil
+}
+
+// loadS3Options loads the server-side encryption, storage class, object lock and tags options
+func loadS3Options(env storageEnv, aConfig *AWSConfig) error {
+	switch strings.ToLower(env.get("AWS_S3_SSE")) {
+	case "":
+	case "aes256", "sse-s3":
+		aConfig.sse = "AES256"
+	case "aws:kms", "sse-kms", "kms":
+		aConfig.sse = "aws:kms"
+		aConfig.sseKmsKeyID = env.get("AWS_S3_SSE_KMS_KEY_ID")
+	case "sse-c":
+		aConfig.sse = s3.SSECustomer
+		key, err := base64.StdEncoding.DecodeString(env.get("AWS_S3_SSE_CUSTOMER_KEY"))
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("AWS_S3_SSE_CUSTOMER_KEY must be a base64 encoded 256-bit key")
+		}
+		aConfig.sseCustomerKey = string(key)
+	default:
+		return fmt.Errorf("unsupported AWS_S3_SSE %q, expected AES256, aws:kms or SSE-C", env.get("AWS_S3_SSE"))
 	}
-	aConfig.disableSsl = disableSsl
-	aConfig.forcePathStyle = forcePathStyle
-	err = utils.CheckEnvVars(awsVars)
-	if err != nil {
-		utils.Error("Please make sure all required environment variables for AWS S3 are set")
-		utils.Fatal("Error checking environment variables: %s", err)
+	aConfig.storageClass = strings.ToUpper(env.get("AWS_S3_STORAGE_CLASS"))
+
+	aConfig.objectLockMode = strings.ToUpper(env.get("AWS_S3_OBJECT_LOCK_MODE"))
+	if aConfig.objectLockMode != "" {
+		if aConfig.objectLockMode != "GOVERNANCE" && aConfig.objectLockMode != "COMPLIANCE" {
+			return fmt.Errorf("unsupported AWS_S3_OBJECT_LOCK_MODE %q, expected GOVERNANCE or COMPLIANCE", aConfig.objectLockMode)
+		}
+		retention, err := utils.ParseDuration(env.get("AWS_S3_OBJECT_LOCK_RETENTION"))
+		if err != nil || retention <= 0 {
+			return fmt.Errorf("AWS_S3_OBJECT_LOCK_RETENTION is required with AWS_S3_OBJECT_LOCK_MODE, e.g: 30d")
+		}
+		aConfig.objectLockRetention = retention
+	}
+	aConfig.legalHold, _ = strconv.ParseBool(env.get("AWS_S3_OBJECT_LOCK_LEGAL_HOLD"))
+
+	aConfig.tags = make(map[string]string)
+	for _, tag := range strings.Split(env.get("AWS_S3_TAGS"), ",") {
+		if strings.TrimSpace(tag) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			return fmt.Errorf("invalid AWS_S3_TAGS %q, expected key=value pairs", tag)
+		}
+		aConfig.tags[strings.TrimSpace(key)] = strings.TrimSpace(value)
 	}
-	return &aConfig
+	return nil
 }
+
+// env returns the S3 environment variables of the S3 options of the config file
+func (o S3Options) env() map[string]string {
+	env := map[string]string{
+		"AWS_S3_SSE":                   o.SSE,
+		"AWS_S3_SSE_KMS_KEY_ID":        o.SSEKMSKeyID,
+		"AWS_S3_SSE_CUSTOMER_KEY":      o.SSECustomerKey,
+		"AWS_S3_STORAGE_CLASS":         o.StorageClass,
+		"AWS_S3_OBJECT_LOCK_MODE":      o.ObjectLock.Mode,
+		"AWS_S3_OBJECT_LOCK_RETENTION": o.ObjectLock.Retention,
+	}
+	if o.ObjectLock.LegalHold {
+		env["AWS_S3_OBJECT_LOCK_LEGAL_HOLD"] = "true"
+	}
+	if len(o.Tags) > 0 {
+		tags := make([]string, 0, len(o.Tags))
+		for key, value := range o.Tags {
+			tags = append(tags, fmt.Sprintf("%s=%s", key, value))
+		}
+		env["AWS_S3_TAGS"] = strings.Join(tags, ",")
+	}
+	return env
+}
+
 func initBackupConfig(cmd *cobra.Command) *BackupConfig {
 	utils.SetEnv("STORAGE_PATH", storagePath)
 	utils.GetEnv(cmd, "cron-expression", "BACKUP_CRON_EXPRESSION")
 	utils.GetEnv(cmd, "path", "REMOTE_PATH")
 	utils.GetEnv(cmd, "config", "BACKUP_CONFIG_FILE")
+	loadRateLimits(cmd)
 	// Get flag value and set env
 	remotePath := utils.GetEnvVariable("REMOTE_PATH", "SSH_REMOTE_PATH")
-	storage = utils.GetEnv(cmd, "storage", "STORAGE")
+	storageType := utils.GetEnv(cmd, "storage", "STORAGE")
 	prune := false
 	backupRetention := utils.GetIntEnv("BACKUP_RETENTION_DAYS")
 	if backupRetention > 0 {
@@ -267,45 +780,156 @@ func initBackupConfig(cmd *cobra.Command) *BackupConfig {
 		all = true
 	}
 	_, _ = cmd.Flags().GetString("mode")
-	passphrase := os.Getenv("GPG_PASSPHRASE")
 	_ = utils.GetEnv(cmd, "path", "AWS_S3_PATH")
 	cronExpression := os.Getenv("BACKUP_CRON_EXPRESSION")
-
-	publicKeyFile, err := checkPubKeyFile(os.Getenv("GPG_PUBLIC_KEY"))
-	if err == nil {
-		encryption = true
-		usingKey = true
-	} else if passphrase != "" {
-		encryption = true
-		usingKey = false
+	splitSize, err := parseSize(utils.GetEnv(cmd, "split-size", "BACKUP_SPLIT_SIZE"))
+	if err != nil {
+		utils.Fatal("Invalid split size: %v", err)
+	}
+	concurrency := 0
+	if value := utils.GetEnv(cmd, "concurrency", "BACKUP_CONCURRENCY"); value != "" {
+		concurrency, err = strconv.Atoi(value)
+		if err != nil || concurrency < 1 {
+			utils.Fatal("Invalid concurrency %q, expected a number greater than 0", value)
+		}
+	}
+	overlapPolicy, err := parseOverlapPolicy(utils.GetEnv(cmd, "overlap-policy", "BACKUP_OVERLAP_POLICY"))
+	if err != nil {
+		utils.Fatal("%v", err)
+	}
+	lockMode, err := parseLockMode(utils.GetEnv(cmd, "lock", "BACKUP_LOCK"))
+	if err != nil {
+		utils.Fatal("%v", err)
+	}
+	lockDir := os.Getenv("BACKUP_LOCK_DIR")
+	if lockMode == lockFile && lockDir == "" {
+		utils.Fatal("BACKUP_LOCK_DIR is required by the file lock, it must be a directory of a volume shared by the instances")
 	}
+
 	// Initialize backup configs
 	config := BackupConfig{}
 	config.backupRetention = backupRetention
 	config.disableCompression = disableCompression
 	config.prune = prune
-	config.storage = storage
-	config.encryption = encryption
+	config.storage = storageType
 	config.remotePath = remotePath
-	config.passphrase = passphrase
-	config.publicKey = publicKeyFile
-	config.usingKey = usingKey
 	config.cronExpression = cronExpression
 	config.all = all
 	config.allInOne = allInOne
 	config.customName = customName
+	config.splitSize = splitSize
+	config.concurrency = concurrency
+	config.overlapPolicy = overlapPolicy
+	config.lockMode = lockMode
+	config.lockDir = lockDir
+	config.signingKey = loadSigning()
+	loadEncryptionConfig(cmd, &config)
 	return &config
 }
 
+// loadEncryptionConfig loads the encryption method and its recipients: age recipients, gpg
+// public keys or passphrase
+func loadEncryptionConfig(cmd *cobra.Command, config *BackupConfig) {
+	passphrase := os.Getenv("GPG_PASSPHRASE")
+	encryptionMethod := strings.ToLower(utils.GetEnv(cmd, "encryption", "BACKUP_ENCRYPTION"))
+	ageRecipients, err := loadAgeRecipients()
+	if err != nil {
+		utils.Fatal("Error loading age recipients: %v", err)
+	}
+	var publicKeys []string
+	switch encryptionMethod {
+	case "", encryptionGPG:
+		// age is used by default when its recipients are set
+		if encryptionMethod == "" && len(ageRecipients) > 0 {
+			encryptionMethod = encryptionAge
+			encryption = true
+			break
+		}
+		// The envelope encryption is used by default when its key provider is set
+		if encryptionMethod == "" && os.Getenv("KEY_PROVIDER") != "" {
+			encryptionMethod = encryptionEnvelope
+			config.keyProvider = loadEncryptionKeyProvider()
+			encryption = true
+			break
+		}
+		publicKeys, err = checkPubKeyFiles(os.Getenv("GPG_PUBLIC_KEY"))
+		if err == nil {
+			encryption = true
+			usingKey = true
+		} else if os.Getenv("GPG_PUBLIC_KEY") != "" {
+			utils.Fatal("Error loading GPG public keys: %v", err)
+		} else if passphrase != "" {
+			encryption = true
+			usingKey = false
+		} else if encryptionMethod == encryptionGPG {
+			utils.Fatal("GPG_PASSPHRASE or GPG_PUBLIC_KEY required for gpg encryption")
+		}
+		encryptionMethod = encryptionGPG
+	case encryptionAge:
+		if len(ageRecipients) == 0 {
+			utils.Fatal("AGE_RECIPIENTS, AGE_RECIPIENTS_FILE or AGE_PASSPHRASE required for age encryption")
+		}
+		encryption = true
+	case encryptionEnvelope:
+		config.keyProvider = loadEncryptionKeyProvider()
+		encryption = true
+	default:
+		utils.Fatal("Invalid encryption method %q, expected age, gpg or envelope", encryptionMethod)
+	}
+	config.encryption = encryption
+	config.encryptionMethod = encryptionMethod
+	config.ageRecipients = ageRecipients
+	config.publicKeys = publicKeys
+	config.passphrase = passphrase
+	config.usingKey = usingKey
+}
+
+// loadEncryptionKeyProvider loads the key provider of KEY_PROVIDER wrapping the data keys
+func loadEncryptionKeyProvider() envelope.KeyProvider {
+	provider, err := loadKeyProvider(os.Getenv("KEY_PROVIDER"), "")
+	if err != nil {
+		utils.Fatal("Error loading key provider: %v", err)
+	}
+	return provider
+}
+
+// loadSigning loads the key signing the backup manifests
+func loadSigning() *signingKey {
+	key, err := loadSigningKey()
+	if err != nil {
+		utils.Fatal("Error loading signing key: %v", err)
+	}
+	return key
+}
+
+// loadVerification loads the keys verifying the backup signatures, they are required when
+// a signature is required
+func loadVerification(requireSignature bool) *verificationKeys {
+	keys, err := loadVerificationKeys()
+	if err != nil {
+		utils.Fatal("Error loading signature public keys: %v", err)
+	}
+	if keys == nil && requireSignature {
+		utils.Fatal("SIGNATURE_PUBLIC_KEY required to verify the backup signatures")
+	}
+	return keys
+}
+
 type RestoreConfig struct {
-	s3Path     string
-	remotePath string
-	storage    string
-	file       string
-	bucket     string
-	usingKey   bool
-	passphrase string
-	privateKey string
+	s3Path        string
+	remotePath    string
+	storage       string
+	file          string
+	bucket        string
+	usingKey      bool
+	passphrase    string
+	privateKeys   []string
+	ageIdentities []age.Identity
+	atomic        bool
+	keepOld       time.Duration
+	// requireSignature refuses unsigned backups, the signatures are verified with verificationKeys
+	requireSignature bool
+	verificationKeys *verificationKeys
 }
 
 func initRestoreConfig(cmd *cobra.Command) *RestoreConfig {
@@ -315,30 +939,58 @@ func initRestoreConfig(cmd *cobra.Command) *RestoreConfig {
 	// Get flag value and set env
 	s3Path := utils.GetEnv(cmd, "path", "AWS_S3_PATH")
 	remotePath := utils.GetEnvVariable("REMOTE_PATH", "SSH_REMOTE_PATH")
-	storage = utils.GetEnv(cmd, "storage", "STORAGE")
+	storageType := utils.GetEnv(cmd, "storage", "STORAGE")
 	file = utils.GetEnv(cmd, "file", "FILE_NAME")
+	loadRateLimits(cmd)
 	bucket := utils.GetEnvVariable("AWS_S3_BUCKET_NAME", "BUCKET_NAME")
-	passphrase := os.Getenv("GPG_PASSPHRASE")
-	privateKeyFile, err := checkPrKeyFile(os.Getenv("GPG_PRIVATE_KEY"))
-	if err == nil {
-		usingKey = true
-	} else if passphrase != "" {
-		usingKey = false
+	atomic := utils.FlagGetBool(cmd, "atomic")
+	if !atomic {
+		atomic, _ = strconv.ParseBool(os.Getenv("RESTORE_ATOMIC"))
+	}
+	requireSignature := utils.FlagGetBool(cmd, "require-signature")
+	if !requireSignature {
+		requireSignature, _ = strconv.ParseBool(os.Getenv("RESTORE_REQUIRE_SIGNATURE"))
+	}
+	utils.GetEnv(cmd, "keep-old", "RESTORE_KEEP_OLD")
+	keepOld, err := utils.ParseDuration(utils.EnvWithDefault("RESTORE_KEEP_OLD", "24h"))
+	if err != nil {
+		utils.Fatal("Error parsing RESTORE_KEEP_OLD: %v", err)
 	}
 
 	// Initialize restore configs
 	rConfig := RestoreConfig{}
 	rConfig.s3Path = s3Path
 	rConfig.remotePath = remotePath
-	rConfig.storage = storage
+	rConfig.storage = storageType
 	rConfig.bucket = bucket
 	rConfig.file = file
-	rConfig.storage = storage
-	rConfig.passphrase = passphrase
-	rConfig.usingKey = usingKey
-	rConfig.privateKey = privateKeyFile
+	rConfig.storage = storageType
+	loadDecryptionConfig(&rConfig)
+	rConfig.atomic = atomic
+	rConfig.keepOld = keepOld
+	rConfig.requireSignature = requireSignature
+	rConfig.verificationKeys = loadVerification(requireSignature)
 	return &rConfig
 }
+
+// loadDecryptionConfig loads the keys decrypting backups: age identities, gpg private keys
+// or passphrase
+func loadDecryptionConfig(conf *RestoreConfig) {
+	passphrase := os.Getenv("GPG_PASSPHRASE")
+	privateKeys, err := checkPrKeyFiles(os.Getenv("GPG_PRIVATE_KEY"))
+	if err != nil && os.Getenv("GPG_PRIVATE_KEY") != "" {
+		utils.Fatal("Error loading GPG private keys: %v", err)
+	}
+	ageIdentities, err := loadAgeIdentities()
+	if err != nil {
+		utils.Fatal("Error loading age identities: %v", err)
+	}
+	conf.passphrase = passphrase
+	conf.usingKey = len(privateKeys) > 0
+	conf.privateKeys = privateKeys
+	conf.ageIdentities = ageIdentities
+}
+
 func initTargetDbConfig() *targetDbConfig {
 	tdbConfig := targetDbConfig{}
 	tdbConfig.targetDbHost = os.Getenv("TARGET_DB_HOST")
@@ -361,3 +1013,143 @@ func loadConfigFile() (string, error) {
 	}
 	return "", fmt.Errorf("backup config file not found")
 }
+
+type TransferConfig struct {
+	from         string
+	fromPath     string
+	to           string
+	toPath       string
+	dbName       string
+	since        time.Duration
+	deleteSource bool
+}
+
+type ListConfig struct {
+	storage    string
+	remotePath string
+	dbName     string
+	since      time.Duration
+}
+
+type ReencryptConfig struct {
+	storage    string
+	remotePath string
+	dbName     string
+	file       string
+	since      time.Duration
+	encryption *BackupConfig
+	decryption *RestoreConfig
+	// requireSignature refuses to re-encrypt unsigned backups
+	requireSignature bool
+	verificationKeys *verificationKeys
+}
+
+type VerifyConfig struct {
+	storage          string
+	remotePath       string
+	file             string
+	requireSignature bool
+	verificationKeys *verificationKeys
+}
+
+func initTransferConfig(cmd *cobra.Command) *TransferConfig {
+	tConfig := TransferConfig{}
+	tConfig.from = utils.FlagGetString(cmd, "from")
+	tConfig.to = utils.FlagGetString(cmd, "to")
+	tConfig.fromPath = utils.FlagGetString(cmd, "from-path")
+	tConfig.toPath = utils.FlagGetString(cmd, "to-path")
+	tConfig.dbName = utils.FlagGetString(cmd, "dbname")
+	tConfig.deleteSource = utils.FlagGetBool(cmd, "delete-source")
+	tConfig.since = flagDuration(cmd, "since")
+	loadRateLimits(cmd)
+	return &tConfig
+}
+
+func initListConfig(cmd *cobra.Command) *ListConfig {
+	lConfig := ListConfig{}
+	lConfig.storage = utils.GetEnv(cmd, "storage", "STORAGE")
+	lConfig.remotePath = utils.FlagGetString(cmd, "path")
+	lConfig.dbName = utils.FlagGetString(cmd, "dbname")
+	lConfig.since = flagDuration(cmd, "since")
+	return &lConfig
+}
+
+func initReencryptConfig(cmd *cobra.Command) *ReencryptConfig {
+	rConfig := ReencryptConfig{}
+	rConfig.storage = utils.GetEnv(cmd, "storage", "STORAGE")
+	rConfig.remotePath = utils.FlagGetString(cmd, "path")
+	rConfig.dbName = utils.FlagGetString(cmd, "dbname")
+	rConfig.file = utils.FlagGetString(cmd, "file")
+	rConfig.since = flagDuration(cmd, "since")
+	loadRateLimits(cmd)
+	rConfig.decryption = &RestoreConfig{}
+	loadDecryptionConfig(rConfig.decryption)
+	rConfig.encryption = &BackupConfig{}
+	rConfig.encryption.signingKey = loadSigning()
+	rConfig.requireSignature = utils.FlagGetBool(cmd, "require-signature")
+	rConfig.verificationKeys = loadVerification(rConfig.requireSignature)
+	loadEncryptionConfig(cmd, rConfig.encryption)
+	if !rConfig.encryption.encryption {
+		utils.Fatal("No new recipients: set AGE_RECIPIENTS, AGE_RECIPIENTS_FILE, GPG_PUBLIC_KEY, GPG_PASSPHRASE or KEY_PROVIDER")
+	}
+	return &rConfig
+}
+
+func initVerifyConfig(cmd *cobra.Command) *VerifyConfig {
+	vConfig := VerifyConfig{}
+	vConfig.storage = utils.GetEnv(cmd, "storage", "STORAGE")
+	vConfig.remotePath = utils.FlagGetString(cmd, "path")
+	vConfig.file = utils.FlagGetString(cmd, "file")
+	vConfig.requireSignature = utils.FlagGetBool(cmd, "require-signature")
+	if !vConfig.requireSignature {
+		vConfig.requireSignature, _ = strconv.ParseBool(os.Getenv("RESTORE_REQUIRE_SIGNATURE"))
+	}
+	loadRateLimits(cmd)
+	vConfig.verificationKeys = loadVerification(vConfig.requireSignature)
+	return &vConfig
+}
+
+// loadRetryPolicy loads the retry policy of the storage operations
+func loadRetryPolicy() storage.RetryPolicy {
+	policy, err := parseRetryPolicy()
+	if err != nil {
+		utils.Fatal("Error loading storage retry policy: %v", err)
+	}
+	return policy
+}
+
+// parseRetryPolicy parses the retry policy of the storage operations
+func parseRetryPolicy() (storage.RetryPolicy, error) {
+	attempts, err := strconv.Atoi(utils.EnvWithDefault("STORAGE_RETRY_ATTEMPTS", "3"))
+	if err != nil || attempts < 1 {
+		return storage.RetryPolicy{}, fmt.Errorf("invalid STORAGE_RETRY_ATTEMPTS %q, expected a positive number", os.Getenv("STORAGE_RETRY_ATTEMPTS"))
+	}
+	backoff, err := utils.ParseDuration(utils.EnvWithDefault("STORAGE_RETRY_BACKOFF", "5s"))
+	if err != nil {
+		return storage.RetryPolicy{}, fmt.Errorf("error parsing STORAGE_RETRY_BACKOFF: %w", err)
+	}
+	maxBackoff, err := utils.ParseDuration(utils.EnvWithDefault("STORAGE_RETRY_MAX_BACKOFF", "2m"))
+	if err != nil {
+		return storage.RetryPolicy{}, fmt.Errorf("error parsing STORAGE_RETRY_MAX_BACKOFF: %w", err)
+	}
+	return storage.RetryPolicy{
+		Attempts:   attempts,
+		Backoff:    backoff,
+		MaxBackoff: maxBackoff,
+		OnRetry: func(operation string, attempt int, wait time.Duration, err error) {
+			utils.Warn("Storage %s failed (attempt %d/%d): %v, retrying in %s", operation, attempt, attempts, err, wait.Round(time.Millisecond))
+		},
+	}, nil
+}
+
+func flagDuration(cmd *cobra.Command, flagName string) time.Duration {
+	value := utils.FlagGetString(cmd, flagName)
+	if value == "" {
+		return 0
+	}
+	duration, err := utils.ParseDuration(value)
+	if err != nil {
+		utils.Fatal("Error parsing --%s: %v", flagName, err)
+	}
+	return duration
+}
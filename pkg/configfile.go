@@ -0,0 +1,443 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// configVersion is the current version of the config file schema, a config file without
+// version is read as the first version
+const configVersion = 1
+
+// storageTypes are the storage types a database or a storage profile can use
+var storageTypes = []string{"local", "s3", "ssh", "remote", "sftp", "ftp", "azure", "gcs", "webdav", "rclone"}
+
+// StorageProfile is a named storage of the config file, a database uses it with its name
+type StorageProfile struct {
+	Type string `yaml:"type"`
+	Path string `yaml:"path"`
+	// S3 and GCS
+	Endpoint       string `yaml:"endpoint"`
+	Bucket         string `yaml:"bucket"`
+	Region         string `yaml:"region"`
+	AccessKey      string `yaml:"accessKey"`
+	SecretKey      string `yaml:"secretKey"`
+	DisableSsl     *bool  `yaml:"disableSsl"`
+	ForcePathStyle *bool  `yaml:"forcePathStyle"`
+	// SSH, FTP and WebDAV
+	Host         string `yaml:"host"`
+	Port         string `yaml:"port"`
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	IdentityFile string `yaml:"identityFile"`
+	URL          string `yaml:"url"`
+	Token        string `yaml:"token"`
+	// Azure Blob
+	AccountName      string `yaml:"accountName"`
+	AccountKey       string `yaml:"accountKey"`
+	ConnectionString string `yaml:"connectionString"`
+	Container        string `yaml:"container"`
+	// Rclone
+	Remote     string `yaml:"remote"`
+	ConfigFile string `yaml:"configFile"`
+	// Env sets the other environment variables of the storage, e.g. AWS_S3_STORAGE_CLASS
+	Env map[string]string `yaml:"env"`
+}
+
+// EncryptionProfile is a named encryption of the config file, a database uses it with its name
+type EncryptionProfile struct {
+	Method        string   `yaml:"method"`
+	GPGPublicKey  string   `yaml:"gpgPublicKey"`
+	GPGPassphrase string   `yaml:"gpgPassphrase"`
+	AgeRecipients []string `yaml:"ageRecipients"`
+	KeyProvider   string   `yaml:"keyProvider"`
+}
+
+// Notifications are the notification channels of the config file
+type Notifications struct {
+	Mail struct {
+		Host     string `yaml:"host"`
+		Port     string `yaml:"port"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		From     string `yaml:"from"`
+		To       string `yaml:"to"`
+		SkipTls  string `yaml:"skipTls"`
+	} `yaml:"mail"`
+	Telegram struct {
+		Token  string `yaml:"token"`
+		ChatID string `yaml:"chatId"`
+	} `yaml:"telegram"`
+}
+
+var (
+	configOnce    sync.Once
+	fileConfig    *Config
+	fileConfigErr error
+	// envReference matches ${VAR} and ${VAR:-default}, $${ escapes a reference
+	envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?}`)
+)
+
+// loadConfig reads the config file once and sets the environment variables of its settings,
+// it returns nil when there is no config file
+func loadConfig() (*Config, error) {
+	configOnce.Do(func() {
+		configFile, err := loadConfigFile()
+		if err != nil {
+			return
+		}
+		fileConfig, fileConfigErr = readConf(configFile)
+		if fileConfigErr == nil {
+			fileConfig.setEnv()
+			// The settings of the config file may reference secrets or set _FILE variables
+			fileConfigErr = loadSecrets()
+		}
+	})
+	return fileConfig, fileConfigErr
+}
+
+// validate checks the version, the storage profiles and the encryption profiles of the config
+func (c *Config) validate() error {
+	if c.Version < 0 || c.Version > configVersion {
+		return fmt.Errorf("unsupported config version %d, expected %d", c.Version, configVersion)
+	}
+	if c.Concurrency < 0 {
+		return fmt.Errorf("invalid concurrency %d, expected a number greater than 0", c.Concurrency)
+	}
+	for name, profile := range c.Storages {
+		if !isStorageType(profile.Type) {
+			return fmt.Errorf("invalid type %q of the %s storage, expected one of %s", profile.Type, name, strings.Join(storageTypes, ", "))
+		}
+	}
+	for name, profile := range c.Encryption {
+		switch strings.ToLower(profile.Method) {
+		case encryptionAge, encryptionGPG, encryptionEnvelope:
+		default:
+			return fmt.Errorf("invalid method %q of the %s encryption, expected age, gpg or envelope", profile.Method, name)
+		}
+	}
+	return nil
+}
+
+// setEnv sets the environment variables of the notifications and of the env section,
+// the environment variables already set are kept
+func (c *Config) setEnv() {
+	mail := c.Notifications.Mail
+	telegram := c.Notifications.Telegram
+	env := map[string]string{
+		"MAIL_HOST":     mail.Host,
+		"MAIL_PORT":     mail.Port,
+		"MAIL_USERNAME": mail.Username,
+		"MAIL_PASSWORD": mail.Password,
+		"MAIL_FROM":     mail.From,
+		"MAIL_TO":       mail.To,
+		"MAIL_SKIP_TLS": mail.SkipTls,
+		"TG_TOKEN":      telegram.Token,
+		"TG_CHAT_ID":    telegram.ChatID,
+	}
+	for key, value := range c.Env {
+		env[key] = value
+	}
+	for key, value := range env {
+		if _, ok := os.LookupEnv(key); !ok && value != "" {
+			utils.SetEnv(key, value)
+		}
+	}
+}
+
+// database returns a database of the config file with the defaults it doesn't set, its
+// encryption profile and the path of its storage profile
+func (c *Config) database(database Database) (Database, error) {
+	database = withDefaults(database, c.Defaults)
+	if profile, ok := c.Encryption[database.Encryption]; ok {
+		database.Encryption = profile.Method
+		if database.GPGPublicKey == "" {
+			database.GPGPublicKey = profile.GPGPublicKey
+		}
+		if database.GPGPassphrase == "" {
+			database.GPGPassphrase = profile.GPGPassphrase
+		}
+		if len(database.AgeRecipients) == 0 {
+			database.AgeRecipients = profile.AgeRecipients
+		}
+		if database.KeyProvider == "" {
+			database.KeyProvider = profile.KeyProvider
+		}
+	}
+	if database.Storage == "" {
+		return database, nil
+	}
+	profile, ok := c.Storages[database.Storage]
+	if !ok {
+		if !isStorageType(database.Storage) {
+			return database, fmt.Errorf("unknown storage %q of the %s database", database.Storage, database.Name)
+		}
+		return database, nil
+	}
+	if database.Path == "" {
+		database.Path = profile.Path
+	}
+	return database, nil
+}
+
+// withDefaults returns the database with the settings of the defaults it doesn't set
+func withDefaults(database, defaults Database) Database {
+	value := reflect.ValueOf(&database).Elem()
+	defaultValue := reflect.ValueOf(defaults)
+	for i := 0; i < value.NumField(); i++ {
+		if value.Field(i).IsZero() {
+			value.Field(i).Set(defaultValue.Field(i))
+		}
+	}
+	return database
+}
+
+// isStorageType checks if name is a storage type
+func isStorageType(name string) bool {
+	for _, storageType := range storageTypes {
+		if strings.EqualFold(name, storageType) {
+			return true
+		}
+	}
+	return false
+}
+
+// storageProfile returns the storage profile of the config file with the given name
+func storageProfile(name string) (StorageProfile, bool) {
+	conf, err := loadConfig()
+	if err != nil {
+		utils.Warn("Error reading config file: %v", err)
+		return StorageProfile{}, false
+	}
+	if conf == nil {
+		return StorageProfile{}, false
+	}
+	profile, ok := conf.Storages[name]
+	return profile, ok
+}
+
+// env returns the environment variables of the storage profile
+func (p StorageProfile) env() map[string]string {
+	env := map[string]string{}
+	switch strings.ToLower(p.Type) {
+	case "s3":
+		env = map[string]string{
+			"AWS_S3_ENDPOINT":      p.Endpoint,
+			"AWS_S3_BUCKET_NAME":   p.Bucket,
+			"AWS_REGION":           p.Region,
+			"AWS_ACCESS_KEY":       p.AccessKey,
+			"AWS_SECRET_KEY":       p.SecretKey,
+			"AWS_DISABLE_SSL":      formatBool(p.DisableSsl),
+			"AWS_FORCE_PATH_STYLE": formatBool(p.ForcePathStyle),
+		}
+	case "ssh", "remote", "sftp":
+		env = map[string]string{
+			"SSH_HOST":          p.Host,
+			"SSH_PORT":          p.Port,
+			"SSH_USER":          p.User,
+			"SSH_PASSWORD":      p.Password,
+			"SSH_IDENTIFY_FILE": p.IdentityFile,
+			"REMOTE_PATH":       p.Path,
+		}
+	case "ftp":
+		env = map[string]string{
+			"FTP_HOST":     p.Host,
+			"FTP_PORT":     p.Port,
+			"FTP_USER":     p.User,
+			"FTP_PASSWORD": p.Password,
+			"REMOTE_PATH":  p.Path,
+		}
+	case "azure":
+		env = map[string]string{
+			"AZURE_STORAGE_ACCOUNT_NAME":      p.AccountName,
+			"AZURE_STORAGE_ACCOUNT_KEY":       p.AccountKey,
+			"AZURE_STORAGE_CONNECTION_STRING": p.ConnectionString,
+			"AZURE_STORAGE_CONTAINER_NAME":    p.Container,
+			"AZURE_STORAGE_ENDPOINT":          p.Endpoint,
+		}
+	case "gcs":
+		env = map[string]string{
+			"GCS_BUCKET_NAME": p.Bucket,
+			"GCS_ENDPOINT":    p.Endpoint,
+		}
+	case "webdav":
+		env = map[string]string{
+			"WEBDAV_URL":      p.URL,
+			"WEBDAV_USER":     p.User,
+			"WEBDAV_PASSWORD": p.Password,
+			"WEBDAV_TOKEN":    p.Token,
+		}
+	case "rclone":
+		env = map[string]string{
+			"RCLONE_REMOTE": p.Remote,
+			"RCLONE_CONFIG": p.ConfigFile,
+		}
+	}
+	for key, value := range p.Env {
+		env[key] = value
+	}
+	return env
+}
+
+func formatBool(value *bool) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*value)
+}
+
+// interpolateNode replaces the environment variable references of the values of a YAML node,
+// the values are interpolated after parsing so the environment variables can't change the structure
+func interpolateNode(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		value, err := interpolate(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value != node.Value {
+			node.Value = value
+			node.Tag = interpolatedTag(node.Tag, value)
+		}
+	}
+	for _, child := range node.Content {
+		if err := interpolateNode(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interpolatedTag returns the tag of an interpolated value, numbers and booleans are tagged as
+// such even when quoted, so "${PORT}" can be decoded as a number, string fields keep the value as is
+func interpolatedTag(tag, value string) string {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return "!!int"
+	}
+	if _, err := strconv.ParseBool(value); err == nil && strings.ToLower(value) == value {
+		return "!!bool"
+	}
+	return tag
+}
+
+// interpolate replaces the ${VAR} and ${VAR:-default} references of a value, the default
+// is used when the variable is not set or empty, a variable without default must be set
+func interpolate(value string) (string, error) {
+	var missing []string
+	result := envReference.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		groups := envReference.FindStringSubmatch(match)
+		if env := os.Getenv(groups[1]); env != "" {
+			return env
+		}
+		if groups[2] != "" {
+			return groups[3]
+		}
+		missing = append(missing, groups[1])
+		return match
+	})
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+// checkConfigFields checks the config file has no unknown field, e.g. a misspelled setting
+// which would be ignored
+func checkConfigFields(configFile string) error {
+	buf, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var node yaml.Node
+	if err = yaml.Unmarshal(buf, &node); err != nil {
+		return err
+	}
+	unknown := unknownFields(&node, reflect.TypeOf(Config{}), "")
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown fields: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// unknownFields returns the fields of a YAML node which are not fields of the type t
+func unknownFields(node *yaml.Node, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var unknown []string
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			unknown = append(unknown, unknownFields(child, t, path)...)
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice {
+			for i, child := range node.Content {
+				unknown = append(unknown, unknownFields(child, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldPath := strings.TrimPrefix(path+"."+key.Value, ".")
+			switch t.Kind() {
+			case reflect.Map:
+				unknown = append(unknown, unknownFields(value, t.Elem(), fieldPath)...)
+			case reflect.Struct:
+				field, ok := yamlField(t, key.Value)
+				if !ok {
+					unknown = append(unknown, fmt.Sprintf("%s (line %d)", fieldPath, key.Line))
+					continue
+				}
+				unknown = append(unknown, unknownFields(value, field.Type, fieldPath)...)
+			}
+		}
+	}
+	return unknown
+}
+
+// yamlField returns the field of a struct with the given YAML name
+func yamlField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if strings.Split(field.Tag.Get("yaml"), ",")[0] == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,692 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/envelope"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// dumpPrivileges are the privileges mysqldump needs to dump a database
+var dumpPrivileges = []string{"SELECT", "LOCK TABLES", "SHOW VIEW", "TRIGGER"}
+
+// grantPattern matches the privileges and the scope of a grant of SHOW GRANTS
+var grantPattern = regexp.MustCompile("^GRANT (.+) ON (\\S+) TO ")
+
+// deprecatedVars are the deprecated names of the required environment variables of the storages
+var deprecatedVars = map[string]string{
+	"AWS_S3_ENDPOINT":    "S3_ENDPOINT",
+	"AWS_S3_BUCKET_NAME": "BUCKET_NAME",
+	"SSH_HOST":           "SSH_HOST_NAME",
+	"FTP_HOST":           "FTP_HOST_NAME",
+}
+
+// checkReport is the pass/fail report of the config validate and doctor commands
+type checkReport struct {
+	w        *tabwriter.Writer
+	checks   int
+	failed   int
+	warnings int
+}
+
+// doctorTargets are the databases, storages and key providers of the configuration, which
+// the doctor command tests once they are valid
+type doctorTargets struct {
+	databases    []*dbConfig
+	storages     []doctorStorage
+	keyProviders []envelope.KeyProvider
+}
+
+type doctorStorage struct {
+	name string
+	path string
+}
+
+func newCheckReport() *checkReport {
+	r := &checkReport{w: tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)}
+	_, _ = fmt.Fprintln(r.w, "STATUS\tCHECK\tDETAIL")
+	return r
+}
+
+func (r *checkReport) add(status, check, detail string) {
+	r.checks++
+	_, _ = fmt.Fprintf(r.w, "%s\t%s\t%s\n", status, check, strings.Join(strings.Fields(detail), " "))
+}
+
+func (r *checkReport) pass(check, detail string) {
+	r.add("PASS", check, detail)
+}
+
+func (r *checkReport) warn(check, detail string) {
+	r.warnings++
+	r.add("WARN", check, detail)
+}
+
+func (r *checkReport) fail(check string, err error) {
+	r.failed++
+	r.add("FAIL", check, err.Error())
+}
+
+// check adds a passed check, or a failed check when err is not nil, and returns whether it passed
+func (r *checkReport) check(check string, err error, detail string) bool {
+	if err != nil {
+		r.fail(check, err)
+		return false
+	}
+	r.pass(check, detail)
+	return true
+}
+
+// finish prints the report, the command exits with an error when a check failed
+func (r *checkReport) finish() {
+	fmt.Println()
+	_ = r.w.Flush()
+	fmt.Printf("\n%d checks: %d passed, %d failed, %d warnings\n", r.checks, r.checks-r.failed-r.warnings, r.failed, r.warnings)
+	if r.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// StartValidate checks the config file, the cron expressions, the key files and the required
+// environment variables, without connecting to the databases and the storages
+func StartValidate(cmd *cobra.Command) {
+	report := newCheckReport()
+	validateConfig(cmd, report)
+	report.finish()
+}
+
+// StartDoctor validates the configuration, then tests the databases and their privileges, the
+// storages, the key providers, the notifications and the free space of the temporary directory
+func StartDoctor(cmd *cobra.Command) {
+	minFreeSpace, err := parseSize(utils.FlagGetString(cmd, "min-free-space"))
+	if err != nil {
+		utils.Fatal("Invalid minimum free space: %v", err)
+	}
+	report := newCheckReport()
+	targets := validateConfig(cmd, report)
+	for _, db := range targets.databases {
+		checkDatabase(report, db)
+	}
+	for _, target := range targets.storages {
+		checkStorage(report, target)
+	}
+	for _, provider := range targets.keyProviders {
+		checkKeyProvider(report, provider)
+	}
+	checkNotifications(report)
+	checkFreeSpace(report, minFreeSpace)
+	report.finish()
+}
+
+// validateConfig checks the configuration and returns the valid databases, storages and key providers
+func validateConfig(cmd *cobra.Command, r *checkReport) doctorTargets {
+	var targets doctorTargets
+	utils.GetEnv(cmd, "config", "BACKUP_CONFIG_FILE")
+	storageType := utils.GetEnv(cmd, "storage", "STORAGE")
+	remotePath := utils.GetEnv(cmd, "path", "REMOTE_PATH")
+	cronExpression := os.Getenv("BACKUP_CRON_EXPRESSION")
+
+	var conf *Config
+	if configFile, err := loadConfigFile(); err != nil {
+		r.pass("config file", "none, the settings are read from the environment variables")
+	} else {
+		conf, err = loadConfig()
+		if !r.check("config file", err, configFile) {
+			return targets
+		}
+		r.check("config fields", checkConfigFields(configFile), "no unknown field")
+		if conf.CronExpression != "" {
+			cronExpression = conf.CronExpression
+		}
+	}
+	if cronExpression != "" {
+		r.check("cron expression", checkCronExpression(cronExpression), cronExpression)
+	}
+	keyProvider := validateEncryption(r)
+	if keyProvider != nil {
+		targets.keyProviders = append(targets.keyProviders, keyProvider)
+	}
+	validateSigning(r)
+	validateSettings(r)
+
+	base := &BackupConfig{storage: storageType, remotePath: remotePath, cronExpression: cronExpression, keyProvider: keyProvider}
+	var configs []*BackupConfig
+	if conf != nil {
+		if len(conf.Databases) == 0 {
+			r.fail("databases", errors.New("no databases found"))
+		}
+		for _, database := range conf.Databases {
+			check := fmt.Sprintf("database %s", database.Name)
+			db, bkConfig, err := validateDatabaseConfig(conf, database, base)
+			if r.check(check, err, describeBackup(bkConfig)) {
+				targets.databases = append(targets.databases, db)
+				configs = append(configs, bkConfig)
+			}
+		}
+	} else {
+		check := fmt.Sprintf("database %s", os.Getenv("DB_NAME"))
+		err := utils.CheckEnvVars(dbHVars)
+		if err == nil {
+			_, err = parseDumpLimits(Database{})
+		}
+		if r.check(check, err, describeBackup(base)) {
+			if os.Getenv("DB_NAME") == "" && utils.FlagGetString(cmd, "dbname") == "" {
+				r.warn(check, "DB_NAME not set, it's required unless all the databases are backed up")
+			}
+			targets.databases = append(targets.databases, initDbConfig(cmd))
+			configs = append(configs, base)
+		}
+	}
+
+	seen := map[doctorStorage]bool{}
+	for _, bkConfig := range configs {
+		target := doctorStorage{name: bkConfig.storage, path: bkConfig.remotePath}
+		if target.name == "" {
+			target.name = "local"
+		}
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+		if r.check(fmt.Sprintf("storage %s config", target.name), validateStorage(target.name), storageDescription(target)) {
+			targets.storages = append(targets.storages, target)
+		}
+		if bkConfig.keyProvider != nil && bkConfig.keyProvider != keyProvider {
+			targets.keyProviders = append(targets.keyProviders, bkConfig.keyProvider)
+		}
+	}
+	return targets
+}
+
+// validateDatabaseConfig checks the settings of a database of the config file and returns its
+// database and backup configs
+func validateDatabaseConfig(conf *Config, database Database, base *BackupConfig) (*dbConfig, *BackupConfig, error) {
+	if database.Name == "" {
+		return nil, nil, errors.New("database name required")
+	}
+	database, err := conf.database(database)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = parseDumpLimits(database); err != nil {
+		return nil, nil, err
+	}
+	// getDatabase exits on a secret reference which can't be resolved
+	for _, value := range []string{database.User, database.Password, database.Host, database.Port, database.DumpOptions} {
+		if _, err = resolveSecret(value); err != nil {
+			return nil, nil, err
+		}
+	}
+	db := getDatabase(database)
+	var missing []string
+	for _, setting := range []struct{ name, value string }{{"host", db.dbHost}, {"user", db.dbUserName}, {"password", db.dbPassword}} {
+		if setting.value == "" {
+			missing = append(missing, setting.name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("missing database %s in the config file and the environment variables", strings.Join(missing, ", "))
+	}
+	bkConfig, err := databaseBackupConfig(database, base)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bkConfig.cronExpression != "" {
+		if err = checkCronExpression(bkConfig.cronExpression); err != nil {
+			return nil, nil, err
+		}
+	}
+	return db, bkConfig, nil
+}
+
+// describeBackup returns the schedule, storage and encryption of a backup config
+func describeBackup(config *BackupConfig) string {
+	if config == nil {
+		return ""
+	}
+	schedule := "no schedule"
+	if config.cronExpression != "" {
+		schedule = config.cronExpression
+	}
+	storageName := config.storage
+	if storageName == "" {
+		storageName = "local"
+	}
+	encryptionMethod := "no encryption"
+	if config.encryption {
+		encryptionMethod = config.encryptionMethod + " encryption"
+	}
+	return fmt.Sprintf("%s, %s storage, %s", schedule, storageName, encryptionMethod)
+}
+
+func storageDescription(target doctorStorage) string {
+	if target.path == "" {
+		return target.name
+	}
+	return fmt.Sprintf("%s %s", target.name, target.path)
+}
+
+func checkCronExpression(cronExpression string) error {
+	if !utils.IsValidCronExpression(cronExpression) {
+		return fmt.Errorf("invalid cron expression %q", cronExpression)
+	}
+	return nil
+}
+
+// validateStorage checks a storage type or a storage profile and its required environment variables
+func validateStorage(name string) error {
+	storageType, env := name, map[string]string{}
+	if profile, ok := storageProfile(name); ok {
+		storageType, env = profile.Type, profile.env()
+	}
+	if !isStorageType(storageType) {
+		return fmt.Errorf("unknown storage %q, expected a storage profile or one of %s", name, strings.Join(storageTypes, ", "))
+	}
+	return storageEnv(env).check(storageVars(storageType))
+}
+
+// storageVars returns the required environment variables of a storage type
+func storageVars(storageType string) []string {
+	switch strings.ToLower(storageType) {
+	case "s3":
+		return awsVars
+	case "ssh", "remote", "sftp":
+		return sshVars
+	case "ftp":
+		return ftpVars
+	case "azure":
+		return azureVars
+	case "gcs":
+		return gcsVars
+	case "webdav":
+		return webdavVars
+	case "rclone":
+		return rcloneVars
+	}
+	return nil
+}
+
+// validateEncryption checks the encryption method and the keys of the environment variables,
+// and returns the key provider of the envelope encryption
+func validateEncryption(r *checkReport) envelope.KeyProvider {
+	method := strings.ToLower(os.Getenv("BACKUP_ENCRYPTION"))
+	ageRecipients, err := loadAgeRecipients()
+	if os.Getenv("AGE_RECIPIENTS") != "" || os.Getenv("AGE_RECIPIENTS_FILE") != "" || os.Getenv("AGE_PASSPHRASE") != "" {
+		r.check("age recipients", err, fmt.Sprintf("%d recipients", len(ageRecipients)))
+	}
+	if os.Getenv("AGE_IDENTITY_FILE") != "" {
+		identities, err := loadAgeIdentities()
+		r.check("age identities", err, fmt.Sprintf("%d identities", len(identities)))
+	}
+	if os.Getenv("GPG_PUBLIC_KEY") != "" {
+		count, err := countGPGKeys(checkPubKeyFiles(os.Getenv("GPG_PUBLIC_KEY")))
+		r.check("gpg public keys", err, fmt.Sprintf("%d keys", count))
+	}
+	if os.Getenv("GPG_PRIVATE_KEY") != "" {
+		files, err := checkPrKeyFiles(os.Getenv("GPG_PRIVATE_KEY"))
+		count := 0
+		if err == nil {
+			count, err = countGPGKeyRing(files, os.Getenv("GPG_PASSPHRASE"))
+		}
+		r.check("gpg private keys", err, fmt.Sprintf("%d keys", count))
+	}
+	var provider envelope.KeyProvider
+	if name := os.Getenv("KEY_PROVIDER"); name != "" {
+		detail := name
+		if provider, err = loadKeyProvider(name, ""); err == nil {
+			detail = fmt.Sprintf("%s key %s", provider.Name(), provider.KeyID())
+		} else {
+			provider = nil
+		}
+		r.check("key provider", err, detail)
+	}
+	switch method {
+	case "":
+		return provider
+	case encryptionAge:
+		if len(ageRecipients) == 0 {
+			err = errors.New("AGE_RECIPIENTS, AGE_RECIPIENTS_FILE or AGE_PASSPHRASE required for age encryption")
+		}
+	case encryptionGPG:
+		if os.Getenv("GPG_PUBLIC_KEY") == "" && os.Getenv("GPG_PASSPHRASE") == "" {
+			err = errors.New("GPG_PASSPHRASE or GPG_PUBLIC_KEY required for gpg encryption")
+		}
+	case encryptionEnvelope:
+		if os.Getenv("KEY_PROVIDER") == "" {
+			err = errors.New("KEY_PROVIDER required for envelope encryption")
+		}
+	default:
+		err = fmt.Errorf("invalid encryption method %q, expected age, gpg or envelope", method)
+	}
+	r.check("encryption method", err, method)
+	return provider
+}
+
+func countGPGKeys(files []string, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	return countGPGKeyRing(files, "")
+}
+
+func countGPGKeyRing(files []string, passphrase string) (int, error) {
+	keyRing, err := loadGPGKeyRing(files, passphrase)
+	if err != nil {
+		return 0, err
+	}
+	return keyRing.CountEntities(), nil
+}
+
+// validateSigning checks the signing key and the signature public keys
+func validateSigning(r *checkReport) {
+	if os.Getenv("SIGNING_KEY") != "" {
+		_, err := loadSigningKey()
+		r.check("signing key", err, os.Getenv("SIGNING_KEY"))
+	}
+	keys, err := loadVerificationKeys()
+	if os.Getenv("SIGNATURE_PUBLIC_KEY") != "" {
+		r.check("signature public keys", err, os.Getenv("SIGNATURE_PUBLIC_KEY"))
+	}
+	if required, _ := strconv.ParseBool(os.Getenv("RESTORE_REQUIRE_SIGNATURE")); required && keys == nil && err == nil {
+		r.fail("signature public keys", errors.New("SIGNATURE_PUBLIC_KEY required by RESTORE_REQUIRE_SIGNATURE"))
+	}
+}
+
+// validateSettings checks the retention, concurrency, overlap policy, lock, catch-up window, split size,
+// rate limits, retry policy and notification settings
+func validateSettings(r *checkReport) {
+	if value := os.Getenv("BACKUP_RETENTION_DAYS"); value != "" {
+		if days, err := strconv.Atoi(value); err != nil || days < 0 {
+			r.fail("backup retention", fmt.Errorf("invalid BACKUP_RETENTION_DAYS %q, expected a number of days", value))
+		}
+	}
+	if value := os.Getenv("BACKUP_CONCURRENCY"); value != "" {
+		if concurrency, err := strconv.Atoi(value); err != nil || concurrency < 1 {
+			r.fail("backup concurrency", fmt.Errorf("invalid BACKUP_CONCURRENCY %q, expected a number greater than 0", value))
+		}
+	}
+	if _, err := parseOverlapPolicy(os.Getenv("BACKUP_OVERLAP_POLICY")); err != nil {
+		r.fail("backup overlap policy", err)
+	}
+	if mode, err := parseLockMode(os.Getenv("BACKUP_LOCK")); err != nil {
+		r.fail("backup lock", err)
+	} else if mode == lockFile && os.Getenv("BACKUP_LOCK_DIR") == "" {
+		r.fail("backup lock", errors.New("BACKUP_LOCK_DIR required by the file lock"))
+	}
+	if value := os.Getenv("BACKUP_CATCHUP_WINDOW"); value != "" {
+		if window, err := utils.ParseDuration(value); err != nil || window < 0 {
+			r.fail("backup catch-up window", fmt.Errorf("invalid BACKUP_CATCHUP_WINDOW %q, expected a duration", value))
+		}
+	}
+	for _, key := range []string{"BACKUP_SPLIT_SIZE", "UPLOAD_RATE_LIMIT", "DOWNLOAD_RATE_LIMIT"} {
+		if _, err := parseRate(os.Getenv(key)); err != nil {
+			r.fail(strings.ToLower(strings.ReplaceAll(key, "_", " ")), fmt.Errorf("invalid %s: %w", key, err))
+		}
+	}
+	if _, err := parseRetryPolicy(); err != nil {
+		r.fail("storage retry policy", err)
+	}
+	var channels []string
+	if utils.MailEnabled() {
+		channels = append(channels, "mail")
+	} else if os.Getenv("MAIL_HOST") != "" || os.Getenv("MAIL_TO") != "" {
+		r.fail("mail notification", errors.New("MAIL_HOST, MAIL_PORT, MAIL_FROM and MAIL_TO required"))
+	}
+	if utils.TelegramEnabled() {
+		channels = append(channels, "telegram")
+	} else if os.Getenv("TG_TOKEN") != "" || os.Getenv("TG_CHAT_ID") != "" {
+		r.fail("telegram notification", errors.New("TG_TOKEN and TG_CHAT_ID required"))
+	}
+	if len(channels) > 0 {
+		r.pass("notifications", strings.Join(channels, ", "))
+	}
+}
+
+// checkDatabase tests the connection to a database and the privileges of its user
+func checkDatabase(r *checkReport, db *dbConfig) {
+	check := fmt.Sprintf("database %s connection", db.dbName)
+	if !r.check(check, testDatabaseConnection(db), fmt.Sprintf("%s@%s:%s", db.dbUserName, db.dbHost, db.dbPort)) {
+		return
+	}
+	check = fmt.Sprintf("database %s privileges", db.dbName)
+	rows, err := queryClientSQL(db.clientConfigFile(), "SHOW GRANTS FOR CURRENT_USER()")
+	if err != nil {
+		r.warn(check, fmt.Sprintf("could not read the grants: %v", err))
+		return
+	}
+	roleRows, rolesErr := roleGrants(db.clientConfigFile(), rows)
+	privileges := grantedPrivileges(append(rows, roleRows...), db.dbName)
+	if privileges["ALL PRIVILEGES"] {
+		r.pass(check, "ALL PRIVILEGES")
+		return
+	}
+	var missing []string
+	for _, privilege := range dumpPrivileges {
+		if !privileges[privilege] {
+			missing = append(missing, privilege)
+		}
+	}
+	if len(missing) > 0 {
+		if rolesErr != nil {
+			r.warn(check, fmt.Sprintf("missing privileges for mysqldump: %s, unless granted by the roles of the user which could not be read: %v", strings.Join(missing, ", "), rolesErr))
+			return
+		}
+		r.fail(check, fmt.Errorf("missing privileges for mysqldump: %s", strings.Join(missing, ", ")))
+		return
+	}
+	r.pass(check, strings.Join(dumpPrivileges, ", "))
+	if !privileges["PROCESS"] && !containsString(db.dumpOptions, "--no-tablespaces") {
+		r.warn(check, "PROCESS privilege missing, mysqldump needs it to dump the tablespaces, or add --no-tablespaces to the dump options")
+	}
+}
+
+// roleGrants returns the grants of the active roles of the user, SHOW GRANTS only lists the roles
+// granted to the user without their privileges
+func roleGrants(clientConfig string, rows [][]string) ([][]string, error) {
+	hasRoles := false
+	for _, row := range rows {
+		if strings.HasPrefix(row[0], "GRANT ") && !grantPattern.MatchString(row[0]) {
+			hasRoles = true
+			break
+		}
+	}
+	if !hasRoles {
+		return nil, nil
+	}
+	roles, err := queryClientSQL(clientConfig, "SELECT CURRENT_ROLE()")
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 || roles[0][0] == "NONE" || roles[0][0] == "NULL" {
+		return nil, nil
+	}
+	return queryClientSQL(clientConfig, "SHOW GRANTS FOR CURRENT_USER() USING "+roles[0][0])
+}
+
+// grantedPrivileges returns the privileges of the grants on all the databases or on the given database
+func grantedPrivileges(rows [][]string, dbName string) map[string]bool {
+	privileges := map[string]bool{}
+	for _, row := range rows {
+		match := grantPattern.FindStringSubmatch(row[0])
+		if match == nil {
+			continue
+		}
+		i := strings.LastIndex(match[2], ".")
+		if i < 0 || match[2][i+1:] != "*" {
+			continue
+		}
+		schema := strings.ReplaceAll(match[2][:i], "`", "")
+		if schema != "*" && !matchSchema(schema, dbName) {
+			continue
+		}
+		for _, privilege := range strings.Split(match[1], ",") {
+			privileges[strings.ToUpper(strings.TrimSpace(privilege))] = true
+		}
+	}
+	return privileges
+}
+
+// matchSchema reports whether a database name matches the database of a grant, % and _ are
+// wildcards unless they are escaped with a backslash
+func matchSchema(pattern, name string) bool {
+	if pattern == "" {
+		return name == ""
+	}
+	switch pattern[0] {
+	case '%':
+		for i := 0; i <= len(name); i++ {
+			if matchSchema(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	case '_':
+		return name != "" && matchSchema(pattern[1:], name[1:])
+	case '\\':
+		if len(pattern) > 1 {
+			pattern = pattern[1:]
+		}
+	}
+	return name != "" && name[0] == pattern[0] && matchSchema(pattern[1:], name[1:])
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStorage tests the write, list and delete permissions of a storage with a canary file
+func checkStorage(r *checkReport, target doctorStorage) {
+	check := fmt.Sprintf("storage %s", target.name)
+	st, err := newBackend(tmpPath, target.name, target.path)
+	if err != nil {
+		r.fail(check, err)
+		return
+	}
+	r.check(check, storageCanary(st), "write, list and delete")
+}
+
+// storageCanary writes, lists and deletes a canary file in a storage
+func storageCanary(st storage.Storage) error {
+	if err := os.MkdirAll(tmpPath, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("mysql-bkup-doctor-%s.canary", time.Now().Format(atomicTimeFormat))
+	if err := os.WriteFile(filepath.Join(tmpPath, name), []byte("mysql-bkup doctor canary\n"), 0600); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(filepath.Join(tmpPath, name))
+	}()
+	if err := st.Copy(name); err != nil {
+		return fmt.Errorf("write failed: %w", err)
+	}
+	files, err := st.List()
+	if err == nil && !containsFile(files, name) {
+		err = errors.New("the canary file is not listed")
+	}
+	if deleteErr := st.Delete(name); deleteErr != nil {
+		return fmt.Errorf("delete failed, %s must be deleted manually: %w", name, deleteErr)
+	}
+	if err != nil {
+		return fmt.Errorf("list failed: %w", err)
+	}
+	return nil
+}
+
+func containsFile(files []storage.File, name string) bool {
+	for _, file := range files {
+		if file.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKeyProvider wraps and unwraps a data key with a key provider of the envelope encryption
+func checkKeyProvider(r *checkReport, provider envelope.KeyProvider) {
+	dataKey := make([]byte, 32)
+	_, err := rand.Read(dataKey)
+	if err == nil {
+		var wrappedKey, unwrappedKey []byte
+		if wrappedKey, err = provider.WrapKey(dataKey); err == nil {
+			unwrappedKey, err = provider.UnwrapKey(wrappedKey)
+		}
+		if err == nil && !bytes.Equal(dataKey, unwrappedKey) {
+			err = errors.New("the unwrapped key doesn't match the data key")
+		}
+	}
+	r.check(fmt.Sprintf("key provider %s", provider.Name()), err, fmt.Sprintf("wrap and unwrap with %s", provider.KeyID()))
+}
+
+// checkNotifications tests the SMTP server and the Telegram bot of the notifications
+func checkNotifications(r *checkReport) {
+	if utils.MailEnabled() {
+		r.check("mail notification", utils.CheckMail(), fmt.Sprintf("%s:%s", os.Getenv("MAIL_HOST"), os.Getenv("MAIL_PORT")))
+	}
+	if utils.TelegramEnabled() {
+		r.check("telegram notification", utils.CheckTelegram(), "bot token")
+	}
+}
+
+// checkFreeSpace checks the free disk space of the temporary directory, the backups are
+// written there before they are uploaded
+func checkFreeSpace(r *checkReport, minFreeSpace int64) {
+	check := "temporary directory space"
+	if err := os.MkdirAll(tmpPath, 0700); err != nil {
+		r.fail(check, err)
+		return
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(tmpPath, &stat); err != nil {
+		r.fail(check, err)
+		return
+	}
+	free := uint64(stat.Bavail) * uint64(stat.Bsize)
+	detail := fmt.Sprintf("%s free in %s", utils.ConvertBytes(free), tmpPath)
+	if free < uint64(minFreeSpace) {
+		r.fail(check, fmt.Errorf("%s, less than %s", detail, utils.ConvertBytes(uint64(minFreeSpace))))
+		return
+	}
+	r.pass(check, detail)
+}
@@ -0,0 +1,171 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/envelope"
+	"github.com/jkaninda/mysql-bkup/pkg/envelope/awskms"
+	"github.com/jkaninda/mysql-bkup/pkg/envelope/local"
+	"github.com/jkaninda/mysql-bkup/pkg/envelope/vault"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"os"
+	"path/filepath"
+)
+
+const encryptionEnvelope = "envelope"
+
+// loadKeyProvider creates the key provider wrapping the data keys of the envelope encryption.
+// keyID is the key of an encrypted backup, it's empty to use the configured key.
+func loadKeyProvider(name, keyID string) (envelope.KeyProvider, error) {
+	switch name {
+	case local.Name:
+		return loadLocalKeyProvider(keyID)
+	case vault.Name:
+		conf := vault.Config{
+			Address:   os.Getenv("VAULT_ADDR"),
+			Token:     os.Getenv("VAULT_TOKEN"),
+			Namespace: os.Getenv("VAULT_NAMESPACE"),
+			Mount:     os.Getenv("VAULT_TRANSIT_MOUNT"),
+			Key:       os.Getenv("VAULT_TRANSIT_KEY"),
+			CACert:    os.Getenv("VAULT_CACERT"),
+		}
+		if keyID != "" {
+			var err error
+			if conf.Mount, conf.Key, err = vault.ParseKeyID(keyID); err != nil {
+				return nil, err
+			}
+		}
+		return vault.NewProvider(conf)
+	case awskms.Name:
+		conf := awskms.Config{
+			KeyID:     os.Getenv("AWS_KMS_KEY_ID"),
+			Region:    os.Getenv("AWS_REGION"),
+			Endpoint:  os.Getenv("AWS_KMS_ENDPOINT"),
+			AccessKey: utils.GetEnvVariable("AWS_ACCESS_KEY", "ACCESS_KEY"),
+			SecretKey: utils.GetEnvVariable("AWS_SECRET_KEY", "SECRET_KEY"),
+		}
+		if keyID != "" {
+			conf.KeyID = keyID
+		}
+		return awskms.NewProvider(conf)
+	case "":
+		return nil, errors.New("KEY_PROVIDER required for envelope encryption")
+	}
+	return nil, fmt.Errorf("invalid key provider %q, expected local, vault or aws-kms", name)
+}
+
+// loadLocalKeyProvider loads the key files and directories of ENCRYPTION_KEY_FILE, the first key
+// encrypts and the key matching keyID decrypts
+func loadLocalKeyProvider(keyID string) (envelope.KeyProvider, error) {
+	if os.Getenv("ENCRYPTION_KEY_FILE") == "" {
+		return nil, errors.New("ENCRYPTION_KEY_FILE required for the local key provider")
+	}
+	files, err := keyFiles(os.Getenv("ENCRYPTION_KEY_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+	}
+	for _, keyFile := range files {
+		provider, err := local.NewProvider(local.Config{KeyFile: keyFile})
+		if err != nil {
+			return nil, err
+		}
+		if keyID == "" || provider.KeyID() == keyID {
+			return provider, nil
+		}
+	}
+	return nil, fmt.Errorf("the key %s is not in ENCRYPTION_KEY_FILE", keyID)
+}
+
+// envelopeEncrypt encrypts a file of the directory dir with a data key wrapped by the key provider
+func envelopeEncrypt(dir, fileName, outputName string, provider envelope.KeyProvider) error {
+	in, err := os.Open(filepath.Join(dir, fileName))
+	if err != nil {
+		return err
+	}
+	defer func(in *os.File) {
+		err := in.Close()
+		if err != nil {
+			return
+		}
+	}(in)
+	out, err := os.Create(filepath.Join(dir, outputName))
+	if err != nil {
+		return err
+	}
+	if _, err = envelope.Encrypt(out, in, provider); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// envelopeDecrypt decrypts an envelope encrypted file, the data key is unwrapped by the key provider
+// and the key recorded in the file
+func envelopeDecrypt(filePath, outputFile string) error {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func(in *os.File) {
+		err := in.Close()
+		if err != nil {
+			return
+		}
+	}(in)
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	err = envelope.Decrypt(out, in, func(header *envelope.Header) (envelope.KeyProvider, error) {
+		utils.Info("Decrypting backup using %s key %s...", header.Provider, header.KeyID)
+		return loadKeyProvider(header.Provider, header.KeyID)
+	})
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// envelopeHeader returns the envelope header of an encrypted file, nil is returned for the
+// other files
+func envelopeHeader(filePath string) (*envelope.Header, error) {
+	if filepath.Ext(filePath) != "."+envelopeExtension {
+		return nil, nil
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			return
+		}
+	}(f)
+	return envelope.ReadHeader(f)
+}
@@ -0,0 +1,113 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package awskms
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/jkaninda/mysql-bkup/pkg/envelope"
+)
+
+// Name is the name of the AWS KMS key provider
+const Name = "aws-kms"
+
+type kmsProvider struct {
+	client *kms.KMS
+	keyID  string
+}
+
+// Config holds the AWS KMS key provider config
+type Config struct {
+	// KeyID is the key ID, ARN or alias of the KMS key
+	KeyID     string
+	Region    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+}
+
+// NewProvider creates a key provider wrapping the data keys with an AWS KMS key, the credentials
+// are the static keys when set, otherwise the credentials of the default chain
+func NewProvider(conf Config) (envelope.KeyProvider, error) {
+	if conf.KeyID == "" {
+		return nil, errors.New("the KMS key ID is required")
+	}
+	awsConfig := aws.Config{
+		Region:                        aws.String(conf.Region),
+		CredentialsChainVerboseErrors: aws.Bool(true),
+	}
+	if conf.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(conf.Endpoint)
+	}
+	if conf.AccessKey != "" && conf.SecretKey != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(conf.AccessKey, conf.SecretKey, "")
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the AWS session: %w", err)
+	}
+	return &kmsProvider{
+		client: kms.New(sess),
+		keyID:  conf.KeyID,
+	}, nil
+}
+
+func (p *kmsProvider) Name() string {
+	return Name
+}
+
+func (p *kmsProvider) KeyID() string {
+	return p.keyID
+}
+
+// WrapKey encrypts a data key with the KMS key, the wrapped key is the KMS ciphertext blob
+func (p *kmsProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	output, err := p.client.Encrypt(&kms.EncryptInput{
+		KeyId:     aws.String(p.keyID),
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.CiphertextBlob, nil
+}
+
+func (p *kmsProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	output, err := p.client.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(p.keyID),
+		CiphertextBlob: wrappedKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Plaintext, nil
+}
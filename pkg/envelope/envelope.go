@@ -0,0 +1,290 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package envelope
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Algorithm is the cipher encrypting the backup stream
+const Algorithm = "AES-256-GCM"
+
+// DefaultChunkSize is the size of the plaintext chunks, each chunk is sealed on its own
+const DefaultChunkSize = 64 * 1024
+
+// MaxChunkSize bounds the chunk size read from a header, the chunk buffer is allocated from it
+const MaxChunkSize = 16 * 1024 * 1024
+
+// magic starts the envelope encrypted files
+const magic = "MYSQL-BKUP-ENVELOPE\n"
+
+// maxHeaderSize bounds the header read from a file
+const maxHeaderSize = 64 * 1024
+
+// KeyProvider wraps and unwraps the data keys of the backups with a key it manages
+type KeyProvider interface {
+	// Name returns the provider name
+	Name() string
+	// KeyID identifies the key wrapping the data keys
+	KeyID() string
+	// WrapKey encrypts a data key
+	WrapKey(dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped by WrapKey
+	UnwrapKey(wrappedKey []byte) ([]byte, error)
+}
+
+// Header describes an envelope encrypted file, it's written at the beginning of the file
+// and holds the wrapped data key
+type Header struct {
+	Provider   string `json:"provider"`
+	KeyID      string `json:"keyId"`
+	WrappedKey []byte `json:"wrappedKey"`
+	Algorithm  string `json:"algorithm"`
+	ChunkSize  int    `json:"chunkSize"`
+	Nonce      []byte `json:"nonce"`
+}
+
+// Encrypt encrypts r into w with a random data key wrapped by the provider, and returns the header
+func Encrypt(w io.Writer, r io.Reader, provider KeyProvider) (*Header, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := provider.WrapKey(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap the data key with %s: %w", provider.Name(), err)
+	}
+	header := &Header{
+		Provider:   provider.Name(),
+		KeyID:      provider.KeyID(),
+		WrappedKey: wrappedKey,
+		Algorithm:  Algorithm,
+		ChunkSize:  DefaultChunkSize,
+		Nonce:      make([]byte, 12),
+	}
+	if _, err = rand.Read(header.Nonce); err != nil {
+		return nil, err
+	}
+	headerData, err := writeHeader(w, header)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if err = encryptChunks(w, r, aead, header, headerData); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// Decrypt decrypts r into w, the data key is unwrapped by the provider returned for the header
+func Decrypt(w io.Writer, r io.Reader, provider func(*Header) (KeyProvider, error)) error {
+	br := bufio.NewReader(r)
+	header, headerData, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	if header.Algorithm != Algorithm {
+		return fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+	}
+	if header.ChunkSize <= 0 || header.ChunkSize > MaxChunkSize {
+		return fmt.Errorf("invalid envelope header: chunk size %d out of range (1-%d)", header.ChunkSize, MaxChunkSize)
+	}
+	if len(header.Nonce) != 12 {
+		return errors.New("invalid envelope header")
+	}
+	p, err := provider(header)
+	if err != nil {
+		return err
+	}
+	dataKey, err := p.UnwrapKey(header.WrappedKey)
+	if err != nil {
+		return fmt.Errorf("failed to unwrap the data key with %s key %s: %w", header.Provider, header.KeyID, err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	return decryptChunks(w, br, aead, header, headerData)
+}
+
+// ReadHeader reads the header of an envelope encrypted file
+func ReadHeader(r io.Reader) (*Header, error) {
+	header, _, err := readHeader(r)
+	return header, err
+}
+
+// readHeader reads the header of an envelope encrypted file and returns it with its serialized form
+func readHeader(r io.Reader) (*Header, []byte, error) {
+	prefix := make([]byte, len(magic)+4)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, nil, fmt.Errorf("invalid envelope header: %w", err)
+	}
+	if string(prefix[:len(magic)]) != magic {
+		return nil, nil, errors.New("not an envelope encrypted file")
+	}
+	size := binary.BigEndian.Uint32(prefix[len(magic):])
+	if size > maxHeaderSize {
+		return nil, nil, errors.New("invalid envelope header size")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, nil, fmt.Errorf("invalid envelope header: %w", err)
+	}
+	header := &Header{}
+	if err := json.Unmarshal(data, header); err != nil {
+		return nil, nil, fmt.Errorf("invalid envelope header: %w", err)
+	}
+	return header, data, nil
+}
+
+// writeHeader writes the header and returns its serialized form
+func writeHeader(w io.Writer, header *Header) ([]byte, error) {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, len(magic)+4)
+	copy(prefix, magic)
+	binary.BigEndian.PutUint32(prefix[len(magic):], uint32(len(data)))
+	if _, err = w.Write(prefix); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce derives the nonce of a chunk from the header nonce and the chunk counter
+func chunkNonce(nonce []byte, counter uint64) []byte {
+	n := make([]byte, len(nonce))
+	copy(n, nonce)
+	c := binary.BigEndian.Uint64(n[4:]) ^ counter
+	binary.BigEndian.PutUint64(n[4:], c)
+	return n
+}
+
+// chunkData returns the additional data of the chunks: the serialized header, so a tampered header is
+// detected, followed by a byte marking the last chunk, so a truncated file is detected
+func chunkData(headerData []byte) (data, lastData []byte) {
+	data = append(append(make([]byte, 0, len(headerData)+1), headerData...), 0)
+	lastData = append(append(make([]byte, 0, len(headerData)+1), headerData...), 1)
+	return data, lastData
+}
+
+// encryptChunks seals the plaintext in chunks of the header chunk size, the last chunk may be empty
+func encryptChunks(w io.Writer, r io.Reader, aead cipher.AEAD, header *Header, headerData []byte) error {
+	br := bufio.NewReader(r)
+	buf := make([]byte, header.ChunkSize)
+	data, lastData := chunkData(headerData)
+	var sealed []byte
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return err
+		}
+		last, err := lastChunk(br, err)
+		if err != nil {
+			return err
+		}
+		ad := data
+		if last {
+			ad = lastData
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(header.Nonce, counter), buf[:n], ad)
+		if _, err = w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// lastChunk returns true when the chunk read is the last one: it's not full, or nothing follows it
+func lastChunk(r *bufio.Reader, readErr error) (bool, error) {
+	if readErr != nil {
+		return true, nil
+	}
+	if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// decryptChunks opens the chunks sealed by encryptChunks
+func decryptChunks(w io.Writer, r *bufio.Reader, aead cipher.AEAD, header *Header, headerData []byte) error {
+	buf := make([]byte, header.ChunkSize+aead.Overhead())
+	data, lastData := chunkData(headerData)
+	var plain []byte
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			if errors.Is(err, io.EOF) {
+				return errors.New("the encrypted file is truncated")
+			}
+			return err
+		}
+		last, err := lastChunk(r, err)
+		if err != nil {
+			return err
+		}
+		ad := data
+		if last {
+			ad = lastData
+		}
+		plain, err = aead.Open(plain[:0], chunkNonce(header.Nonce, counter), buf[:n], ad)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt chunk %d: the file is corrupted or truncated", counter)
+		}
+		if _, err = w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
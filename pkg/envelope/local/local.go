@@ -0,0 +1,130 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package local
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/envelope"
+	"os"
+)
+
+// Name is the name of the local key provider
+const Name = "local"
+
+type localProvider struct {
+	key   []byte
+	keyID string
+}
+
+// Config holds the local key provider config
+type Config struct {
+	// KeyFile holds a 256-bit key: 32 raw bytes, or encoded in base64 or hex
+	KeyFile string
+}
+
+// NewProvider creates a key provider wrapping the data keys with the key of a local key file
+func NewProvider(conf Config) (envelope.KeyProvider, error) {
+	data, err := os.ReadFile(conf.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the key file: %w", err)
+	}
+	key, err := parseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", conf.KeyFile, err)
+	}
+	sum := sha256.Sum256(key)
+	return &localProvider{
+		key:   key,
+		keyID: "sha256:" + hex.EncodeToString(sum[:8]),
+	}, nil
+}
+
+// parseKey decodes a 256-bit key
+func parseKey(data []byte) ([]byte, error) {
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("expected a 256-bit key, raw or encoded in base64 or hex")
+}
+
+func (p *localProvider) Name() string {
+	return Name
+}
+
+// KeyID returns the fingerprint of the key
+func (p *localProvider) KeyID() string {
+	return p.keyID
+}
+
+// WrapKey encrypts a data key with AES-256-GCM, the nonce is prepended to the wrapped key
+func (p *localProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	aead, err := p.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(p.keyID)), nil
+}
+
+func (p *localProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	aead, err := p.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, errors.New("invalid wrapped key")
+	}
+	dataKey, err := aead.Open(nil, wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():], []byte(p.keyID))
+	if err != nil {
+		return nil, fmt.Errorf("the data key was not wrapped with the key %s", p.keyID)
+	}
+	return dataKey, nil
+}
+
+func (p *localProvider) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(p.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
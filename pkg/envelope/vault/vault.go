@@ -0,0 +1,175 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/envelope"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Name is the name of the Vault transit key provider
+const Name = "vault"
+
+type vaultProvider struct {
+	client  *http.Client
+	address string
+	token   string
+	conf    Config
+}
+
+// Config holds the Vault transit key provider config
+type Config struct {
+	Address   string
+	Token     string
+	Namespace string
+	// Mount is the path of the transit secrets engine, transit by default
+	Mount string
+	// Key is the name of the transit key
+	Key string
+	// CACert is the CA certificate file verifying the Vault server certificate
+	CACert string
+}
+
+// NewProvider creates a key provider wrapping the data keys with a key of a Vault transit engine
+func NewProvider(conf Config) (envelope.KeyProvider, error) {
+	if conf.Address == "" || conf.Token == "" {
+		return nil, errors.New("the Vault address and token are required")
+	}
+	if conf.Key == "" {
+		return nil, errors.New("the Vault transit key is required")
+	}
+	if conf.Mount == "" {
+		conf.Mount = "transit"
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.CACert != "" {
+		pem, err := os.ReadFile(conf.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the Vault CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid Vault CA certificate %s", conf.CACert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &vaultProvider{
+		client:  &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		address: strings.TrimSuffix(conf.Address, "/"),
+		token:   conf.Token,
+		conf:    conf,
+	}, nil
+}
+
+// ParseKeyID returns the mount and the key name of a key ID
+func ParseKeyID(keyID string) (mount, key string, err error) {
+	mount, key = path.Split(keyID)
+	mount = strings.TrimSuffix(strings.TrimSuffix(mount, "/"), "/keys")
+	if mount == "" || key == "" {
+		return "", "", fmt.Errorf("invalid Vault key ID %q", keyID)
+	}
+	return mount, key, nil
+}
+
+func (p *vaultProvider) Name() string {
+	return Name
+}
+
+// KeyID returns the path of the transit key, e.g. transit/keys/backups
+func (p *vaultProvider) KeyID() string {
+	return path.Join(p.conf.Mount, "keys", p.conf.Key)
+}
+
+// WrapKey encrypts a data key with the transit key, the wrapped key is the Vault ciphertext
+func (p *vaultProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	var data struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := p.request("encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data.Ciphertext), nil
+}
+
+func (p *vaultProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	var data struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := p.request("decrypt", map[string]string{"ciphertext": string(wrappedKey)}, &data); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(data.Plaintext)
+}
+
+// request calls an operation of the transit key and decodes the data of the response
+func (p *vaultProvider) request(operation string, body map[string]string, data any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", p.address, p.conf.Mount, operation, p.conf.Key)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", p.token)
+	if p.conf.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.conf.Namespace)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil && resp.StatusCode == http.StatusOK {
+		return fmt.Errorf("invalid Vault response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("vault %s failed: %s", operation, strings.Join(result.Errors, ", "))
+		}
+		return fmt.Errorf("vault %s failed: %s", operation, resp.Status)
+	}
+	return json.Unmarshal(result.Data, data)
+}
@@ -0,0 +1,176 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadGPGKeyRing reads the keys of the key files into a key ring, a file can hold several
+// armored or binary keys. Private keys are unlocked with the passphrase, a private key that
+// can't be unlocked is skipped with a warning.
+func loadGPGKeyRing(files []string, passphrase string) (*crypto.KeyRing, error) {
+	keyRing, err := crypto.NewKeyRing(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, keyFile := range files {
+		entities, err := readGPGKeyFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file %s: %w", keyFile, err)
+		}
+		for _, entity := range entities {
+			key, err := crypto.NewKeyFromEntity(entity)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key file %s: %w", keyFile, err)
+			}
+			if key, err = unlockGPGKey(key, passphrase); err != nil {
+				utils.Warn("Skipping key %s of %s: %v", key.GetFingerprint(), keyFile, err)
+				continue
+			}
+			if err = keyRing.AddKey(key); err != nil {
+				return nil, fmt.Errorf("invalid key file %s: %w", keyFile, err)
+			}
+		}
+	}
+	if keyRing.CountEntities() == 0 {
+		return nil, errors.New("no usable key found")
+	}
+	return keyRing, nil
+}
+
+// readGPGKeyFile reads the keys of a binary key file, or of all the armored blocks of an armored key file
+func readGPGKeyFile(keyFile string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		return openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	var entities openpgp.EntityList
+	// The armor decoder reads ahead of its block, the blocks are decoded one by one
+	blocks := strings.Split(string(data), "-----BEGIN PGP")
+	for _, text := range blocks[1:] {
+		block, err := armor.Decode(strings.NewReader("-----BEGIN PGP" + text))
+		if err != nil {
+			return nil, err
+		}
+		blockEntities, err := openpgp.ReadKeyRing(block.Body)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, blockEntities...)
+	}
+	return entities, nil
+}
+
+// unlockGPGKey unlocks a locked private key with the passphrase, other keys are returned unchanged
+func unlockGPGKey(key *crypto.Key, passphrase string) (*crypto.Key, error) {
+	if !key.IsPrivate() {
+		return key, nil
+	}
+	locked, err := key.IsLocked()
+	if err != nil || !locked {
+		return key, err
+	}
+	if passphrase == "" {
+		return key, errors.New("the private key is locked, set GPG_PASSPHRASE")
+	}
+	unlocked, err := key.Unlock([]byte(passphrase))
+	if err != nil {
+		return key, err
+	}
+	return unlocked, nil
+}
+
+// gpgEncrypt encrypts a file of the directory dir for all the keys of the key ring
+func gpgEncrypt(dir, fileName, outputName string, keyRing *crypto.KeyRing) error {
+	in, err := os.Open(filepath.Join(dir, fileName))
+	if err != nil {
+		return err
+	}
+	defer func(in *os.File) {
+		err := in.Close()
+		if err != nil {
+			return
+		}
+	}(in)
+	out, err := os.Create(filepath.Join(dir, outputName))
+	if err != nil {
+		return err
+	}
+	w, err := keyRing.EncryptStream(out, crypto.NewPlainMessageMetadata(true, fileName, 0), nil)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	if _, err = io.Copy(w, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// gpgDecrypt decrypts a file with the first matching private key of the key ring
+func gpgDecrypt(filePath, outputFile string, keyRing *crypto.KeyRing) error {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func(in *os.File) {
+		err := in.Close()
+		if err != nil {
+			return
+		}
+	}(in)
+	r, err := keyRing.DecryptStream(in, nil, 0)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -43,10 +43,15 @@ func intro() {
 	fmt.Println("Copyright (c) 2024 Jonas Kaninda")
 }
 
-// copyToTmp copy file to temporary directory
+// deleteTemp deletes the files of the temporary directory
 func deleteTemp() {
-	utils.Info("Deleting %s ...", tmpPath)
-	err := filepath.Walk(tmpPath, func(path string, info os.FileInfo, err error) error {
+	deleteTempDir(tmpPath)
+}
+
+// deleteTempDir deletes the files of a temporary directory
+func deleteTempDir(dir string) {
+	utils.Info("Deleting %s ...", dir)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -63,7 +68,7 @@ func deleteTemp() {
 	if err != nil {
 		utils.Error("Error deleting files: %v", err)
 	} else {
-		utils.Info("Deleting %s ... done", tmpPath)
+		utils.Info("Deleting %s ... done", dir)
 	}
 }
 
@@ -75,10 +80,10 @@ func testDatabaseConnection(db *dbConfig) error {
 	}
 	utils.Info("Connecting to %s database ...", db.dbName)
 	// Set database name for notification error
-	utils.DatabaseName = db.dbName
+	utils.SetDatabaseName(db.dbName)
 
 	// Prepare the command to test the database connection
-	cmd := exec.Command("mariadb", fmt.Sprintf("--defaults-file=%s", mysqlClientConfig), db.dbName, "-e", "quit")
+	cmd := exec.Command("mariadb", fmt.Sprintf("--defaults-file=%s", db.clientConfigFile()), db.dbName, "-e", "quit")
 	// Capture the output
 	var out bytes.Buffer
 	cmd.Stdout = &out
@@ -93,53 +98,66 @@ func testDatabaseConnection(db *dbConfig) error {
 	return nil
 }
 
-// checkPubKeyFile checks gpg public key
-func checkPubKeyFile(pubKey string) (string, error) {
-	// Define possible key file names
-	keyFiles := []string{filepath.Join(gpgHome, "public_key.asc"), filepath.Join(gpgHome, "public_key.gpg"), pubKey}
+// checkPubKeyFiles returns the gpg public key files: the comma separated files and directories
+// of pubKeys, or the default public key file
+func checkPubKeyFiles(pubKeys string) ([]string, error) {
+	return checkKeyFiles(pubKeys, "public_key")
+}
 
-	// Loop through key file names and check if they exist
-	for _, keyFile := range keyFiles {
+// checkPrKeyFiles returns the gpg private key files: the comma separated files and directories
+// of prKeys, or the default private key file
+func checkPrKeyFiles(prKeys string) ([]string, error) {
+	return checkKeyFiles(prKeys, "private_key")
+}
+
+func checkKeyFiles(value, defaultName string) ([]string, error) {
+	if value != "" {
+		return keyFiles(value)
+	}
+	// Define possible key file names
+	for _, keyFile := range []string{filepath.Join(gpgHome, defaultName+".asc"), filepath.Join(gpgHome, defaultName+".gpg")} {
 		if _, err := os.Stat(keyFile); err == nil {
 			// File exists
-			return keyFile, nil
-		} else if os.IsNotExist(err) {
-			// File does not exist, continue to the next one
-			continue
-		} else {
+			return []string{keyFile}, nil
+		} else if !os.IsNotExist(err) {
 			// An unexpected error occurred
-			return "", err
+			return nil, err
 		}
 	}
-
-	// Return an error if neither file exists
-	return "", fmt.Errorf("no public key file found")
+	return nil, fmt.Errorf("no %s file found", strings.ReplaceAll(defaultName, "_", " "))
 }
 
-// checkPrKeyFile checks private key
-func checkPrKeyFile(prKey string) (string, error) {
-	// Define possible key file names
-	keyFiles := []string{filepath.Join(gpgHome, "private_key.asc"), filepath.Join(gpgHome, "private_key.gpg"), prKey}
-
-	// Loop through key file names and check if they exist
-	for _, keyFile := range keyFiles {
-		if _, err := os.Stat(keyFile); err == nil {
-			// File exists
-			return keyFile, nil
-		} else if os.IsNotExist(err) {
-			// File does not exist, continue to the next one
+// keyFiles returns the files of a comma separated list of key files and directories,
+// the files of a directory are sorted by name and hidden files are ignored
+func keyFiles(value string) ([]string, error) {
+	var files []string
+	for _, path := range splitList(value) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
 			continue
-		} else {
-			// An unexpected error occurred
-			return "", err
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
 		}
 	}
-
-	// Return an error if neither file exists
-	return "", fmt.Errorf("no public key file found")
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no key file found in %s", value)
+	}
+	return files, nil
 }
 
-// readConf reads config file and returns Config
+// readConf reads a YAML or JSON config file and returns Config, the environment variable
+// references of the values are replaced
 func readConf(configFile string) (*Config, error) {
 	if utils.FileExists(configFile) {
 		buf, err := os.ReadFile(configFile)
@@ -147,13 +165,21 @@ func readConf(configFile string) (*Config, error) {
 			return nil, err
 		}
 
+		var node yaml.Node
+		if err = yaml.Unmarshal(buf, &node); err != nil {
+			return nil, fmt.Errorf("in file %q: %w", configFile, err)
+		}
+		if err = interpolateNode(&node); err != nil {
+			return nil, fmt.Errorf("in file %q: %w", configFile, err)
+		}
 		c := &Config{}
-		err = yaml.Unmarshal(buf, c)
-		if err != nil {
+		if err = node.Decode(c); err != nil {
 			return nil, fmt.Errorf("in file %q: %w", configFile, err)
 		}
-
-		return c, err
+		if err = c.validate(); err != nil {
+			return nil, fmt.Errorf("in file %q: %w", configFile, err)
+		}
+		return c, nil
 	}
 	return nil, fmt.Errorf("config file %q not found", configFile)
 }
@@ -163,7 +189,7 @@ func checkConfigFile(filePath string) (string, error) {
 	// Remove the quotes
 	filePath = strings.Trim(filePath, `"`)
 	// Define possible config file names
-	configFiles := []string{filepath.Join(workingDir, "config.yaml"), filepath.Join(workingDir, "config.yml"), filePath}
+	configFiles := []string{filepath.Join(workingDir, "config.yaml"), filepath.Join(workingDir, "config.yml"), filepath.Join(workingDir, "config.json"), filePath}
 
 	// Loop through config file names and check if they exist
 	for _, configFile := range configFiles {
@@ -194,10 +220,73 @@ func createMysqlClientConfigFile(db dbConfig) error {
 	caCertPath := goutils.GetStringEnvWithDefault("DB_SSL_CA", "/etc/ssl/certs/ca-certificates.crt")
 	sslMode := goutils.GetStringEnvWithDefault("DB_SSL_MODE", "0")
 	// Create the mysql client config file
-	mysqlClientConfigFile := filepath.Join(tmpPath, "my.cnf")
+	mysqlClientConfigFile := db.clientConfigFile()
 	mysqlCl := fmt.Sprintf("[client]\nhost=%s\nport=%s\nuser=%s\npassword=%s\nssl-ca=%s\nssl=%s\n", db.dbHost, db.dbPort, db.dbUserName, db.dbPassword, caCertPath, sslMode)
 	if err := os.WriteFile(mysqlClientConfigFile, []byte(mysqlCl), 0644); err != nil {
 		return fmt.Errorf("failed to create mysql client config file: %v", err)
 	}
 	return nil
 }
+
+// querySQL runs a query and returns the result rows, columns are tab separated in batch mode
+func querySQL(query string) ([][]string, error) {
+	return queryClientSQL(mysqlClientConfig, query)
+}
+
+// queryClientSQL runs a query with a mysql client config file and returns the result rows
+func queryClientSQL(clientConfig, query string) ([][]string, error) {
+	cmd := exec.Command("mariadb", fmt.Sprintf("--defaults-file=%s", clientConfig), "-N", "-B", "-e", query)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("query failed: %v, output: %s", err, stderr.String())
+	}
+	var rows [][]string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		columns := strings.Split(line, "\t")
+		for i, column := range columns {
+			columns[i] = unescapeBatchValue(column)
+		}
+		rows = append(rows, columns)
+	}
+	return rows, nil
+}
+
+// execSQL runs a SQL script on the given database, the script is sent through stdin
+// so it can use the client DELIMITER command
+func execSQL(database, script string) error {
+	args := []string{fmt.Sprintf("--defaults-file=%s", mysqlClientConfig)}
+	if database != "" {
+		args = append(args, database)
+	}
+	cmd := exec.Command("mariadb", args...)
+	cmd.Stdin = strings.NewReader(script)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// unescapeBatchValue reverts the escaping applied by the mariadb client in batch mode
+func unescapeBatchValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	replacer := strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\0`, "\x00", `\\`, `\`)
+	return replacer.Replace(value)
+}
+
+// quoteIdentifier quotes a database object name
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// quoteString quotes a SQL string literal
+func quoteString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
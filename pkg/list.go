@@ -0,0 +1,57 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// StartList lists the backups stored in a storage
+func StartList(cmd *cobra.Command) {
+	conf := initListConfig(cmd)
+	bkStorage, err := openStorage(conf.storage, conf.remotePath)
+	if err != nil {
+		utils.Fatal("Error creating %s storage: %s", conf.storage, err)
+	}
+	files, err := bkStorage.List()
+	if err != nil {
+		utils.Fatal("Error listing backups from %s storage: %s", bkStorage.Name(), err)
+	}
+	backups := filterBackups(files, conf.dbName, conf.since)
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ModTime.After(backups[j].ModTime)
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tSIZE\tCREATED")
+	for _, backup := range backups {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", backup.Name, utils.ConvertBytes(uint64(backup.Size)), backup.ModTime.Local().Format(timeFormat))
+	}
+	_ = w.Flush()
+}
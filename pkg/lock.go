@@ -0,0 +1,259 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// overlapSkip skips a scheduled run while the previous run of the job is still running
+	overlapSkip = "skip"
+	// overlapQueue runs a scheduled run once the previous run is completed, at most one run is queued
+	overlapQueue = "queue"
+	// overlapDelay runs every scheduled run once the previous runs are completed
+	overlapDelay = "delay"
+
+	lockNone     = "none"
+	lockFile     = "file"
+	lockDatabase = "database"
+	// lockKeepAlive is the interval of the queries keeping the session of a database lock alive
+	lockKeepAlive = time.Minute
+)
+
+// errBackupLocked is the error of the backups skipped because another instance is backing up the database
+var errBackupLocked = errors.New("the database is being backed up by another instance")
+
+// parseOverlapPolicy checks the overlap policy of scheduled backups, the default policy is skip
+func parseOverlapPolicy(value string) (string, error) {
+	switch policy := strings.ToLower(strings.TrimSpace(value)); policy {
+	case "":
+		return overlapSkip, nil
+	case overlapSkip, overlapQueue, overlapDelay:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid overlap policy %q, expected skip, queue or delay", value)
+	}
+}
+
+// parseLockMode checks the lock of the backups, it's disabled by default
+func parseLockMode(value string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case "":
+		return lockNone, nil
+	case lockNone, lockFile, lockDatabase:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid lock %q, expected none, file or database", value)
+	}
+}
+
+// scheduledJob is a job of the scheduler, it applies the overlap policy when a run is
+// due while the previous run is still running
+type scheduledJob struct {
+	name   string
+	policy string
+	run    func()
+	// running is held while the job runs
+	running sync.Mutex
+	queued  atomic.Bool
+}
+
+// newScheduledJob creates a scheduled job with an overlap policy
+func newScheduledJob(name, policy string, run func()) *scheduledJob {
+	return &scheduledJob{name: name, policy: policy, run: run}
+}
+
+// Run implements cron.Job
+func (j *scheduledJob) Run() {
+	if !j.running.TryLock() {
+		switch j.policy {
+		case overlapQueue:
+			if !j.queued.CompareAndSwap(false, true) {
+				utils.Warn("The previous backup of %s is still running and another one is queued, this run is skipped", j.name)
+				return
+			}
+			utils.Warn("The previous backup of %s is still running, this run is queued", j.name)
+			j.running.Lock()
+			j.queued.Store(false)
+		case overlapDelay:
+			utils.Warn("The previous backup of %s is still running, this run is delayed", j.name)
+			j.running.Lock()
+		default:
+			utils.Warn("The previous backup of %s is still running, this run is skipped", j.name)
+			return
+		}
+	}
+	defer j.running.Unlock()
+	j.run()
+}
+
+// acquireBackupLock locks the backup of a database so two instances never back up the same
+// database at the same time, it returns errBackupLocked when another instance holds the lock
+func acquireBackupLock(db *dbConfig, config *BackupConfig, name string) (release func(), err error) {
+	switch config.lockMode {
+	case lockFile:
+		return acquireFileLock(config.lockDir, name)
+	case lockDatabase:
+		return acquireDatabaseLock(db, name)
+	default:
+		return func() {}, nil
+	}
+}
+
+// lockOwner describes the instance holding a lock
+func lockOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s (pid %d) since %s", hostname, os.Getpid(), time.Now().Format(timeFormat))
+}
+
+// acquireFileLock locks the file of the database in the lock directory of a shared volume, the
+// lock is released by the system when the process exits
+func acquireFileLock(dir, name string) (func(), error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating lock directory: %w", err)
+	}
+	path := filepath.Join(dir, sanitizeName(name)+".lock")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %w", err)
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		owner, _ := io.ReadAll(file)
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			if owner := strings.TrimSpace(string(owner)); owner != "" {
+				return nil, fmt.Errorf("%w: %s is locked by %s", errBackupLocked, path, owner)
+			}
+			return nil, fmt.Errorf("%w: %s is locked", errBackupLocked, path)
+		}
+		return nil, fmt.Errorf("error locking %s: %w", path, err)
+	}
+	// The owner is informative, the lock is the flock of the file
+	if err = file.Truncate(0); err == nil {
+		_, err = file.WriteAt([]byte(lockOwner()+"\n"), 0)
+	}
+	if err != nil {
+		utils.Warn("Error writing the owner of the lock %s: %v", path, err)
+	}
+	utils.Info("Lock %s acquired", path)
+	return func() {
+		_ = file.Truncate(0)
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		_ = file.Close()
+	}, nil
+}
+
+// acquireDatabaseLock takes a named lock of the database server with GET_LOCK, the lock is held by
+// a client session which stays open during the backup, the server releases it when the session ends
+func acquireDatabaseLock(db *dbConfig, name string) (func(), error) {
+	if err := createMysqlClientConfigFile(*db); err != nil {
+		return nil, err
+	}
+	lockName := databaseLockName(name)
+	cmd := exec.Command("mariadb", fmt.Sprintf("--defaults-file=%s", db.clientConfigFile()), "-N", "-B", "--unbuffered")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting mariadb: %w", err)
+	}
+	closeSession := func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	}
+	if _, err = fmt.Fprintf(stdin, "SELECT GET_LOCK(%s, 0);\n", quoteString(lockName)); err != nil {
+		closeSession()
+		return nil, fmt.Errorf("error taking the lock %s: %w", lockName, err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		closeSession()
+		return nil, fmt.Errorf("error taking the lock %s: %v, output: %s", lockName, err, stderr.String())
+	}
+	if strings.TrimSpace(line) != "1" {
+		closeSession()
+		return nil, fmt.Errorf("%w: the lock %s is held by another session", errBackupLocked, lockName)
+	}
+	utils.Info("Lock %s acquired", lockName)
+	// Idle sessions are closed by the server after wait_timeout, the lock would be released
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := fmt.Fprintln(stdin, "DO 0;"); err != nil {
+					utils.Warn("The session of the lock %s is closed: %v", lockName, err)
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		_, _ = fmt.Fprintf(stdin, "DO RELEASE_LOCK(%s);\n", quoteString(lockName))
+		closeSession()
+	}, nil
+}
+
+// databaseLockName returns the name of the lock of a database, the names of the locks
+// are limited to 64 characters
+func databaseLockName(name string) string {
+	lockName := "mysql-bkup:" + name
+	if len(lockName) > 64 {
+		sum := sha256.Sum256([]byte(name))
+		lockName = "mysql-bkup:" + hex.EncodeToString(sum[:])[:32]
+	}
+	return lockName
+}
@@ -0,0 +1,176 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/envelope"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestExtension is appended to the backup file name to name its manifest
+const manifestExtension = ".manifest.json"
+const manifestVersion = 1
+
+// backupManifest describes a backup file, it's stored next to the backup
+type backupManifest struct {
+	Version    int       `json:"version"`
+	File       string    `json:"file"`
+	Database   string    `json:"database"`
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum"`
+	Compressed bool      `json:"compressed"`
+	Encrypted  bool      `json:"encrypted"`
+	Reference  string    `json:"reference,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// Envelope is the header of an envelope encrypted backup, it holds the wrapped data key
+	Envelope *envelope.Header `json:"envelope,omitempty"`
+	// Parts are the volumes of a split backup, Size and Checksum are those of the joined file
+	Parts []manifestPart `json:"parts,omitempty"`
+}
+
+// manifestPart describes a volume of a split backup
+type manifestPart struct {
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// verifyChecksum returns the checksum of a file downloaded from a storage, an error is returned
+// when the file has an expected checksum and it doesn't match
+func verifyChecksum(part manifestPart, st storage.Storage) (string, error) {
+	checksum, err := fileChecksum(filepath.Join(tmpPath, part.File))
+	if err != nil {
+		return "", err
+	}
+	if part.Checksum != "" && part.Checksum != checksum {
+		return "", fmt.Errorf("checksum mismatch of %s on %s storage: expected %s, got %s", part.File, st.Name(), part.Checksum, checksum)
+	}
+	return checksum, nil
+}
+
+// manifestName returns the manifest file name of a backup file
+func manifestName(fileName string) string {
+	return fileName + manifestExtension
+}
+
+// isManifest returns true if the file is a backup manifest
+func isManifest(fileName string) bool {
+	return strings.HasSuffix(fileName, manifestExtension)
+}
+
+// writeManifest creates the manifest of a backup file located in the temporary directory of the backup
+func writeManifest(db *dbConfig, config *BackupConfig, fileName string) (string, error) {
+	filePath := filepath.Join(config.workDir(), fileName)
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	checksum, err := fileChecksum(filePath)
+	if err != nil {
+		return "", err
+	}
+	header, err := envelopeHeader(filePath)
+	if err != nil {
+		return "", err
+	}
+	manifest := backupManifest{
+		Version:    manifestVersion,
+		File:       fileName,
+		Database:   db.dbName,
+		Size:       fileInfo.Size(),
+		Checksum:   checksum,
+		Compressed: !config.disableCompression,
+		Encrypted:  config.encryption,
+		Reference:  os.Getenv("BACKUP_REFERENCE"),
+		CreatedAt:  time.Now().UTC(),
+		Envelope:   header,
+	}
+	if config.all && config.allInOne {
+		manifest.Database = "all_databases"
+	}
+	return manifestName(fileName), saveManifest(&manifest, filepath.Join(config.workDir(), manifestName(fileName)))
+}
+
+func saveManifest(manifest *backupManifest, filePath string) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// readManifest reads a manifest file
+func readManifest(filePath string) (*backupManifest, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(data, filepath.Base(filePath))
+}
+
+// parseManifest parses the content of the manifest file name
+func parseManifest(data []byte, name string) (*backupManifest, error) {
+	manifest := &backupManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", name, err)
+	}
+	return manifest, nil
+}
+
+// fileChecksum returns the SHA-256 checksum of a file, prefixed by the algorithm
+func fileChecksum(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			utils.Error("Error closing file: %v", err)
+		}
+	}(f)
+	checksum, _, err := readerChecksum(f)
+	return checksum, err
+}
+
+// readerChecksum returns the SHA-256 checksum, prefixed by the algorithm, and the size of the content of r
+func readerChecksum(r io.Reader) (string, int64, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), size, nil
+}
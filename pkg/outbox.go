@@ -0,0 +1,176 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outboxExtension is appended to the backup file name to name its outbox entry
+const outboxExtension = ".outbox.json"
+
+// outboxEntry describes a backup kept in the outbox after a failed upload,
+// the upload is attempted again on the next backup run
+type outboxEntry struct {
+	Storage    string            `json:"storage"`
+	RemotePath string            `json:"remotePath"`
+	Files      []string          `json:"files"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Attempts   int               `json:"attempts"`
+	CreatedAt  time.Time         `json:"createdAt"`
+}
+
+// outboxDir returns the outbox directory, the outbox is disabled when it's empty
+func outboxDir() string {
+	return os.Getenv("BACKUP_OUTBOX_DIR")
+}
+
+// saveToOutbox moves the backup files from the temporary directory of the backup to the outbox
+func saveToOutbox(config *BackupConfig, files []string, tags map[string]string) error {
+	dir := outboxDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := moveFile(filepath.Join(config.workDir(), name), filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to move %s to the outbox: %w", name, err)
+		}
+	}
+	entry := outboxEntry{
+		Storage:    config.storage,
+		RemotePath: config.remotePath,
+		Files:      files,
+		Tags:       tags,
+		Attempts:   1,
+		CreatedAt:  time.Now().UTC(),
+	}
+	return saveOutboxEntry(&entry, filepath.Join(dir, files[0]+outboxExtension))
+}
+
+func saveOutboxEntry(entry *outboxEntry, filePath string) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// outboxMu prevents concurrent backups from uploading the outbox at the same time
+var outboxMu sync.Mutex
+
+// flushOutbox uploads the backups kept in the outbox, they are removed from the outbox once uploaded,
+// it returns at once when another backup is uploading the outbox
+func flushOutbox() {
+	dir := outboxDir()
+	if dir == "" {
+		return
+	}
+	if !outboxMu.TryLock() {
+		utils.Debug("The outbox is already being uploaded by another backup, skipping")
+		return
+	}
+	defer outboxMu.Unlock()
+	entries, err := filepath.Glob(filepath.Join(dir, "*"+outboxExtension))
+	if err != nil || len(entries) == 0 {
+		return
+	}
+	utils.Info("Uploading %d backups from the outbox...", len(entries))
+	for _, entryPath := range entries {
+		if err := flushOutboxEntry(entryPath); err != nil {
+			utils.Error("Error uploading %s from the outbox: %v", strings.TrimSuffix(filepath.Base(entryPath), outboxExtension), err)
+		}
+	}
+}
+
+func flushOutboxEntry(entryPath string) error {
+	data, err := os.ReadFile(entryPath)
+	if err != nil {
+		return err
+	}
+	entry := &outboxEntry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return fmt.Errorf("invalid outbox entry %s: %w", filepath.Base(entryPath), err)
+	}
+	bkStorage, err := newStorage(entry.Storage, entry.RemotePath)
+	if err != nil {
+		return err
+	}
+	if tagger, ok := bkStorage.(storage.Tagger); ok && len(entry.Tags) > 0 {
+		tagger.SetTags(entry.Tags)
+	}
+	dir := filepath.Dir(entryPath)
+	for _, name := range entry.Files {
+		if err = copyFile(filepath.Join(dir, name), filepath.Join(tmpPath, name)); err != nil {
+			return err
+		}
+		err = bkStorage.Copy(name)
+		_ = os.Remove(filepath.Join(tmpPath, name))
+		if err != nil {
+			entry.Attempts++
+			if saveErr := saveOutboxEntry(entry, entryPath); saveErr != nil {
+				utils.Error("Error updating outbox entry: %v", saveErr)
+			}
+			return fmt.Errorf("attempt %d failed: %w", entry.Attempts, err)
+		}
+	}
+	for _, name := range entry.Files {
+		if err = os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	utils.Info("Backup %s uploaded from the outbox to %s storage", entry.Files[0], bkStorage.Name())
+	return os.Remove(entryPath)
+}
+
+// moveFile moves a file, it's copied when the directories are on different file systems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies a file and keeps its modification time, resumable uploads rely on it
+func copyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err = utils.CopyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
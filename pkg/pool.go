@@ -0,0 +1,198 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	goutils "github.com/jkaninda/go-utils"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// errKeptInOutbox is the error of the backups kept in the outbox after a failed upload
+var errKeptInOutbox = errors.New("the backup is kept in the outbox")
+
+// backupJob is the backup of a database with its own settings
+type backupJob struct {
+	db     *dbConfig
+	config *BackupConfig
+}
+
+// backupResult is the result of the backup of a database
+type backupResult struct {
+	database string
+	file     string
+	size     int64
+	location string
+	duration time.Duration
+	err      error
+}
+
+// backupPool runs the backup jobs, at most concurrency jobs at the same time
+type backupPool struct {
+	slots chan struct{}
+}
+
+// newBackupPool creates a backup pool, the jobs run one at a time when concurrency is lower than 2
+func newBackupPool(concurrency int) *backupPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > 1 {
+		// The progress bars of concurrent backups would overwrite each other
+		progressBarDisabled.Store(true)
+	}
+	return &backupPool{slots: make(chan struct{}, concurrency)}
+}
+
+// run runs a job once a slot of the pool is free
+func (p *backupPool) run(job backupJob) backupResult {
+	p.slots <- struct{}{}
+	defer func() {
+		<-p.slots
+	}()
+	return job.run()
+}
+
+// runAll runs the jobs and returns their results in the order of the jobs
+func (p *backupPool) runAll(jobs []backupJob) []backupResult {
+	results := make([]backupResult, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = p.run(job)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// run backs up the database of the job in its own temporary directory with its own mysql client
+// config file, so the jobs can run at the same time
+func (j backupJob) run() backupResult {
+	db := *j.db
+	config := *j.config
+	dir, err := jobTempDir(db.dbName)
+	if err != nil {
+		return backupResult{database: db.dbName, err: fmt.Errorf("error creating temporary directory: %w", err)}
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			utils.Error("Error deleting %s: %v", dir, err)
+		}
+	}()
+	db.clientConfig = filepath.Join(dir, "my.cnf")
+	config.tmpDir = dir
+	return storageBackup(&db, &config)
+}
+
+// jobTempDir creates the temporary directory of a backup job in the temporary directory
+func jobTempDir(dbName string) (string, error) {
+	if err := os.MkdirAll(tmpPath, 0755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(tmpPath, sanitizeName(dbName)+"-")
+}
+
+// sanitizeName replaces the characters of a database name which aren't letters, digits, - or _
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// runBackupJobs runs the backup jobs in the pool and prints the summary of their results, the failed
+// backups are fatal unless the rescue mode is enabled or they are kept in the outbox of a scheduled backup,
+// it returns true when all the backups succeeded
+func runBackupJobs(pool *backupPool, jobs []backupJob) bool {
+	utils.Info("Backing up %d databases, %d at a time...", len(jobs), cap(pool.slots))
+	startTime := time.Now()
+	results := pool.runAll(jobs)
+	printBackupSummary(results, time.Since(startTime))
+
+	var failed, errs []string
+	fatal, succeeded := false, true
+	for i, result := range results {
+		if result.err != nil {
+			succeeded = false
+		}
+		if result.err == nil || errors.Is(result.err, errBackupLocked) {
+			continue
+		}
+		failed = append(failed, result.database)
+		errs = append(errs, fmt.Sprintf("%s: %v", result.database, result.err))
+		if !errors.Is(result.err, errKeptInOutbox) || jobs[i].config.cronExpression == "" {
+			fatal = true
+		}
+	}
+	if len(failed) == 0 {
+		return succeeded
+	}
+	msg := fmt.Sprintf("%d of %d backups failed, %s", len(failed), len(results), strings.Join(errs, "; "))
+	utils.Error("%s", msg)
+	utils.NotifyDatabaseError(strings.Join(failed, ", "), msg)
+	if fatal && !backupRescueMode {
+		os.Exit(1)
+	}
+	return false
+}
+
+// printBackupSummary prints the result of each backup
+func printBackupSummary(results []backupResult, duration time.Duration) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "DATABASE\tSTATUS\tSIZE\tDURATION\tDETAIL")
+	succeeded, skipped := 0, 0
+	for _, result := range results {
+		status, size, detail := "OK", utils.ConvertBytes(uint64(result.size)), result.location
+		switch {
+		case errors.Is(result.err, errBackupLocked):
+			status, size, detail = "SKIPPED", "-", result.err.Error()
+			skipped++
+		case result.err != nil:
+			status, detail = "FAILED", result.err.Error()
+			if result.size == 0 {
+				size = "-"
+			}
+		default:
+			succeeded++
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.database, status, size,
+			goutils.FormatDuration(result.duration.Round(time.Millisecond), 0), strings.Join(strings.Fields(detail), " "))
+	}
+	fmt.Println()
+	_ = w.Flush()
+	fmt.Printf("\n%d backups: %d succeeded, %d skipped, %d failed in %s\n", len(results), succeeded, skipped, len(results)-succeeded-skipped, goutils.FormatDuration(duration.Round(time.Millisecond), 0))
+}
@@ -0,0 +1,263 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	goutils "github.com/jkaninda/go-utils"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const progressBarWidth = 30
+
+// Comments written by mysqldump before each table
+var tableMarkers = [][]byte{
+	[]byte("-- Dumping data for table `"),
+	[]byte("-- Table structure for table `"),
+}
+
+// progress reports the progress of a backup or a restoration: bytes processed, statements,
+// current table, throughput and ETA. The total is an estimate for backups.
+type progress struct {
+	operation  string
+	total      int64
+	estimated  bool
+	bytes      atomic.Int64
+	statements atomic.Int64
+	mu         sync.Mutex
+	table      string
+	startTime  time.Time
+	interval   time.Duration
+	bar        bool
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// newProgress creates a progress reporter, total is zero when the size is unknown
+func newProgress(operation string, total int64, estimated bool) *progress {
+	interval, err := utils.ParseDuration(utils.EnvWithDefault("PROGRESS_INTERVAL", "30s"))
+	if err != nil {
+		utils.Warn("Invalid PROGRESS_INTERVAL, using default value: %v", err)
+		interval = 30 * time.Second
+	}
+	p := &progress{
+		operation: operation,
+		total:     total,
+		estimated: estimated,
+		startTime: time.Now(),
+		interval:  interval,
+		bar:       progressBarEnabled(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if p.bar && p.interval > 0 {
+		p.interval = time.Second
+	}
+	return p
+}
+
+// progressBarDisabled disables the progress bar while several backups run at the same time
+var progressBarDisabled atomic.Bool
+
+// progressBarEnabled returns true when stdout is attached to a terminal, PROGRESS_BAR=false disables it
+func progressBarEnabled() bool {
+	if progressBarDisabled.Load() || strings.EqualFold(os.Getenv("PROGRESS_BAR"), "false") {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// start reports the progress periodically until finish is called
+func (p *progress) start() {
+	if p.interval <= 0 {
+		close(p.done)
+		return
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.report()
+			}
+		}
+	}()
+}
+
+// finish stops the periodic report
+func (p *progress) finish() {
+	close(p.stop)
+	<-p.done
+	if p.bar && p.interval > 0 {
+		p.report()
+		fmt.Println()
+	}
+	utils.Info("%s: %s processed in %s, %d statements", p.operation, utils.ConvertBytes(uint64(p.bytes.Load())),
+		goutils.FormatDuration(time.Since(p.startTime), 1), p.statements.Load())
+}
+
+func (p *progress) report() {
+	processed := p.bytes.Load()
+	elapsed := time.Since(p.startTime)
+	rate := float64(processed) / elapsed.Seconds()
+	percent, eta := -1.0, ""
+	if p.total > 0 {
+		percent = min(float64(processed)*100/float64(p.total), 99.9)
+		if rate > 0 && processed < p.total {
+			eta = goutils.FormatDuration(time.Duration(float64(p.total-processed)/rate)*time.Second, 0)
+		}
+	}
+	if p.bar {
+		p.printBar(processed, percent, rate, eta)
+		return
+	}
+	parts := []string{utils.ConvertBytes(uint64(processed))}
+	if p.total > 0 {
+		total := utils.ConvertBytes(uint64(p.total))
+		if p.estimated {
+			total = "~" + total
+		}
+		parts[0] = fmt.Sprintf("%s / %s (%.1f%%)", parts[0], total, percent)
+	}
+	parts = append(parts, fmt.Sprintf("%d statements", p.statements.Load()))
+	if table := p.currentTable(); table != "" {
+		parts = append(parts, fmt.Sprintf("table %s", table))
+	}
+	parts = append(parts, fmt.Sprintf("%s/s", utils.ConvertBytes(uint64(rate))))
+	if eta != "" {
+		parts = append(parts, fmt.Sprintf("ETA %s", eta))
+	}
+	utils.Info("%s: %s", p.operation, strings.Join(parts, ", "))
+}
+
+func (p *progress) printBar(processed int64, percent, rate float64, eta string) {
+	bar := strings.Repeat("=", progressBarWidth)
+	label := utils.ConvertBytes(uint64(processed))
+	if percent >= 0 {
+		filled := int(percent * progressBarWidth / 100)
+		bar = strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+		label = fmt.Sprintf("%5.1f%% %s/%s", percent, label, utils.ConvertBytes(uint64(p.total)))
+	}
+	if eta != "" {
+		eta = " ETA " + eta
+	}
+	fmt.Printf("\r\033[K%s [%s] %s %s/s %s%s", p.operation, bar, label, utils.ConvertBytes(uint64(rate)), p.currentTable(), eta)
+}
+
+func (p *progress) currentTable() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.table
+}
+
+func (p *progress) setTable(table string) {
+	p.mu.Lock()
+	p.table = table
+	p.mu.Unlock()
+}
+
+// byteCounter returns a writer counting the processed bytes
+func (p *progress) byteCounter() io.Writer {
+	return &byteCounter{p: p}
+}
+
+// sqlScanner returns a writer counting statements and tracking the current table of a SQL stream
+func (p *progress) sqlScanner() io.Writer {
+	return &sqlScanner{p: p}
+}
+
+type byteCounter struct {
+	p *progress
+}
+
+func (c *byteCounter) Write(b []byte) (int, error) {
+	c.p.bytes.Add(int64(len(b)))
+	return len(b), nil
+}
+
+// sqlScanner only keeps the beginning and the last character of the current line,
+// extended inserts can be several megabytes long.
+type sqlScanner struct {
+	p     *progress
+	start []byte
+	last  byte
+}
+
+func (s *sqlScanner) Write(b []byte) (int, error) {
+	data := b
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			s.append(data)
+			break
+		}
+		s.append(data[:i])
+		s.endLine()
+		data = data[i+1:]
+	}
+	return len(b), nil
+}
+
+func (s *sqlScanner) append(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if room := 128 - len(s.start); room > 0 {
+		s.start = append(s.start, data[:min(room, len(data))]...)
+	}
+	s.last = data[len(data)-1]
+}
+
+func (s *sqlScanner) endLine() {
+	if s.last == ';' {
+		s.p.statements.Add(1)
+	}
+	for _, marker := range tableMarkers {
+		if bytes.HasPrefix(s.start, marker) {
+			name := s.start[len(marker):]
+			if i := bytes.IndexByte(name, '`'); i >= 0 {
+				name = name[:i]
+			}
+			s.p.setTable(string(name))
+		}
+	}
+	s.start = s.start[:0]
+	s.last = 0
+}
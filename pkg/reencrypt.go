@@ -0,0 +1,207 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// StartReencrypt re-encrypts the encrypted backups of a storage for the new recipients,
+// backups are decrypted and encrypted in the temporary directory without a database round-trip
+func StartReencrypt(cmd *cobra.Command) {
+	intro()
+	conf := initReencryptConfig(cmd)
+	st, err := openStorage(conf.storage, conf.remotePath)
+	if err != nil {
+		utils.Fatal("Error creating %s storage: %s", conf.storage, err)
+	}
+	utils.Info("Re-encrypting backups of %s storage using %s...", st.Name(), conf.encryption.encryptionMethod)
+
+	files, err := st.List()
+	if err != nil {
+		utils.Fatal("Error listing backups from %s storage: %s", st.Name(), err)
+	}
+	names := fileNames(files)
+
+	reencrypted, skipped, failed := 0, 0, 0
+	for _, file := range filterBackups(files, conf.dbName, conf.since) {
+		if conf.file != "" && file.Name != conf.file {
+			continue
+		}
+		if ext := filepath.Ext(file.Name); ext != "."+gpgExtension && ext != "."+ageExtension && ext != "."+envelopeExtension {
+			utils.Info("%s is not encrypted, skipping", file.Name)
+			skipped++
+			continue
+		}
+		if err = reencryptBackup(st, conf, file, names[manifestName(file.Name)], names[signatureName(file.Name)]); err != nil {
+			utils.Error("Error re-encrypting %s: %v", file.Name, err)
+			failed++
+			deleteTemp()
+			continue
+		}
+		reencrypted++
+	}
+	deleteTemp()
+	utils.Info("Re-encryption completed: %d re-encrypted, %d skipped, %d failed", reencrypted, skipped, failed)
+	if failed > 0 {
+		utils.Fatal("%d backups could not be re-encrypted on %s storage", failed, st.Name())
+	}
+}
+
+// reencryptBackup downloads and decrypts a backup, encrypts it for the new recipients and replaces it
+// on the storage. A split backup is split again into volumes of the same size, and the manifest
+// is updated with the new file, size and checksum.
+func reencryptBackup(st storage.Storage, conf *ReencryptConfig, file backupFile, hasManifest, hasSignature bool) error {
+	utils.Info("Re-encrypting %s (%s)...", file.Name, utils.ConvertBytes(uint64(file.Size)))
+	if len(file.volumes) > 0 && !hasManifest {
+		return fmt.Errorf("the manifest of the split backup is missing")
+	}
+	if err := downloadBackup(st, file.Name); err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	var manifest *backupManifest
+	if hasManifest {
+		// The manifest of a split backup is downloaded and verified with its volumes
+		if len(file.volumes) == 0 {
+			if err := st.CopyFrom(manifestName(file.Name)); err != nil {
+				return fmt.Errorf("manifest download failed: %w", err)
+			}
+		}
+		var err error
+		if manifest, err = readManifest(filepath.Join(tmpPath, manifestName(file.Name))); err != nil {
+			return err
+		}
+		if _, err = verifyChecksum(manifestPart{File: file.Name, Checksum: manifest.Checksum}, st); err != nil {
+			return err
+		}
+	}
+	// A tampered backup must not be signed again
+	if conf.verificationKeys != nil || conf.requireSignature {
+		if !hasManifest {
+			if conf.requireSignature {
+				return fmt.Errorf("the backup is not signed: manifest not found")
+			}
+			utils.Warn("The backup %s is not signed", file.Name)
+		} else if err := verifyManifestSignature(st, file.Name, conf.verificationKeys, conf.requireSignature); err != nil {
+			return err
+		}
+	}
+
+	decrypted, err := decryptFile(conf.decryption, filepath.Join(tmpPath, file.Name))
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+	newName, err := encryptFile(conf.encryption, filepath.Base(decrypted))
+	if err != nil {
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+	if err = utils.DeleteFile(decrypted); err != nil {
+		return err
+	}
+	newNames := []string{newName}
+	if manifest != nil {
+		var volumeSize int64
+		if len(manifest.Parts) > 0 {
+			volumeSize = manifest.Parts[0].Size
+		}
+		if err = updateManifest(manifest, newName); err != nil {
+			return err
+		}
+		if volumeSize > 0 {
+			if newNames, err = splitBackup(tmpPath, newName, volumeSize); err != nil {
+				return err
+			}
+		}
+		newNames = append(newNames, manifestName(newName))
+		// The manifest changed, it's signed again
+		if conf.encryption.signingKey != nil {
+			signatureFile, err := signManifest(tmpPath, conf.encryption.signingKey, newName)
+			if err != nil {
+				return err
+			}
+			newNames = append(newNames, signatureFile)
+		} else if hasSignature {
+			utils.Warn("The signature of %s is removed, set SIGNING_KEY to sign the re-encrypted backups", file.Name)
+		}
+	}
+
+	// The manifest is uploaded last, once the backup is complete
+	for _, name := range newNames {
+		if err = st.Copy(name); err != nil {
+			return fmt.Errorf("upload failed: %w", err)
+		}
+	}
+	oldNames := file.volumes
+	if len(oldNames) == 0 {
+		oldNames = []string{file.Name}
+	}
+	if hasManifest {
+		oldNames = append(oldNames, manifestName(file.Name))
+	}
+	if hasSignature {
+		oldNames = append(oldNames, signatureName(file.Name))
+	}
+	for _, name := range oldNames {
+		if slices.Contains(newNames, name) {
+			continue
+		}
+		if err = st.Delete(name); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", name, err)
+		}
+	}
+	utils.Info("Re-encrypting %s...done, saved as %s", file.Name, newName)
+	deleteTemp()
+	return nil
+}
+
+// updateManifest updates the manifest of a re-encrypted backup and saves it in the temporary directory,
+// its volumes are removed and added back when the backup is split again
+func updateManifest(manifest *backupManifest, fileName string) error {
+	filePath := filepath.Join(tmpPath, fileName)
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	checksum, err := fileChecksum(filePath)
+	if err != nil {
+		return err
+	}
+	manifest.File = fileName
+	manifest.Size = fileInfo.Size()
+	manifest.Checksum = checksum
+	manifest.Encrypted = true
+	manifest.Envelope, err = envelopeHeader(filePath)
+	if err != nil {
+		return err
+	}
+	manifest.Parts = nil
+	return saveManifest(manifest, filepath.Join(tmpPath, manifestName(fileName)))
+}
@@ -26,203 +26,53 @@ package pkg
 
 import (
 	"fmt"
-	"github.com/jkaninda/go-storage/pkg/ftp"
-	"github.com/jkaninda/go-storage/pkg/ssh"
-	goutils "github.com/jkaninda/go-utils"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/pkg/storage/ftp"
+	"github.com/jkaninda/mysql-bkup/pkg/storage/ssh"
 	"github.com/jkaninda/mysql-bkup/utils"
-
-	"os"
-	"path/filepath"
-	"time"
 )
 
-func sshBackup(db *dbConfig, config *BackupConfig) {
-	utils.Info("Backup database to Remote server")
-	// Backup database
-	err := BackupDatabase(db, config.backupFileName, disableCompression, config.all, config.allInOne)
-	if err != nil {
-		recoverMode(err, "Error backing up database")
-		return
-	}
-	finalFileName := config.backupFileName
-	if config.encryption {
-		encryptBackup(config)
-		finalFileName = fmt.Sprintf("%s.%s", config.backupFileName, "gpg")
-	}
-	utils.Info("Uploading backup archive to remote storage ... ")
-	sshConfig, err := loadSSHConfig()
-	if err != nil {
-		utils.Fatal("Error loading ssh config: %s", err)
-	}
-
-	sshStorage, err := ssh.NewStorage(ssh.Config{
-		Host:         sshConfig.hostName,
-		Port:         sshConfig.port,
-		User:         sshConfig.user,
-		Password:     sshConfig.password,
-		IdentifyFile: sshConfig.identifyFile,
-		RemotePath:   config.remotePath,
-		LocalPath:    tmpPath,
+// newSSHStorage creates the SSH storage from the storage settings
+func newSSHStorage(env storageEnv, localPath, remotePath string) (storage.Storage, error) {
+	sshConfig, err := loadSSHConfig(env)
+	if err != nil {
+		return nil, fmt.Errorf("error loading ssh config: %w", err)
+	}
+	return ssh.NewStorage(ssh.Config{
+		Host:                  sshConfig.hostName,
+		Port:                  sshConfig.port,
+		User:                  sshConfig.user,
+		Password:              sshConfig.password,
+		IdentifyFile:          sshConfig.identifyFile,
+		Passphrase:            sshConfig.passphrase,
+		AgentSocket:           sshConfig.agentSocket,
+		KnownHostsFiles:       sshConfig.knownHostsFiles,
+		HostKeyFingerprints:   sshConfig.hostKeyFingerprints,
+		StrictHostKeyChecking: sshConfig.strictHostKeyChecking,
+		JumpHosts:             sshConfig.jumpHosts,
+		RemotePath:            remotePath,
+		LocalPath:             localPath,
+		Warnf:                 utils.Warn,
 	})
-	if err != nil {
-		utils.Fatal("Error creating SSH storage: %s", err)
-	}
-	err = sshStorage.Copy(finalFileName)
-	if err != nil {
-		utils.Fatal("Error copying backup file: %s", err)
-	}
-	// Get backup info
-	fileInfo, err := os.Stat(filepath.Join(tmpPath, finalFileName))
-	if err != nil {
-		utils.Error("Error: %s", err)
-	}
-	backupSize = fileInfo.Size()
-	utils.Info("Backup name is %s", finalFileName)
-	utils.Info("Backup size: %s", utils.ConvertBytes(uint64(backupSize)))
-	utils.Info("Backup saved in %s", filepath.Join(config.remotePath, finalFileName))
-
-	// Delete backup file from tmp folder
-	err = utils.DeleteFile(filepath.Join(tmpPath, finalFileName))
-	if err != nil {
-		utils.Error("Error deleting file: %v", err)
-
-	}
-	if config.prune {
-		err := sshStorage.Prune(config.backupRetention)
-		if err != nil {
-			utils.Fatal("Error deleting old backup from %s storage: %s ", config.storage, err)
-		}
-
-	}
-	utils.Info("Uploading backup archive to remote storage ... done ")
-	duration := goutils.FormatDuration(time.Since(startTime), 0)
-
-	// Send notification
-	utils.NotifySuccess(&utils.NotificationData{
-		File:           finalFileName,
-		BackupSize:     utils.ConvertBytes(uint64(backupSize)),
-		Database:       db.dbName,
-		Storage:        config.storage,
-		BackupLocation: filepath.Join(config.remotePath, finalFileName),
-		Duration:       duration,
-	})
-	// Delete temp
-	deleteTemp()
-	utils.Info("The backup of the %s database has been completed in %s", db.dbName, duration)
-
 }
-func remoteRestore(db *dbConfig, conf *RestoreConfig) {
-	utils.Info("Restore database from remote server")
-	sshConfig, err := loadSSHConfig()
-	if err != nil {
-		utils.Fatal("Error loading ssh config: %s", err)
-	}
-
-	sshStorage, err := ssh.NewStorage(ssh.Config{
-		Host:         sshConfig.hostName,
-		Port:         sshConfig.port,
-		User:         sshConfig.user,
-		Password:     sshConfig.password,
-		IdentifyFile: sshConfig.identifyFile,
-		RemotePath:   conf.remotePath,
-		LocalPath:    tmpPath,
-	})
-	if err != nil {
-		utils.Fatal("Error creating SSH storage: %s", err)
-	}
-	err = sshStorage.CopyFrom(conf.file)
-	if err != nil {
-		utils.Fatal("Error copying backup file: %s", err)
-	}
-	RestoreDatabase(db, conf)
-}
-func ftpRestore(db *dbConfig, conf *RestoreConfig) {
-	utils.Info("Restore database from FTP server")
-	ftpConfig := loadFtpConfig()
-	ftpStorage, err := ftp.NewStorage(ftp.Config{
-		Host:       ftpConfig.host,
-		Port:       ftpConfig.port,
-		User:       ftpConfig.user,
-		Password:   ftpConfig.password,
-		RemotePath: conf.remotePath,
-		LocalPath:  tmpPath,
-	})
-	if err != nil {
-		utils.Fatal("Error creating SSH storage: %s", err)
-	}
-	err = ftpStorage.CopyFrom(conf.file)
-	if err != nil {
-		utils.Fatal("Error copying backup file: %s", err)
-	}
-	RestoreDatabase(db, conf)
-}
-func ftpBackup(db *dbConfig, config *BackupConfig) {
-	utils.Info("Backup database to the remote FTP server")
-
-	// Backup database
-	err := BackupDatabase(db, config.backupFileName, disableCompression, config.all, config.allInOne)
-	if err != nil {
-		recoverMode(err, "Error backing up database")
-		return
-	}
-	finalFileName := config.backupFileName
-	if config.encryption {
-		encryptBackup(config)
-		finalFileName = fmt.Sprintf("%s.%s", config.backupFileName, "gpg")
-	}
-	utils.Info("Uploading backup archive to the remote FTP server ... ")
-	utils.Info("Backup name is %s", finalFileName)
-	ftpConfig := loadFtpConfig()
-	ftpStorage, err := ftp.NewStorage(ftp.Config{
-		Host:       ftpConfig.host,
-		Port:       ftpConfig.port,
-		User:       ftpConfig.user,
-		Password:   ftpConfig.password,
-		RemotePath: config.remotePath,
-		LocalPath:  tmpPath,
-	})
-	if err != nil {
-		utils.Fatal("Error creating SSH storage: %s", err)
-	}
-	err = ftpStorage.Copy(finalFileName)
-	if err != nil {
-		utils.Fatal("Error copying backup file: %s", err)
-	}
-	utils.Info("Backup saved in %s", filepath.Join(config.remotePath, finalFileName))
-	// Get backup info
-	fileInfo, err := os.Stat(filepath.Join(tmpPath, finalFileName))
-	if err != nil {
-		utils.Error("Error: %s", err)
-	}
-	backupSize = fileInfo.Size()
-	// Delete backup file from tmp folder
-	err = utils.DeleteFile(filepath.Join(tmpPath, finalFileName))
-	if err != nil {
-		utils.Error("Error deleting file: %v", err)
-
-	}
-	if config.prune {
-		err := ftpStorage.Prune(config.backupRetention)
-		if err != nil {
-			utils.Fatal("Error deleting old backup from %s storage: %s ", config.storage, err)
-		}
-
-	}
-	utils.Info("Backup name is %s", finalFileName)
-	utils.Info("Backup size: %s", utils.ConvertBytes(uint64(backupSize)))
-	utils.Info("Uploading backup archive to the remote FTP server ... done ")
-	duration := goutils.FormatDuration(time.Since(startTime), 0)
 
-	// Send notification
-	utils.NotifySuccess(&utils.NotificationData{
-		File:           finalFileName,
-		BackupSize:     utils.ConvertBytes(uint64(backupSize)),
-		Database:       db.dbName,
-		Storage:        config.storage,
-		BackupLocation: filepath.Join(config.remotePath, finalFileName),
-		Duration:       duration,
+// newFTPStorage creates the FTP storage from the storage settings
+func newFTPStorage(env storageEnv, localPath, remotePath string) (storage.Storage, error) {
+	ftpConfig, err := loadFtpConfig(env)
+	if err != nil {
+		return nil, fmt.Errorf("error loading ftp config: %w", err)
+	}
+	return ftp.NewStorage(ftp.Config{
+		Host:               ftpConfig.host,
+		Port:               ftpConfig.port,
+		User:               ftpConfig.user,
+		Password:           ftpConfig.password,
+		TLS:                ftpConfig.tls,
+		CACert:             ftpConfig.caCert,
+		RemotePath:         remotePath,
+		LocalPath:          localPath,
+		InsecureSkipVerify: ftpConfig.insecureSkipVerify,
+		Mode:               ftpConfig.mode,
+		DisableEPSV:        ftpConfig.disableEPSV,
 	})
-	// Delete temp
-	deleteTemp()
-	utils.Info("The backup of the %s database has been completed in %s", db.dbName, duration)
 }
@@ -25,11 +25,16 @@ SOFTWARE.
 package pkg
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"github.com/jkaninda/encryptor"
-	"github.com/jkaninda/go-storage/pkg/local"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/pkg/storage/local"
 	"github.com/jkaninda/mysql-bkup/utils"
 	"github.com/spf13/cobra"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -40,39 +45,43 @@ func StartRestore(cmd *cobra.Command) {
 	dbConf = initDbConfig(cmd)
 	restoreConf := initRestoreConfig(cmd)
 
-	switch restoreConf.storage {
-	case "local":
-		localRestore(dbConf, restoreConf)
-	case "s3", "S3":
-		s3Restore(dbConf, restoreConf)
-	case "ssh", "SSH", "remote":
-		remoteRestore(dbConf, restoreConf)
-	case "ftp", "FTP":
-		ftpRestore(dbConf, restoreConf)
-	case "azure":
-		azureRestore(dbConf, restoreConf)
-	default:
-		localRestore(dbConf, restoreConf)
+	bkStorage, err := newRestoreStorage(restoreConf)
+	if err != nil {
+		utils.Fatal("Error creating %s storage: %s", restoreConf.storage, err)
+	}
+	utils.Info("Restore database from %s storage", bkStorage.Name())
+	err = downloadBackup(bkStorage, restoreConf.file)
+	if err != nil {
+		utils.Fatal("Error downloading backup file: %s", err)
 	}
+	if restoreConf.verificationKeys != nil || restoreConf.requireSignature {
+		if err = verifyBackup(bkStorage, restoreConf.file, restoreConf.verificationKeys, restoreConf.requireSignature); err != nil {
+			utils.Fatal("Error verifying backup: %s", err)
+		}
+	}
+	RestoreDatabase(dbConf, restoreConf)
 }
-func localRestore(dbConf *dbConfig, restoreConf *RestoreConfig) {
-	utils.Info("Restore database from local")
+
+// newRestoreStorage creates the storage to restore from, a local backup file can be
+// given with its path, it defaults to the storage path or to the path of the storage profile
+func newRestoreStorage(restoreConf *RestoreConfig) (storage.Storage, error) {
+	bkStorage, err := newStorage(restoreConf.storage, restoreConf.remotePath)
+	if err != nil || bkStorage.Name() != "local" {
+		return bkStorage, err
+	}
 	basePath := filepath.Dir(restoreConf.file)
-	fileName := filepath.Base(restoreConf.file)
-	restoreConf.file = fileName
+	restoreConf.file = filepath.Base(restoreConf.file)
 	if basePath == "" || basePath == "." {
+		// A local storage profile restores from its path
+		if _, ok := storageProfile(restoreConf.storage); ok {
+			return bkStorage, nil
+		}
 		basePath = storagePath
 	}
-	localStorage := local.NewStorage(local.Config{
+	return local.NewStorage(local.Config{
 		RemotePath: basePath,
 		LocalPath:  tmpPath,
-	})
-	err := localStorage.CopyFrom(fileName)
-	if err != nil {
-		utils.Fatal("Error copying backup file: %s", err)
-	}
-	RestoreDatabase(dbConf, restoreConf)
-
+	}), nil
 }
 
 // RestoreDatabase restores the database from a backup file
@@ -82,17 +91,14 @@ func RestoreDatabase(db *dbConfig, conf *RestoreConfig) {
 	}
 
 	filePath := filepath.Join(tmpPath, conf.file)
-	rFile, err := os.ReadFile(filePath)
-	if err != nil {
-		utils.Fatal("Error reading backup file: %v", err)
+	if !utils.FileExists(filePath) {
+		utils.Fatal("Error reading backup file: %s not found", filePath)
 	}
-
-	extension := filepath.Ext(filePath)
-	outputFile := RemoveLastExtension(filePath)
-
-	if extension == ".gpg" {
-		decryptBackup(conf, rFile, outputFile)
+	outputFile, err := decryptFile(conf, filePath)
+	if err != nil {
+		utils.Fatal("Error decrypting backup: %v", err)
 	}
+	conf.file = filepath.Base(outputFile)
 
 	restorationFile := filepath.Join(tmpPath, conf.file)
 	if !utils.FileExists(restorationFile) {
@@ -104,50 +110,101 @@ func RestoreDatabase(db *dbConfig, conf *RestoreConfig) {
 	}
 
 	utils.Info("Restoring database...")
+	if conf.atomic {
+		atomicRestore(db, conf, restorationFile)
+		return
+	}
 	restoreDatabaseFile(db, restorationFile)
 }
 
-func decryptBackup(conf *RestoreConfig, rFile []byte, outputFile string) {
-	if conf.usingKey {
-		utils.Info("Decrypting backup using private key...")
-		prKey, err := os.ReadFile(conf.privateKey)
-		if err != nil {
-			utils.Fatal("Error reading private key: %v", err)
-		}
-		if err := encryptor.DecryptWithPrivateKey(rFile, outputFile, prKey, conf.passphrase); err != nil {
-			utils.Fatal("Error decrypting backup: %v", err)
+// decryptFile decrypts an age, gpg or envelope encrypted file and returns the path of the decrypted file,
+// the file path is returned unchanged when the file is not encrypted
+func decryptFile(conf *RestoreConfig, filePath string) (string, error) {
+	outputFile := RemoveLastExtension(filePath)
+	switch filepath.Ext(filePath) {
+	case "." + ageExtension:
+		utils.Info("Decrypting backup using age...")
+		return outputFile, ageDecrypt(filePath, outputFile, conf.ageIdentities)
+	case "." + envelopeExtension:
+		return outputFile, envelopeDecrypt(filePath, outputFile)
+	case "." + gpgExtension:
+		if conf.usingKey {
+			utils.Info("Decrypting backup using private key...")
+			keyRing, err := loadGPGKeyRing(conf.privateKeys, conf.passphrase)
+			if err != nil {
+				return "", fmt.Errorf("error reading private keys: %w", err)
+			}
+			return outputFile, gpgDecrypt(filePath, outputFile, keyRing)
 		}
-	} else {
 		if conf.passphrase == "" {
-			utils.Fatal("Passphrase or private key required for GPG file.")
+			return "", errors.New("passphrase or private key required for GPG file")
 		}
 		utils.Info("Decrypting backup using passphrase...")
-		if err := encryptor.Decrypt(rFile, outputFile, conf.passphrase); err != nil {
-			utils.Fatal("Error decrypting file: %v", err)
+		rFile, err := os.ReadFile(filePath)
+		if err != nil {
+			return "", err
 		}
-		conf.file = RemoveLastExtension(conf.file)
+		return outputFile, encryptor.Decrypt(rFile, outputFile, conf.passphrase)
 	}
+	return filePath, nil
 }
 
 func restoreDatabaseFile(db *dbConfig, restorationFile string) {
-	extension := filepath.Ext(restorationFile)
-	var cmdStr string
-
-	switch extension {
-	case ".gz":
-		cmdStr = fmt.Sprintf("zcat %s | mariadb --defaults-file=%s %s", restorationFile, mysqlClientConfig, db.dbName)
-	case ".sql":
-		cmdStr = fmt.Sprintf("cat %s | mariadb --defaults-file=%s %s", restorationFile, mysqlClientConfig, db.dbName)
-	default:
-		utils.Fatal("Unknown file extension: %s", extension)
+	if err := importDatabaseFile(db, restorationFile); err != nil {
+		utils.Fatal("Error restoring database: %v", err)
 	}
+	utils.Info("Database has been restored successfully.")
+	deleteTemp()
+}
 
-	cmd := exec.Command("sh", "-c", cmdStr)
-	output, err := cmd.CombinedOutput()
+// importDatabaseFile imports a .sql or .sql.gz file into the database
+func importDatabaseFile(db *dbConfig, restorationFile string, clientArgs ...string) error {
+	extension := filepath.Ext(restorationFile)
+	if extension != ".gz" && extension != ".sql" {
+		return fmt.Errorf("unknown file extension: %s", extension)
+	}
+	f, err := os.Open(restorationFile)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			return
+		}
+	}(f)
+	fileInfo, err := f.Stat()
 	if err != nil {
-		utils.Fatal("Error restoring database: %v\nOutput: %s", err, string(output))
+		return err
+	}
+	// Progress is based on the bytes read from the backup file, compressed or not
+	p := newProgress("Restoring", fileInfo.Size(), false)
+	var reader io.Reader = io.TeeReader(f, p.byteCounter())
+	if extension == ".gz" {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip file: %w", err)
+		}
+		defer func(gzipReader *gzip.Reader) {
+			err := gzipReader.Close()
+			if err != nil {
+				return
+			}
+		}(gzipReader)
+		reader = gzipReader
 	}
 
-	utils.Info("Database has been restored successfully.")
-	deleteTemp()
+	args := append([]string{fmt.Sprintf("--defaults-file=%s", mysqlClientConfig)}, clientArgs...)
+	cmd := exec.Command("mariadb", append(args, db.dbName)...)
+	cmd.Stdin = io.TeeReader(reader, p.sqlScanner())
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	p.start()
+	err = cmd.Run()
+	p.finish()
+	if err != nil {
+		return fmt.Errorf("%v\nOutput: %s", err, output.String())
+	}
+	return nil
 }
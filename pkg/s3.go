@@ -26,113 +26,42 @@ package pkg
 
 import (
 	"fmt"
-	"github.com/jkaninda/go-storage/pkg/s3"
-	goutils "github.com/jkaninda/go-utils"
-	"github.com/jkaninda/mysql-bkup/utils"
 
-	"os"
-	"path/filepath"
-	"time"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/pkg/storage/s3"
 )
 
-func s3Backup(db *dbConfig, config *BackupConfig) {
-
-	utils.Info("Backup database to s3 storage")
-	// Backup database
-	err := BackupDatabase(db, config.backupFileName, disableCompression, config.all, config.allInOne)
-	if err != nil {
-		recoverMode(err, "Error backing up database")
-		return
-	}
-	finalFileName := config.backupFileName
-	if config.encryption {
-		encryptBackup(config)
-		finalFileName = fmt.Sprintf("%s.%s", config.backupFileName, "gpg")
-	}
-	utils.Info("Uploading backup archive to remote storage S3 ... ")
-	awsConfig := initAWSConfig()
-	if config.remotePath == "" {
-		config.remotePath = awsConfig.remotePath
-	}
-	utils.Info("Backup name is %s", finalFileName)
-	s3Storage, err := s3.NewStorage(s3.Config{
-		Endpoint:       awsConfig.endpoint,
-		Bucket:         awsConfig.bucket,
-		AccessKey:      awsConfig.accessKey,
-		SecretKey:      awsConfig.secretKey,
-		Region:         awsConfig.region,
-		DisableSsl:     awsConfig.disableSsl,
-		ForcePathStyle: awsConfig.forcePathStyle,
-		RemotePath:     config.remotePath,
-		LocalPath:      tmpPath,
-	})
-	if err != nil {
-		utils.Fatal("Error creating s3 storage: %s", err)
-	}
-	err = s3Storage.Copy(finalFileName)
-	if err != nil {
-		utils.Fatal("Error copying backup file: %s", err)
-	}
-	// Get backup info
-	fileInfo, err := os.Stat(filepath.Join(tmpPath, finalFileName))
-	if err != nil {
-		utils.Error("Error: %s", err)
-	}
-	backupSize = fileInfo.Size()
-
-	// Delete backup file from tmp folder
-	err = utils.DeleteFile(filepath.Join(tmpPath, config.backupFileName))
+// newS3Storage creates the S3 storage from environment variables
+func newS3Storage(env storageEnv, localPath, remotePath string) (storage.Storage, error) {
+	awsConfig, err := initAWSConfig(env)
 	if err != nil {
-		fmt.Println("Error deleting file: ", err)
-
+		return nil, fmt.Errorf("error loading s3 config: %w", err)
 	}
-	// Delete old backup
-	if config.prune {
-		err := s3Storage.Prune(config.backupRetention)
-		if err != nil {
-			utils.Fatal("Error deleting old backup from %s storage: %s ", config.storage, err)
-		}
+	if remotePath == "" {
+		remotePath = awsConfig.remotePath
 	}
-	utils.Info("Backup saved in %s", filepath.Join(config.remotePath, finalFileName))
-	utils.Info("Uploading backup archive to remote storage S3 ... done ")
-	duration := goutils.FormatDuration(time.Since(startTime), 0)
-	// Send notification
-	utils.NotifySuccess(&utils.NotificationData{
-		File:           finalFileName,
-		BackupSize:     utils.ConvertBytes(uint64(backupSize)),
-		Database:       db.dbName,
-		Storage:        config.storage,
-		BackupLocation: filepath.Join(config.remotePath, finalFileName),
-		Duration:       duration,
+	return s3.NewStorage(s3.Config{
+		Endpoint:        awsConfig.endpoint,
+		Bucket:          awsConfig.bucket,
+		AccessKey:       awsConfig.accessKey,
+		SecretKey:       awsConfig.secretKey,
+		Region:          awsConfig.region,
+		DisableSsl:      awsConfig.disableSsl,
+		ForcePathStyle:  awsConfig.forcePathStyle,
+		RemotePath:      remotePath,
+		LocalPath:       localPath,
+		RoleARN:         awsConfig.roleARN,
+		ExternalID:      awsConfig.externalID,
+		RoleSessionName: awsConfig.roleSessionName,
+		Options: s3.Options{
+			ServerSideEncryption: awsConfig.sse,
+			SSEKMSKeyID:          awsConfig.sseKmsKeyID,
+			SSECustomerKey:       awsConfig.sseCustomerKey,
+			StorageClass:         awsConfig.storageClass,
+			ObjectLockMode:       awsConfig.objectLockMode,
+			ObjectLockRetention:  awsConfig.objectLockRetention,
+			LegalHold:            awsConfig.legalHold,
+			Tags:                 awsConfig.tags,
+		},
 	})
-	// Delete temp
-	deleteTemp()
-	utils.Info("The backup of the %s database has been completed in %s", db.dbName, duration)
-
-}
-func s3Restore(db *dbConfig, conf *RestoreConfig) {
-	utils.Info("Restore database from s3")
-	awsConfig := initAWSConfig()
-	if conf.remotePath == "" {
-		conf.remotePath = awsConfig.remotePath
-	}
-	s3Storage, err := s3.NewStorage(s3.Config{
-		Endpoint:       awsConfig.endpoint,
-		Bucket:         awsConfig.bucket,
-		AccessKey:      awsConfig.accessKey,
-		SecretKey:      awsConfig.secretKey,
-		Region:         awsConfig.region,
-		DisableSsl:     awsConfig.disableSsl,
-		ForcePathStyle: awsConfig.forcePathStyle,
-		RemotePath:     conf.remotePath,
-		LocalPath:      tmpPath,
-	})
-	if err != nil {
-		utils.Fatal("Error creating s3 storage: %s", err)
-	}
-	err = s3Storage.CopyFrom(conf.file)
-	if err != nil {
-		utils.Fatal("Error download file from S3 storage: %s", err)
-	}
-	RestoreDatabase(db, conf)
 }
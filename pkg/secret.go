@@ -0,0 +1,170 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/secret"
+	vaultkv "github.com/jkaninda/mysql-bkup/pkg/secret/vault"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// secretVars are the environment variables holding secrets, each one can be read from the file of
+// the variable with the _FILE suffix, e.g. DB_PASSWORD_FILE, or reference a secret provider
+var secretVars = []string{
+	"DB_PASSWORD", "TARGET_DB_PASSWORD",
+	"AWS_ACCESS_KEY", "AWS_SECRET_KEY", "ACCESS_KEY", "SECRET_KEY",
+	"SSH_PASSWORD", "SSH_IDENTIFY_FILE_PASSPHRASE", "FTP_PASSWORD",
+	"AZURE_STORAGE_ACCOUNT_KEY", "AZURE_STORAGE_CONNECTION_STRING", "AZURE_STORAGE_SAS_TOKEN",
+	"WEBDAV_PASSWORD", "WEBDAV_TOKEN",
+	"GPG_PASSPHRASE", "AGE_PASSPHRASE", "AGE_IDENTITY_PASSPHRASE", "SIGNING_KEY_PASSPHRASE",
+	"VAULT_TOKEN", "MAIL_PASSWORD", "TG_TOKEN",
+}
+
+// secretProviders create the secret providers of the secret references, e.g. vault:secret/db#password
+var secretProviders = map[string]func() (secret.Provider, error){
+	secret.EnvName: func() (secret.Provider, error) {
+		return secret.NewEnvProvider(), nil
+	},
+	secret.FileName: func() (secret.Provider, error) {
+		return secret.NewFileProvider(), nil
+	},
+	vaultkv.Name: func() (secret.Provider, error) {
+		conf := vaultkv.Config{
+			Address:   os.Getenv("VAULT_ADDR"),
+			Token:     os.Getenv("VAULT_TOKEN"),
+			Namespace: os.Getenv("VAULT_NAMESPACE"),
+			CACert:    os.Getenv("VAULT_CACERT"),
+		}
+		if version := os.Getenv("VAULT_KV_VERSION"); version != "" {
+			var err error
+			if conf.Version, err = strconv.Atoi(version); err != nil {
+				return nil, fmt.Errorf("invalid VAULT_KV_VERSION %q", version)
+			}
+		}
+		return vaultkv.NewProvider(conf)
+	},
+}
+
+var (
+	secretMu sync.Mutex
+	// secretProviderCache holds the secret providers already created
+	secretProviderCache = map[string]secret.Provider{}
+	// resolvedSecrets are the secret environment variables already loaded, they aren't resolved twice
+	resolvedSecrets = map[string]bool{}
+)
+
+// LoadSecrets loads the secret environment variables from their _FILE variables and secret providers
+func LoadSecrets() {
+	if err := loadSecrets(); err != nil {
+		utils.Fatal("Error loading secrets: %v", err)
+	}
+}
+
+// loadSecrets reads the files of the _FILE variables of the secret environment variables, then resolves
+// the secret references of the secret environment variables
+func loadSecrets() error {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	names := make([]string, 0)
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// The secret files are read before the references are resolved, VAULT_TOKEN_FILE may hold the
+	// token of the Vault secret provider
+	for _, name := range names {
+		target, ok := strings.CutSuffix(name, "_FILE")
+		if !ok || !isSecretVar(target) || resolvedSecrets[target] {
+			continue
+		}
+		if _, ok := os.LookupEnv(target); ok {
+			return fmt.Errorf("both %s and %s are set", target, name)
+		}
+		value, err := secret.ReadFile(os.Getenv(name))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		utils.SetEnv(target, value)
+		resolvedSecrets[target] = true
+	}
+	for _, name := range names {
+		if !isSecretVar(name) || resolvedSecrets[name] {
+			continue
+		}
+		value, err := resolveSecretLocked(os.Getenv(name))
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", name, err)
+		}
+		utils.SetEnv(name, value)
+		resolvedSecrets[name] = true
+	}
+	return nil
+}
+
+// isSecretVar checks if an environment variable holds a secret, the per-database passwords
+// e.g. DB_PASSWORD_ORDERS and ORDERS_DB_PASSWORD are secrets
+func isSecretVar(name string) bool {
+	for _, secretVar := range secretVars {
+		if name == secretVar {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, "DB_PASSWORD_") || strings.HasSuffix(name, "_DB_PASSWORD")
+}
+
+// resolveSecret returns the secret of a secret reference, e.g. vault:secret/db#password, the values
+// which aren't a reference of a secret provider are returned as is
+func resolveSecret(value string) (string, error) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	return resolveSecretLocked(value)
+}
+
+func resolveSecretLocked(value string) (string, error) {
+	name, path, ok := secret.ParseReference(value)
+	if !ok {
+		return value, nil
+	}
+	newProvider, ok := secretProviders[name]
+	if !ok {
+		return value, nil
+	}
+	provider, ok := secretProviderCache[name]
+	if !ok {
+		var err error
+		if provider, err = newProvider(); err != nil {
+			return "", fmt.Errorf("error creating %s secret provider: %w", name, err)
+		}
+		secretProviderCache[name] = provider
+	}
+	return provider.Secret(path)
+}
@@ -0,0 +1,113 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package secret
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// EnvName is the name of the environment variable secret provider
+	EnvName = "env"
+	// FileName is the name of the file secret provider
+	FileName = "file"
+)
+
+// Provider reads the secrets of a secret store
+type Provider interface {
+	// Name returns the provider name
+	Name() string
+	// Secret returns the secret of a reference path, e.g. secret/db#password
+	Secret(path string) (string, error)
+}
+
+// ParseReference splits a secret reference, e.g. vault:secret/db#password, into the provider name and the path
+func ParseReference(value string) (name, path string, ok bool) {
+	name, path, ok = strings.Cut(value, ":")
+	if !ok || name == "" || path == "" {
+		return "", "", false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && r != '-' {
+			return "", "", false
+		}
+	}
+	return name, path, true
+}
+
+// SplitKey splits a reference path into the path of the secret and the key of the secret, e.g. secret/db#password
+func SplitKey(path string) (secretPath, key string) {
+	secretPath, key, _ = strings.Cut(path, "#")
+	return secretPath, key
+}
+
+// ReadFile reads a secret file, the trailing newline is removed
+func ReadFile(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+type envProvider struct{}
+
+// NewEnvProvider creates a secret provider reading the secrets of environment variables, e.g. env:MYSQL_ROOT_PASSWORD
+func NewEnvProvider() Provider {
+	return envProvider{}
+}
+
+func (envProvider) Name() string {
+	return EnvName
+}
+
+func (envProvider) Secret(path string) (string, error) {
+	value, ok := os.LookupEnv(path)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s not set", path)
+	}
+	return value, nil
+}
+
+type fileProvider struct{}
+
+// NewFileProvider creates a secret provider reading the secrets of files, e.g. file:/run/secrets/db-password
+func NewFileProvider() Provider {
+	return fileProvider{}
+}
+
+func (fileProvider) Name() string {
+	return FileName
+}
+
+func (fileProvider) Secret(path string) (string, error) {
+	value, err := ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,190 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package vault
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/secret"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name is the name of the Vault KV secret provider
+const Name = "vault"
+
+type vaultProvider struct {
+	client  *http.Client
+	address string
+	conf    Config
+	mu      sync.Mutex
+	// secrets caches the secrets read, a secret holding several keys is read once
+	secrets map[string]map[string]any
+}
+
+// Config holds the Vault KV secret provider config
+type Config struct {
+	Address   string
+	Token     string
+	Namespace string
+	// Version is the version of the KV secrets engine, 1 or 2, 2 by default
+	Version int
+	// CACert is the CA certificate file verifying the Vault server certificate
+	CACert string
+}
+
+// NewProvider creates a secret provider reading the secrets of a Vault KV secrets engine,
+// the reference path starts with the mount of the engine, e.g. secret/db#password
+func NewProvider(conf Config) (secret.Provider, error) {
+	if conf.Address == "" || conf.Token == "" {
+		return nil, errors.New("the Vault address and token are required")
+	}
+	switch conf.Version {
+	case 0:
+		conf.Version = 2
+	case 1, 2:
+	default:
+		return nil, fmt.Errorf("invalid Vault KV version %d, expected 1 or 2", conf.Version)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.CACert != "" {
+		pem, err := os.ReadFile(conf.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the Vault CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid Vault CA certificate %s", conf.CACert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &vaultProvider{
+		client:  &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		address: strings.TrimSuffix(conf.Address, "/"),
+		conf:    conf,
+		secrets: map[string]map[string]any{},
+	}, nil
+}
+
+func (p *vaultProvider) Name() string {
+	return Name
+}
+
+// Secret returns a key of a secret, the key can be omitted when the secret holds a single key
+func (p *vaultProvider) Secret(path string) (string, error) {
+	secretPath, key := secret.SplitKey(path)
+	data, err := p.read(secretPath)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("the Vault secret %s holds %d keys, the key is required, e.g. %s#password", secretPath, len(data), secretPath)
+		}
+		for k := range data {
+			key = k
+		}
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in the Vault secret %s", key, secretPath)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// read reads the keys of a secret
+func (p *vaultProvider) read(secretPath string) (map[string]any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if data, ok := p.secrets[secretPath]; ok {
+		return data, nil
+	}
+	mount, name, _ := strings.Cut(strings.Trim(secretPath, "/"), "/")
+	if mount == "" || name == "" {
+		return nil, fmt.Errorf("invalid Vault secret path %q, expected <mount>/<path>", secretPath)
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s", p.address, mount, name)
+	if p.conf.Version == 2 {
+		url = fmt.Sprintf("%s/v1/%s/data/%s", p.address, mount, name)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.conf.Token)
+	if p.conf.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.conf.Namespace)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid Vault response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("vault secret %s not found", secretPath)
+	case resp.StatusCode != http.StatusOK && len(result.Errors) > 0:
+		return nil, fmt.Errorf("failed to read the Vault secret %s: %s", secretPath, strings.Join(result.Errors, ", "))
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed to read the Vault secret %s: %s", secretPath, resp.Status)
+	}
+	var data map[string]any
+	if p.conf.Version == 2 {
+		var kv struct {
+			Data map[string]any `json:"data"`
+		}
+		err = json.Unmarshal(result.Data, &kv)
+		data = kv.Data
+	} else {
+		err = json.Unmarshal(result.Data, &data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid Vault secret %s: %w", secretPath, err)
+	}
+	p.secrets[secretPath] = data
+	return data, nil
+}
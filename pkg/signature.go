@@ -0,0 +1,269 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"aead.dev/minisign"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// signatureExtension is appended to the manifest name to name its detached signature
+const signatureExtension = ".sig"
+
+// minisignComment starts the minisign keys and signatures
+const minisignComment = "untrusted comment:"
+
+// signingKey signs the backup manifests with a minisign (ed25519) or a gpg private key
+type signingKey struct {
+	minisign *minisign.PrivateKey
+	gpg      *crypto.KeyRing
+}
+
+// verificationKeys verify the signatures of the backup manifests
+type verificationKeys struct {
+	minisign []minisign.PublicKey
+	gpg      *crypto.KeyRing
+}
+
+// signatureName returns the signature file name of a backup file, it signs the backup manifest
+func signatureName(fileName string) string {
+	return manifestName(fileName) + signatureExtension
+}
+
+// isSignature returns true if the file is a backup manifest signature
+func isSignature(fileName string) bool {
+	return strings.HasSuffix(fileName, manifestExtension+signatureExtension)
+}
+
+// loadSigningKey loads the private key of SIGNING_KEY, a minisign or gpg private key unlocked
+// with SIGNING_KEY_PASSPHRASE. Nil is returned when it's not set.
+func loadSigningKey() (*signingKey, error) {
+	keyFile := os.Getenv("SIGNING_KEY")
+	if keyFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	passphrase := os.Getenv("SIGNING_KEY_PASSPHRASE")
+	if bytes.HasPrefix(data, []byte(minisignComment)) {
+		var key minisign.PrivateKey
+		if minisign.IsEncrypted(data) {
+			if passphrase == "" {
+				return nil, errors.New("the minisign signing key is encrypted, set SIGNING_KEY_PASSPHRASE")
+			}
+			key, err = minisign.DecryptKey(passphrase, data)
+		} else {
+			err = key.UnmarshalText(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key %s: %w", keyFile, err)
+		}
+		return &signingKey{minisign: &key}, nil
+	}
+	keyRing, err := loadGPGKeyRing([]string{keyFile}, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", keyFile, err)
+	}
+	if !keyRing.GetKeys()[0].IsPrivate() {
+		return nil, fmt.Errorf("invalid signing key %s: not a private key", keyFile)
+	}
+	return &signingKey{gpg: keyRing}, nil
+}
+
+// loadVerificationKeys loads the minisign and gpg public keys of the files and directories
+// of SIGNATURE_PUBLIC_KEY. Nil is returned when it's not set.
+func loadVerificationKeys() (*verificationKeys, error) {
+	value := os.Getenv("SIGNATURE_PUBLIC_KEY")
+	if value == "" {
+		return nil, nil
+	}
+	files, err := keyFiles(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature public key: %w", err)
+	}
+	keys := &verificationKeys{}
+	var gpgFiles []string
+	for _, keyFile := range files {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(string(data), "-----BEGIN PGP") {
+			gpgFiles = append(gpgFiles, keyFile)
+			continue
+		}
+		key, err := parseMinisignPublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature public key %s: %w", keyFile, err)
+		}
+		keys.minisign = append(keys.minisign, key)
+	}
+	if len(gpgFiles) > 0 {
+		if keys.gpg, err = loadGPGKeyRing(gpgFiles, ""); err != nil {
+			return nil, fmt.Errorf("invalid signature public key: %w", err)
+		}
+	}
+	return keys, nil
+}
+
+// parseMinisignPublicKey parses a minisign public key file, or a public key
+func parseMinisignPublicKey(data []byte) (minisign.PublicKey, error) {
+	var key minisign.PublicKey
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, minisignComment) {
+			continue
+		}
+		return key, key.UnmarshalText([]byte(line))
+	}
+	return key, errors.New("no public key found")
+}
+
+// sign returns the detached signature of a message, armored for gpg
+func (k *signingKey) sign(message []byte) ([]byte, error) {
+	if k.minisign != nil {
+		// Prehashed signature, the default of minisign
+		r := minisign.NewReader(bytes.NewReader(message))
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, err
+		}
+		return r.Sign(*k.minisign), nil
+	}
+	signature, err := k.gpg.SignDetached(crypto.NewPlainMessage(message))
+	if err != nil {
+		return nil, err
+	}
+	armored, err := signature.GetArmored()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(armored), nil
+}
+
+// verify verifies the detached signature of a message with the keys of its kind
+func (k *verificationKeys) verify(message, signature []byte) error {
+	if bytes.HasPrefix(signature, []byte(minisignComment)) {
+		for _, key := range k.minisign {
+			if minisign.Verify(key, message, signature) {
+				return nil
+			}
+		}
+		return errors.New("invalid minisign signature, or signed with an unknown key")
+	}
+	if k.gpg == nil {
+		return errors.New("gpg signature but no gpg public key in SIGNATURE_PUBLIC_KEY")
+	}
+	pgpSignature, err := crypto.NewPGPSignatureFromArmored(string(signature))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if err = k.gpg.VerifyDetached(crypto.NewPlainMessage(message), pgpSignature, crypto.GetUnixTime()); err != nil {
+		return fmt.Errorf("invalid gpg signature, or signed with an unknown key: %w", err)
+	}
+	return nil
+}
+
+// signManifest signs the manifest of a backup file located in the directory dir,
+// and returns the name of the signature file
+func signManifest(dir string, key *signingKey, fileName string) (string, error) {
+	manifest, err := os.ReadFile(filepath.Join(dir, manifestName(fileName)))
+	if err != nil {
+		return "", err
+	}
+	signature, err := key.sign(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the manifest: %w", err)
+	}
+	return signatureName(fileName), os.WriteFile(filepath.Join(dir, signatureName(fileName)), signature, 0644)
+}
+
+// verifyBackup verifies a downloaded backup with its manifest: the signature of the manifest when
+// verification keys are set or a signature is required, and the backup checksum
+func verifyBackup(st storage.Storage, fileName string, keys *verificationKeys, requireSignature bool) error {
+	if err := st.CopyFrom(manifestName(fileName)); err != nil {
+		if requireSignature {
+			return fmt.Errorf("the backup %s is not signed: manifest not found", fileName)
+		}
+		utils.Warn("The manifest of %s is not found, the backup can't be verified", fileName)
+		return nil
+	}
+	if keys != nil || requireSignature {
+		if err := verifyManifestSignature(st, fileName, keys, requireSignature); err != nil {
+			return err
+		}
+	}
+	manifest, err := readManifest(filepath.Join(tmpPath, manifestName(fileName)))
+	if err != nil {
+		return err
+	}
+	// The manifest must describe this backup, a signed backup can't be renamed
+	if manifest.File != fileName {
+		return fmt.Errorf("the manifest describes %s, not %s", manifest.File, fileName)
+	}
+	if _, err = verifyChecksum(manifestPart{File: fileName, Checksum: manifest.Checksum}, st); err != nil {
+		return err
+	}
+	utils.Info("Checksum of %s verified: %s", fileName, manifest.Checksum)
+	return nil
+}
+
+// verifyManifestSignature verifies the signature of a downloaded manifest, an unsigned backup
+// is an error when a signature is required, otherwise a warning
+func verifyManifestSignature(st storage.Storage, fileName string, keys *verificationKeys, requireSignature bool) error {
+	if keys == nil {
+		return errors.New("SIGNATURE_PUBLIC_KEY required to verify the signature")
+	}
+	if err := st.CopyFrom(signatureName(fileName)); err != nil {
+		if requireSignature {
+			return fmt.Errorf("the backup %s is not signed: signature not found", fileName)
+		}
+		utils.Warn("The backup %s is not signed", fileName)
+		return nil
+	}
+	manifest, err := os.ReadFile(filepath.Join(tmpPath, manifestName(fileName)))
+	if err != nil {
+		return err
+	}
+	signature, err := os.ReadFile(filepath.Join(tmpPath, signatureName(fileName)))
+	if err != nil {
+		return err
+	}
+	if err = keys.verify(manifest, signature); err != nil {
+		return fmt.Errorf("signature verification of %s failed: %w", fileName, err)
+	}
+	utils.Info("Signature of %s verified", fileName)
+	return nil
+}
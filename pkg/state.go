@@ -0,0 +1,160 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"github.com/robfig/cron/v3"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// scheduleState is the state of the scheduled jobs, it's persisted in BACKUP_STATE_FILE so the
+// runs missed while the container was stopped are caught up on startup
+type scheduleState struct {
+	path string
+	// window is the age limit of the missed runs caught up, 0 disables the catch-up and a
+	// negative window catches up the missed runs of any age
+	window time.Duration
+	mu     sync.Mutex
+	Jobs   map[string]*jobState `json:"jobs"`
+}
+
+// jobState is the state of a scheduled job
+type jobState struct {
+	// Since is the time the job was first scheduled
+	Since       time.Time  `json:"since"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+}
+
+// loadScheduleState loads the state of the scheduled jobs, it returns nil when BACKUP_STATE_FILE
+// is not set, a state file which can't be read is replaced
+func loadScheduleState() *scheduleState {
+	path := os.Getenv("BACKUP_STATE_FILE")
+	if path == "" {
+		return nil
+	}
+	state := &scheduleState{path: path, window: -1, Jobs: map[string]*jobState{}}
+	if value := os.Getenv("BACKUP_CATCHUP_WINDOW"); value != "" {
+		window, err := utils.ParseDuration(value)
+		if err != nil || window < 0 {
+			utils.Fatal("Invalid BACKUP_CATCHUP_WINDOW %q, expected a duration, e.g. 6h or 1d", value)
+		}
+		state.window = window
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			utils.Warn("Error reading the state file %s: %v", path, err)
+		}
+		return state
+	}
+	if err = json.Unmarshal(data, state); err != nil {
+		utils.Warn("Error reading the state file %s, it's replaced: %v", path, err)
+	}
+	if state.Jobs == nil {
+		state.Jobs = map[string]*jobState{}
+	}
+	return state
+}
+
+// catchUp checks if the job missed a scheduled run while the container was stopped, the
+// jobs not in the state are recorded as scheduled from now on
+func (s *scheduleState) catchUp(name, cronExpression string) (time.Time, bool) {
+	if s == nil {
+		return time.Time{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	job, ok := s.Jobs[name]
+	if !ok {
+		s.Jobs[name] = &jobState{Since: now}
+		s.save()
+		return time.Time{}, false
+	}
+	if s.window == 0 {
+		return time.Time{}, false
+	}
+	schedule, err := cron.ParseStandard(cronExpression)
+	if err != nil {
+		return time.Time{}, false
+	}
+	from := job.Since
+	if job.LastSuccess != nil && job.LastSuccess.After(from) {
+		from = *job.LastSuccess
+	}
+	if s.window > 0 && from.Before(now.Add(-s.window)) {
+		from = now.Add(-s.window)
+	}
+	missed := schedule.Next(from)
+	return missed, !missed.After(now)
+}
+
+// succeeded records the successful run of a job
+func (s *scheduleState) succeeded(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	job, ok := s.Jobs[name]
+	if !ok {
+		job = &jobState{Since: now}
+		s.Jobs[name] = job
+	}
+	job.LastSuccess = &now
+	s.save()
+}
+
+// save writes the state file, the file is replaced atomically so a stop during the write
+// doesn't corrupt it
+func (s *scheduleState) save() {
+	if err := s.write(); err != nil {
+		utils.Warn("Error writing the state file %s: %v", s.path, err)
+	}
+}
+
+// write writes the state into a temporary file renamed to the state file
+func (s *scheduleState) write() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", s.path, os.Getpid())
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
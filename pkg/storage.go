@@ -0,0 +1,220 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/pkg/storage/local"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// newStorage creates the storage backend of the given storage type, failed operations are retried
+func newStorage(storageType, remotePath string) (storage.Storage, error) {
+	return newStorageIn(tmpPath, storageType, remotePath)
+}
+
+// newStorageIn creates the storage backend of the given storage type, the files are uploaded from
+// and downloaded to localPath
+func newStorageIn(localPath, storageType, remotePath string) (storage.Storage, error) {
+	st, err := newBackend(localPath, storageType, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return storage.WithRetry(st, loadRetryPolicy()), nil
+}
+
+// newBackend creates the storage backend of the given storage type or storage profile
+func newBackend(localPath, storageType, remotePath string) (storage.Storage, error) {
+	if profile, ok := storageProfile(storageType); ok {
+		return newProfileBackend(localPath, profile, remotePath)
+	}
+	return newTypeBackend(storageEnv{}, localPath, storageType, remotePath)
+}
+
+// newProfileBackend creates the storage backend of a storage profile of the config file,
+// the environment variables already set override the settings of the profile
+func newProfileBackend(localPath string, profile StorageProfile, remotePath string) (storage.Storage, error) {
+	if remotePath == "" {
+		remotePath = profile.Path
+	}
+	if strings.EqualFold(profile.Type, "local") && remotePath != "" {
+		return local.NewStorage(local.Config{
+			LocalPath:  localPath,
+			RemotePath: remotePath,
+		}), nil
+	}
+	env := storageEnv{}
+	for key, value := range profile.env() {
+		if value == "" {
+			continue
+		}
+		value, err := resolveSecret(value)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving %s of the storage profile: %w", key, err)
+		}
+		env[key] = value
+	}
+	return newTypeBackend(env, localPath, profile.Type, remotePath)
+}
+
+// newTypeBackend creates the storage backend of the given storage type from its settings
+func newTypeBackend(env storageEnv, localPath, storageType, remotePath string) (storage.Storage, error) {
+	switch strings.ToLower(storageType) {
+	case "s3":
+		return newS3Storage(env.withS3Options(), localPath, remotePath)
+	case "ssh", "remote", "sftp":
+		return newSSHStorage(env, localPath, remotePath)
+	case "ftp":
+		return newFTPStorage(env, localPath, remotePath)
+	case "azure":
+		return newAzureStorage(env, localPath, remotePath)
+	case "gcs":
+		return newGCSStorage(env, localPath, remotePath)
+	case "webdav":
+		return newWebDAVStorage(env, localPath, remotePath)
+	case "rclone":
+		return newRcloneStorage(env, localPath, remotePath)
+	default:
+		return local.NewStorage(local.Config{
+			LocalPath:  localPath,
+			RemotePath: storagePath,
+		}), nil
+	}
+}
+
+// storageEnv holds the settings of a storage profile by environment variable name, the environment
+// variables which are set override them. It's empty for a storage type configured by the environment
+type storageEnv map[string]string
+
+// withS3Options returns the settings with the S3 options of the config file, the settings of the
+// storage profile override them
+func (e storageEnv) withS3Options() storageEnv {
+	conf, err := loadConfig()
+	if err != nil || conf == nil {
+		return e
+	}
+	env := storageEnv{}
+	for key, value := range conf.S3.env() {
+		if value != "" {
+			env[key] = value
+		}
+	}
+	for key, value := range e {
+		env[key] = value
+	}
+	return env
+}
+
+// get returns the value of an environment variable, or the setting of the profile when it's not set
+func (e storageEnv) get(key string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return e[key]
+}
+
+// variable returns the value of a variable, or of its deprecated name with a warning
+func (e storageEnv) variable(key, oldKey string) string {
+	value := e.get(key)
+	if value == "" {
+		if value = e.get(oldKey); value != "" {
+			utils.Warn("%s is deprecated, please use %s instead! ", oldKey, key)
+		}
+	}
+	return value
+}
+
+// withDefault returns the value of a variable or defaultValue when it's empty
+func (e storageEnv) withDefault(key, defaultValue string) string {
+	if value := e.get(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// intValue returns the integer value of a variable, zero when it's empty
+func (e storageEnv) intValue(key string) (int, error) {
+	value := e.get(key)
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return number, nil
+}
+
+// check returns an error listing the required variables which are not set
+func (e storageEnv) check(keys []string) error {
+	var missing []string
+	for _, key := range keys {
+		if e.get(key) == "" && (deprecatedVars[key] == "" || e.get(deprecatedVars[key]) == "") {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing environment variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// openStorage creates a storage from a path given on the command line, the path defaults to REMOTE_PATH
+// and a local storage uses it instead of the storage path
+func openStorage(storageType, path string) (storage.Storage, error) {
+	if path == "" {
+		return newStorage(storageType, utils.GetEnvVariable("REMOTE_PATH", "SSH_REMOTE_PATH"))
+	}
+	st, err := newStorage(storageType, path)
+	if err != nil || st.Name() != "local" {
+		return st, err
+	}
+	return storage.WithRetry(local.NewStorage(local.Config{
+		LocalPath:  tmpPath,
+		RemotePath: path,
+	}), loadRetryPolicy()), nil
+}
+
+// storageLocation returns the location of a file in the storage
+func storageLocation(st storage.Storage, storageName, remotePath, fileName string) string {
+	switch st.Name() {
+	case "local":
+		// The local storage profiles with a path don't use the storage path
+		if _, ok := storageProfile(storageName); ok && remotePath != "" {
+			return filepath.Join(remotePath, fileName)
+		}
+		return filepath.Join(storagePath, fileName)
+	case "s3":
+		if remotePath == "" {
+			remotePath = utils.GetEnvVariable("AWS_S3_PATH", "S3_PATH")
+		}
+	}
+	return filepath.Join(remotePath, fileName)
+}
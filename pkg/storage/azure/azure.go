@@ -0,0 +1,300 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package azure
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type azureStorage struct {
+	*storage.Backend
+	client        *azblob.Client
+	containerName string
+	accessTier    string
+}
+
+// Config holds the Azure Blob storage config
+type Config struct {
+	AccountName string
+	AccountKey  string
+	// ConnectionString is used instead of the account name and credentials when set
+	ConnectionString string
+	SASToken         string
+	// Endpoint is the blob service URL, e.g: http://127.0.0.1:10000/devstoreaccount1 for Azurite
+	Endpoint      string
+	ContainerName string
+	// AccessTier is Hot, Cool, Cold or Archive, the account default tier is used when empty
+	AccessTier string
+	LocalPath  string
+	RemotePath string
+}
+
+// clientOptions sends the requests through the rate limited transport
+func clientOptions() *azblob.ClientOptions {
+	return &azblob.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Transport: &http.Client{Transport: storage.Transport()}},
+	}
+}
+
+// createClient creates Azure Blob Client, with the first credential found: connection string,
+// SAS token, account key, or Microsoft Entra ID credentials resolved from the environment:
+// service principal with a client secret or certificate, workload identity and managed identity.
+func createClient(conf Config) (*azblob.Client, error) {
+	if conf.ConnectionString != "" {
+		client, err := azblob.NewClientFromConnectionString(conf.ConnectionString, clientOptions())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client from connection string: %w", err)
+		}
+		return client, nil
+	}
+	serviceURL := conf.Endpoint
+	if serviceURL == "" {
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", conf.AccountName)
+	}
+	var client *azblob.Client
+	var err error
+	switch {
+	case conf.SASToken != "":
+		sasURL := strings.TrimSuffix(serviceURL, "/") + "/?" + strings.TrimPrefix(conf.SASToken, "?")
+		client, err = azblob.NewClientWithNoCredential(sasURL, clientOptions())
+	case conf.AccountKey != "":
+		credential, credErr := azblob.NewSharedKeyCredential(conf.AccountName, conf.AccountKey)
+		if credErr != nil {
+			return nil, fmt.Errorf("failed to create credential: %w", credErr)
+		}
+		client, err = azblob.NewClientWithSharedKeyCredential(serviceURL, credential, clientOptions())
+	default:
+		credential, credErr := azidentity.NewDefaultAzureCredential(nil)
+		if credErr != nil {
+			return nil, fmt.Errorf("failed to create credential: %w", credErr)
+		}
+		client, err = azblob.NewClient(serviceURL, credential, clientOptions())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return client, nil
+}
+
+// NewStorage creates new Storage
+func NewStorage(conf Config) (storage.Storage, error) {
+	client, err := createClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &azureStorage{
+		client:        client,
+		containerName: conf.ContainerName,
+		accessTier:    conf.AccessTier,
+		Backend: &storage.Backend{
+			RemotePath: conf.RemotePath,
+			LocalPath:  conf.LocalPath,
+		},
+	}, nil
+}
+
+// Copy copies file to Azure Blob Storage
+func (s azureStorage) Copy(fileName string) error {
+	file, err := os.Open(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", fileName, err)
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	blobName := filepath.Join(s.RemotePath, fileName)
+	if info.Size() > storage.PartSize {
+		return s.resumableUpload(file, info, blobName)
+	}
+	options := &azblob.UploadFileOptions{}
+	if s.accessTier != "" {
+		options.AccessTier = to.Ptr(blob.AccessTier(s.accessTier))
+	}
+	_, err = s.client.UploadFile(context.Background(), s.containerName, blobName, file, options)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+// resumableUpload stages the blocks of a file and commits them, the block IDs are derived from the
+// file size and modification time so the uncommitted blocks of an interrupted upload are reused
+func (s azureStorage) resumableUpload(file *os.File, info os.FileInfo, blobName string) error {
+	ctx := context.Background()
+	client := s.client.ServiceClient().NewContainerClient(s.containerName).NewBlockBlobClient(blobName)
+	staged := make(map[string]int64)
+	blockList, err := client.GetBlockList(ctx, blockblob.BlockListTypeUncommitted, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return fmt.Errorf("failed to get block list: %w", err)
+	}
+	if err == nil {
+		for _, block := range blockList.UncommittedBlocks {
+			if block.Name != nil && block.Size != nil {
+				staged[*block.Name] = *block.Size
+			}
+		}
+	}
+
+	parts := storage.Parts(info.Size(), blockblob.MaxBlocks)
+	blockIDs := make([]string, len(parts))
+	for i, part := range parts {
+		blockIDs[i] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%016x%016x%06d", info.Size(), info.ModTime().UnixNano(), part.Number)))
+	}
+	err = storage.UploadParts(parts, func(part storage.Part) error {
+		blockID := blockIDs[part.Number-1]
+		if size, ok := staged[blockID]; ok && size == part.Size {
+			return nil
+		}
+		_, err := client.StageBlock(ctx, blockID, streaming.NopCloser(io.NewSectionReader(file, part.Offset, part.Size)), nil)
+		if err != nil {
+			return fmt.Errorf("failed to stage block %d: %w", part.Number, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	options := &blockblob.CommitBlockListOptions{}
+	if s.accessTier != "" {
+		options.Tier = to.Ptr(blob.AccessTier(s.accessTier))
+	}
+	if _, err = client.CommitBlockList(ctx, blockIDs, options); err != nil {
+		return fmt.Errorf("failed to commit block list: %w", err)
+	}
+	return nil
+}
+
+// CopyFrom copies a file from Azure Blob Storage to local storage
+func (s azureStorage) CopyFrom(fileName string) error {
+	file, err := os.Create(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+
+	_, err = s.client.DownloadFile(context.Background(), s.containerName, filepath.Join(s.RemotePath, fileName), file, nil)
+	if err != nil {
+		return fmt.Errorf("failed to download blob: %w", err)
+	}
+	return nil
+}
+
+// Open opens a blob of the remote path for reading, the download is limited by the transport
+func (s azureStorage) Open(fileName string) (io.ReadCloser, error) {
+	resp, err := s.client.DownloadStream(context.Background(), s.containerName, filepath.Join(s.RemotePath, fileName), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blob: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// Put streams r to a blob of the remote path
+func (s azureStorage) Put(fileName string, r io.Reader, _ int64) error {
+	options := &azblob.UploadStreamOptions{BlockSize: storage.PartSize}
+	if s.accessTier != "" {
+		options.AccessTier = to.Ptr(blob.AccessTier(s.accessTier))
+	}
+	_, err := s.client.UploadStream(context.Background(), s.containerName, filepath.Join(s.RemotePath, fileName), r, options)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+// List returns the blobs stored in the remote path
+func (s azureStorage) List() ([]storage.File, error) {
+	prefix := storage.Prefix(s.RemotePath)
+	pager := s.client.NewListBlobsFlatPager(s.containerName, &azblob.ListBlobsFlatOptions{Prefix: &prefix})
+	var files []storage.File
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list blobs: %w", err)
+		}
+		for _, blob := range page.Segment.BlobItems {
+			name := strings.TrimPrefix(*blob.Name, prefix)
+			// Skip blobs stored in sub-directories
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			file := storage.File{Name: name}
+			if blob.Properties != nil {
+				if blob.Properties.ContentLength != nil {
+					file.Size = *blob.Properties.ContentLength
+				}
+				if blob.Properties.LastModified != nil {
+					file.ModTime = *blob.Properties.LastModified
+				}
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// Delete deletes a blob from the remote path
+func (s azureStorage) Delete(fileName string) error {
+	_, err := s.client.DeleteBlob(context.Background(), s.containerName, filepath.Join(s.RemotePath, fileName), nil)
+	return err
+}
+
+// Prune deletes old backup created more than specified days
+func (s azureStorage) Prune(retentionDays int) error {
+	return storage.Prune(s, retentionDays)
+}
+
+// Name returns the storage name
+func (s azureStorage) Name() string {
+	return "azure"
+}
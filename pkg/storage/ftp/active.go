@@ -0,0 +1,310 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ftp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/jlaffaye/ftp"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The FTP client only opens passive data connections, the active mode is added by the connections it dials:
+// the PASV command sent on the control connection is replaced by PORT or EPRT with the address of a local
+// listener, and the data connection accepts the connection of the server on that listener.
+
+// passiveCommand is the command the client sends to open a data connection, EPSV is disabled in active mode
+var passiveCommand = []byte("PASV\r\n")
+
+// activeDialer dials the control connection and the data connections of a client in active mode
+type activeDialer struct {
+	dialer    net.Dialer
+	tlsConfig *tls.Config
+	// explicitTLS upgrades the control connection with AUTH TLS before the client uses it, so that
+	// the commands can still be rewritten above the TLS layer
+	explicitTLS bool
+	control     *activeControlConn
+}
+
+// dial dials the control connection on the first call, then the data connections
+func (d *activeDialer) dial(network, address string) (net.Conn, error) {
+	if d.control == nil {
+		conn, err := d.dialControl(network, address)
+		if err != nil {
+			return nil, err
+		}
+		d.control = conn
+		return conn, nil
+	}
+	listener := d.control.takeListener()
+	if listener == nil {
+		return nil, errors.New("no active data connection has been requested")
+	}
+	var conn net.Conn = &activeDataConn{listener: listener}
+	if d.tlsConfig != nil {
+		conn = tls.Client(conn, d.tlsConfig)
+	}
+	return conn, nil
+}
+
+// dialControl dials the control connection, upgraded to TLS when FTPS is used
+func (d *activeDialer) dialControl(network, address string) (*activeControlConn, error) {
+	conn, err := d.dialer.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	control := &activeControlConn{timeout: d.dialer.Timeout}
+	switch {
+	case d.explicitTLS:
+		// The greeting is read before AUTH TLS, it is replayed to the client once the connection is upgraded
+		tp := textproto.NewConn(conn)
+		code, msg, err := tp.ReadResponse(ftp.StatusReady)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		control.reply = []byte(fmt.Sprintf("%d %s\r\n", code, strings.ReplaceAll(msg, "\n", " ")))
+		if _, err = tp.Cmd("AUTH TLS"); err == nil {
+			_, _, err = tp.ReadResponse(ftp.StatusAuthOK)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tls.Client(conn, d.tlsConfig)
+	case d.tlsConfig != nil:
+		conn = tls.Client(conn, d.tlsConfig)
+	}
+	control.Conn = conn
+	control.reader = bufio.NewReader(conn)
+	return control, nil
+}
+
+// activeControlConn is a control connection which replaces PASV by PORT or EPRT
+type activeControlConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	// reply is read by the client before the data of the connection
+	reply []byte
+	// portSent is set until the reply of the PORT or EPRT command has been read
+	portSent bool
+	mu       sync.Mutex
+	listener net.Listener
+}
+
+func (c *activeControlConn) Write(p []byte) (int, error) {
+	if !bytes.Equal(p, passiveCommand) {
+		return c.Conn.Write(p)
+	}
+	local, ok := c.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return 0, fmt.Errorf("unsupported local address %s", c.LocalAddr())
+	}
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: local.IP})
+	if err != nil {
+		return 0, fmt.Errorf("failed to listen for the active data connection: %w", err)
+	}
+	if c.timeout > 0 {
+		_ = listener.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if _, err = c.Conn.Write([]byte(portCommand(listener.Addr().(*net.TCPAddr)) + "\r\n")); err != nil {
+		_ = listener.Close()
+		return 0, err
+	}
+	c.mu.Lock()
+	c.listener = listener
+	c.mu.Unlock()
+	c.portSent = true
+	return len(p), nil
+}
+
+func (c *activeControlConn) Read(p []byte) (int, error) {
+	if c.portSent {
+		c.portSent = false
+		code, reply, err := readReply(c.reader)
+		if err != nil {
+			return 0, err
+		}
+		if code/100 == 2 {
+			// The client expects the reply of PASV, the address is ignored as the data connection is accepted
+			reply = []byte(fmt.Sprintf("%d Entering Active Mode (127,0,0,1,0,0).\r\n", ftp.StatusPassiveMode))
+		} else if listener := c.takeListener(); listener != nil {
+			_ = listener.Close()
+		}
+		c.reply = append(c.reply, reply...)
+	}
+	if len(c.reply) > 0 {
+		n := copy(p, c.reply)
+		c.reply = c.reply[n:]
+		return n, nil
+	}
+	return c.reader.Read(p)
+}
+
+func (c *activeControlConn) Close() error {
+	if listener := c.takeListener(); listener != nil {
+		_ = listener.Close()
+	}
+	return c.Conn.Close()
+}
+
+// takeListener returns the listener of the data connection requested by the last PORT or EPRT command
+func (c *activeControlConn) takeListener() net.Listener {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	listener := c.listener
+	c.listener = nil
+	return listener
+}
+
+// portCommand returns the PORT command of an IPv4 address, or the EPRT command of an IPv6 address
+func portCommand(addr *net.TCPAddr) string {
+	if ip := addr.IP.To4(); ip != nil {
+		return fmt.Sprintf("PORT %d,%d,%d,%d,%d,%d", ip[0], ip[1], ip[2], ip[3], addr.Port/256, addr.Port%256)
+	}
+	return fmt.Sprintf("EPRT |2|%s|%d|", addr.IP, addr.Port)
+}
+
+// readReply reads a single or multi-line reply and returns its code and its raw lines
+func readReply(r *bufio.Reader) (int, []byte, error) {
+	var reply []byte
+	var code string
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return 0, nil, err
+		}
+		reply = append(reply, line...)
+		if len(line) < 4 {
+			continue
+		}
+		if code == "" {
+			code = string(line[:3])
+		}
+		if string(line[:3]) == code && line[3] == ' ' {
+			break
+		}
+	}
+	var status int
+	if _, err := fmt.Sscanf(code, "%d", &status); err != nil {
+		return 0, nil, fmt.Errorf("invalid FTP reply %q", reply)
+	}
+	return status, reply, nil
+}
+
+// activeDataConn is a data connection accepted from the server on the first read or write
+type activeDataConn struct {
+	listener net.Listener
+	once     sync.Once
+	conn     net.Conn
+	err      error
+}
+
+func (c *activeDataConn) accept() (net.Conn, error) {
+	c.once.Do(func() {
+		c.conn, c.err = c.listener.Accept()
+		_ = c.listener.Close()
+		if c.err != nil {
+			c.err = fmt.Errorf("the server did not open the active data connection: %w", c.err)
+		}
+	})
+	return c.conn, c.err
+}
+
+func (c *activeDataConn) Read(p []byte) (int, error) {
+	conn, err := c.accept()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Read(p)
+}
+
+func (c *activeDataConn) Write(p []byte) (int, error) {
+	conn, err := c.accept()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Write(p)
+}
+
+// Handshake accepts the connection of the server, the client calls it after an empty upload
+func (c *activeDataConn) Handshake() error {
+	_, err := c.accept()
+	return err
+}
+
+func (c *activeDataConn) Close() error {
+	c.once.Do(func() {
+		c.err = net.ErrClosed
+	})
+	_ = c.listener.Close()
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *activeDataConn) LocalAddr() net.Addr {
+	if c.conn != nil {
+		return c.conn.LocalAddr()
+	}
+	return c.listener.Addr()
+}
+
+func (c *activeDataConn) RemoteAddr() net.Addr {
+	if c.conn != nil {
+		return c.conn.RemoteAddr()
+	}
+	return c.listener.Addr()
+}
+
+func (c *activeDataConn) SetDeadline(t time.Time) error {
+	if c.conn != nil {
+		return c.conn.SetDeadline(t)
+	}
+	return nil
+}
+
+func (c *activeDataConn) SetReadDeadline(t time.Time) error {
+	if c.conn != nil {
+		return c.conn.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *activeDataConn) SetWriteDeadline(t time.Time) error {
+	if c.conn != nil {
+		return c.conn.SetWriteDeadline(t)
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ftp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jlaffaye/ftp"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// TLSExplicit upgrades the connection to TLS with AUTH TLS
+	TLSExplicit = "explicit"
+	// TLSImplicit connects with TLS, usually on port 990
+	TLSImplicit = "implicit"
+	// ModePassive opens the data connections to the server with EPSV or PASV
+	ModePassive = "passive"
+	// ModeActive lets the server open the data connections with PORT or EPRT
+	ModeActive = "active"
+	// partSuffix is added to files being uploaded until they are complete
+	partSuffix = ".part"
+)
+
+type ftpStorage struct {
+	*storage.Backend
+	config Config
+}
+
+// Config holds the FTP connection details
+type Config struct {
+	Host     string
+	User     string
+	Password string
+	Port     int
+	// TLS is empty for plain FTP, TLSExplicit or TLSImplicit
+	TLS string
+	// CACert is a CA certificate file used to verify the server certificate
+	CACert             string
+	InsecureSkipVerify bool
+	// Mode is ModePassive or ModeActive, empty for the passive mode
+	Mode string
+	// DisableEPSV uses PASV instead of EPSV for passive data connections
+	DisableEPSV bool
+	LocalPath   string
+	RemotePath  string
+}
+
+// tlsConfig returns the TLS configuration, sessions are cached as many servers require
+// the data connection to reuse the session of the control connection
+func tlsConfig(conf Config) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         conf.Host,
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	if conf.CACert != "" {
+		caCert, err := os.ReadFile(conf.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse CA certificate %s", conf.CACert)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// createClient creates FTP Client
+func createClient(conf Config) (*ftp.ServerConn, error) {
+	options := []ftp.DialOption{ftp.DialWithTimeout(5 * time.Second), ftp.DialWithDisabledEPSV(conf.DisableEPSV)}
+	var config *tls.Config
+	if conf.TLS != "" {
+		var err error
+		if config, err = tlsConfig(conf); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Mode == ModeActive {
+		// The dialer upgrades the connections to TLS itself, EPSV is disabled so that PASV is replaced
+		dialer := &activeDialer{dialer: net.Dialer{Timeout: 5 * time.Second}, tlsConfig: config, explicitTLS: conf.TLS == TLSExplicit}
+		options = append(options, ftp.DialWithDisabledEPSV(true), ftp.DialWithDialFunc(dialer.dial))
+		if config != nil {
+			options = append(options, ftp.DialWithTLS(config))
+		}
+	} else {
+		switch conf.TLS {
+		case "":
+		case TLSExplicit:
+			options = append(options, ftp.DialWithExplicitTLS(config))
+		case TLSImplicit:
+			options = append(options, ftp.DialWithTLS(config))
+		default:
+			return nil, fmt.Errorf("unsupported FTP TLS mode %q", conf.TLS)
+		}
+	}
+	ftpClient, err := ftp.Dial(net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port)), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to FTP: %w", err)
+	}
+
+	err = ftpClient.Login(conf.User, conf.Password)
+	if err != nil {
+		_ = ftpClient.Quit()
+		return nil, fmt.Errorf("failed to log in to FTP: %w", err)
+	}
+
+	return ftpClient, nil
+}
+
+// NewStorage creates new Storage
+func NewStorage(conf Config) (storage.Storage, error) {
+	if conf.TLS != "" && conf.TLS != TLSExplicit && conf.TLS != TLSImplicit {
+		return nil, fmt.Errorf("unsupported FTP TLS mode %q", conf.TLS)
+	}
+	if conf.Mode != "" && conf.Mode != ModePassive && conf.Mode != ModeActive {
+		return nil, fmt.Errorf("unsupported FTP mode %q", conf.Mode)
+	}
+	return &ftpStorage{
+		config: conf,
+		Backend: &storage.Backend{
+			RemotePath: conf.RemotePath,
+			LocalPath:  conf.LocalPath,
+		},
+	}, nil
+}
+
+// Copy copies file to the remote server, the file is uploaded with the .part suffix and renamed
+// once complete. The upload resumes from the size of the partial file left by an interrupted one,
+// the partial file is kept on failure so that a retry of the copy resumes it.
+func (s ftpStorage) Copy(fileName string) error {
+	file, err := os.Open(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", fileName, err)
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %w", fileName, err)
+	}
+
+	remoteFile := path.Join(s.RemotePath, fileName)
+	offset := s.remoteSize(remoteFile + partSuffix)
+	if offset > info.Size() {
+		offset = 0
+	}
+	if err = s.upload(file, remoteFile, offset); err != nil {
+		return fmt.Errorf("failed to upload file %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// deletePart deletes the partial file of a failed upload
+func (s ftpStorage) deletePart(remoteFile string) {
+	ftpClient, err := createClient(s.config)
+	if err != nil {
+		return
+	}
+	defer quit(ftpClient)
+	_ = ftpClient.Delete(remoteFile)
+}
+
+// upload uploads the file from offset and renames it once complete
+func (s ftpStorage) upload(file *os.File, remoteFile string, offset int64) error {
+	ftpClient, err := createClient(s.config)
+	if err != nil {
+		return err
+	}
+	defer quit(ftpClient)
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if err = ftpClient.StorFrom(remoteFile+partSuffix, storage.UploadReader(file), uint64(offset)); err != nil {
+		return err
+	}
+	return ftpClient.Rename(remoteFile+partSuffix, remoteFile)
+}
+
+// remoteSize returns the size of a remote file, zero when it is unknown to restart the transfer
+func (s ftpStorage) remoteSize(remoteFile string) int64 {
+	ftpClient, err := createClient(s.config)
+	if err != nil {
+		return 0
+	}
+	defer quit(ftpClient)
+	size, err := ftpClient.FileSize(remoteFile)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// CopyFrom copies a file from the remote server to local storage
+func (s ftpStorage) CopyFrom(fileName string) error {
+	ftpClient, err := createClient(s.config)
+	if err != nil {
+		return err
+	}
+	defer quit(ftpClient)
+
+	r, err := ftpClient.Retr(path.Join(s.RemotePath, fileName))
+	if err != nil {
+		return fmt.Errorf("failed to retrieve file %s: %w", fileName, err)
+	}
+	defer func(r *ftp.Response) {
+		err := r.Close()
+		if err != nil {
+			return
+		}
+	}(r)
+
+	outFile, err := os.Create(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return fmt.Errorf("failed to create local file %s: %w", fileName, err)
+	}
+	if _, err = io.Copy(outFile, storage.DownloadReader(r)); err != nil {
+		_ = outFile.Close()
+		return fmt.Errorf("failed to copy data to local file %s: %w", fileName, err)
+	}
+	return outFile.Close()
+}
+
+// Open opens a file of the remote path for reading, the connection is closed with the reader
+func (s ftpStorage) Open(fileName string) (io.ReadCloser, error) {
+	ftpClient, err := createClient(s.config)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ftpClient.Retr(path.Join(s.RemotePath, fileName))
+	if err != nil {
+		quit(ftpClient)
+		return nil, fmt.Errorf("failed to retrieve file %s: %w", fileName, err)
+	}
+	return storage.NewReadCloser(storage.DownloadReader(r), func() error {
+		defer quit(ftpClient)
+		return r.Close()
+	}), nil
+}
+
+// Put streams r to a file of the remote path, it is uploaded with the .part suffix and renamed once complete
+func (s ftpStorage) Put(fileName string, r io.Reader, _ int64) error {
+	ftpClient, err := createClient(s.config)
+	if err != nil {
+		return err
+	}
+	defer quit(ftpClient)
+
+	remoteFile := path.Join(s.RemotePath, fileName)
+	if err = ftpClient.StorFrom(remoteFile+partSuffix, storage.UploadReader(r), 0); err == nil {
+		err = ftpClient.Rename(remoteFile+partSuffix, remoteFile)
+	}
+	if err != nil {
+		s.deletePart(remoteFile + partSuffix)
+		return fmt.Errorf("failed to upload file %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// List returns the files stored in the remote path
+func (s ftpStorage) List() ([]storage.File, error) {
+	ftpClient, err := createClient(s.config)
+	if err != nil {
+		return nil, err
+	}
+	defer quit(ftpClient)
+
+	entries, err := ftpClient.List(s.RemotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote directory: %w", err)
+	}
+	files := make([]storage.File, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type == ftp.EntryTypeFile {
+			files = append(files, storage.File{Name: entry.Name, Size: int64(entry.Size), ModTime: entry.Time})
+		}
+	}
+	return files, nil
+}
+
+// Delete deletes a file from the remote path
+func (s ftpStorage) Delete(fileName string) error {
+	ftpClient, err := createClient(s.config)
+	if err != nil {
+		return err
+	}
+	defer quit(ftpClient)
+	return ftpClient.Delete(path.Join(s.RemotePath, fileName))
+}
+
+// Prune deletes old backup created more than specified days
+func (s ftpStorage) Prune(retentionDays int) error {
+	return storage.Prune(s, retentionDays)
+}
+
+// Name returns the storage name
+func (s ftpStorage) Name() string {
+	return "ftp"
+}
+
+func quit(ftpClient *ftp.ServerConn) {
+	_ = ftpClient.Quit()
+}
@@ -0,0 +1,191 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gcs
+
+import (
+	gstorage "cloud.google.com/go/storage"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type gcsStorage struct {
+	*storage.Backend
+	client *gstorage.Client
+	bucket string
+}
+
+// Config holds the Google Cloud Storage config
+type Config struct {
+	BucketName string
+	// Endpoint overrides the storage API endpoint, e.g: a fake GCS server
+	Endpoint   string
+	LocalPath  string
+	RemotePath string
+}
+
+// NewStorage creates new Storage, credentials are resolved from GOOGLE_APPLICATION_CREDENTIALS
+// or the workload identity of the environment
+func NewStorage(conf Config) (storage.Storage, error) {
+	var opts []option.ClientOption
+	if conf.Endpoint != "" {
+		// Emulators only implement the JSON API
+		opts = append(opts, option.WithEndpoint(conf.Endpoint), option.WithoutAuthentication(), gstorage.WithJSONReads())
+	}
+	client, err := gstorage.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return &gcsStorage{
+		client: client,
+		bucket: conf.BucketName,
+		Backend: &storage.Backend{
+			RemotePath: conf.RemotePath,
+			LocalPath:  conf.LocalPath,
+		},
+	}, nil
+}
+
+// Copy copies file to Google Cloud Storage
+func (s gcsStorage) Copy(fileName string) error {
+	file, err := os.Open(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", fileName, err)
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+
+	return s.Put(fileName, file, -1)
+}
+
+// Put streams r to an object of the remote path
+func (s gcsStorage) Put(fileName string, r io.Reader, _ int64) error {
+	writer := s.client.Bucket(s.bucket).Object(s.objectName(fileName)).NewWriter(context.Background())
+	if _, err := io.Copy(writer, storage.UploadReader(r)); err != nil {
+		_ = writer.Close()
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+// CopyFrom copies a file from Google Cloud Storage to local storage
+func (s gcsStorage) CopyFrom(fileName string) error {
+	reader, err := s.client.Bucket(s.bucket).Object(s.objectName(fileName)).NewReader(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to download object: %w", err)
+	}
+	defer func(reader *gstorage.Reader) {
+		err := reader.Close()
+		if err != nil {
+			return
+		}
+	}(reader)
+
+	file, err := os.Create(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+
+	if _, err = io.Copy(file, storage.DownloadReader(reader)); err != nil {
+		return fmt.Errorf("failed to download object: %w", err)
+	}
+	return nil
+}
+
+// Open opens an object of the remote path for reading
+func (s gcsStorage) Open(fileName string) (io.ReadCloser, error) {
+	reader, err := s.client.Bucket(s.bucket).Object(s.objectName(fileName)).NewReader(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to download object: %w", err)
+	}
+	return storage.NewReadCloser(storage.DownloadReader(reader), reader.Close), nil
+}
+
+// List returns the objects stored in the remote path
+func (s gcsStorage) List() ([]storage.File, error) {
+	prefix := strings.TrimPrefix(storage.Prefix(s.RemotePath), "/")
+	it := s.client.Bucket(s.bucket).Objects(context.Background(), &gstorage.Query{Prefix: prefix, Delimiter: "/"})
+	var files []storage.File
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		// Skip sub-directories
+		if attrs.Name == "" {
+			continue
+		}
+		files = append(files, storage.File{
+			Name:    strings.TrimPrefix(attrs.Name, prefix),
+			Size:    attrs.Size,
+			ModTime: attrs.Created,
+		})
+	}
+	return files, nil
+}
+
+// Delete deletes an object from the remote path
+func (s gcsStorage) Delete(fileName string) error {
+	return s.client.Bucket(s.bucket).Object(s.objectName(fileName)).Delete(context.Background())
+}
+
+// Prune deletes old backup created more than specified days
+func (s gcsStorage) Prune(retentionDays int) error {
+	return storage.Prune(s, retentionDays)
+}
+
+// Name returns the storage name
+func (s gcsStorage) Name() string {
+	return "gcs"
+}
+
+// objectName returns the object name of a file, GCS object names have no leading slash
+func (s gcsStorage) objectName(fileName string) string {
+	return strings.TrimPrefix(path.Join(s.RemotePath, fileName), "/")
+}
@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package local
+
+import (
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type localStorage struct {
+	*storage.Backend
+}
+
+// Config holds the local storage paths
+type Config struct {
+	LocalPath  string
+	RemotePath string
+}
+
+// NewStorage creates new Storage
+func NewStorage(conf Config) storage.Storage {
+	return &localStorage{
+		Backend: &storage.Backend{
+			LocalPath:  conf.LocalPath,
+			RemotePath: conf.RemotePath,
+		},
+	}
+}
+
+// Copy copies file to the local destination path
+func (l localStorage) Copy(file string) error {
+	if _, err := os.Stat(filepath.Join(l.LocalPath, file)); os.IsNotExist(err) {
+		return err
+	}
+	return copyFile(filepath.Join(l.LocalPath, file), filepath.Join(l.RemotePath, file), storage.UploadReader)
+}
+
+// CopyFrom copies file from a Path to local path
+func (l localStorage) CopyFrom(file string) error {
+	if _, err := os.Stat(filepath.Join(l.RemotePath, file)); os.IsNotExist(err) {
+		return err
+	}
+	return copyFile(filepath.Join(l.RemotePath, file), filepath.Join(l.LocalPath, file), storage.DownloadReader)
+}
+
+// Open opens a file of the destination path
+func (l localStorage) Open(file string) (io.ReadCloser, error) {
+	in, err := os.Open(filepath.Join(l.RemotePath, file))
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewReadCloser(storage.DownloadReader(in), in.Close), nil
+}
+
+// Put writes r to a file of the destination path
+func (l localStorage) Put(file string, r io.Reader, _ int64) error {
+	out, err := os.Create(filepath.Join(l.RemotePath, file))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, storage.UploadReader(r)); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// List returns the files stored in the destination path
+func (l localStorage) List() ([]storage.File, error) {
+	entries, err := os.ReadDir(l.RemotePath)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]storage.File, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, storage.File{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+// Delete deletes a file from the destination path
+func (l localStorage) Delete(file string) error {
+	return os.Remove(filepath.Join(l.RemotePath, file))
+}
+
+// Prune deletes old backup created more than specified days
+func (l localStorage) Prune(retentionDays int) error {
+	return storage.Prune(l, retentionDays)
+}
+
+// Name returns the storage name
+func (l localStorage) Name() string {
+	return "local"
+}
+
+// copyFile copies file, the source is read through limit
+func copyFile(src, dst string, limit func(io.Reader) io.Reader) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func(in *os.File) {
+		err := in.Close()
+		if err != nil {
+			return
+		}
+	}(in)
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, limit(in)); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
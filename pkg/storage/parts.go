@@ -0,0 +1,92 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package storage
+
+import (
+	"sync"
+)
+
+const (
+	// PartSize is the minimum size of the parts of resumable uploads, it must not change
+	// between attempts so the parts already uploaded can be reused
+	PartSize int64 = 16 << 20
+	// partConcurrency is the number of parts uploaded concurrently
+	partConcurrency = 4
+)
+
+// Part is a part of a file uploaded in several parts
+type Part struct {
+	// Number starts from 1
+	Number int
+	Offset int64
+	Size   int64
+}
+
+// Parts splits a file of the given size in parts of at least PartSize, up to maxParts
+func Parts(size int64, maxParts int) []Part {
+	partSize := max(PartSize, (size+int64(maxParts)-1)/int64(maxParts))
+	parts := make([]Part, 0, (size+partSize-1)/partSize)
+	for offset := int64(0); offset < size; offset += partSize {
+		parts = append(parts, Part{Number: len(parts) + 1, Offset: offset, Size: min(partSize, size-offset)})
+	}
+	return parts
+}
+
+// UploadParts uploads the parts concurrently and returns the first error
+func UploadParts(parts []Part, upload func(part Part) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	queue := make(chan Part)
+	for range partConcurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range queue {
+				if err := upload(part); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, part := range parts {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		queue <- part
+	}
+	close(queue)
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,171 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// The rate limits are shared by all the storages and the concurrent transfers of the process
+var (
+	uploadLimiter   *rate.Limiter
+	downloadLimiter *rate.Limiter
+)
+
+// SetRateLimits limits the bandwidth of uploads and downloads in bytes per second, zero disables the limit
+func SetRateLimits(upload, download int64) {
+	uploadLimiter = NewLimiter(upload)
+	downloadLimiter = NewLimiter(download)
+}
+
+// NewLimiter returns a limiter of bytesPerSecond, nil when the rate is zero
+func NewLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	// Allow bursts of 100ms, at least 32 KiB so reads are not split too much
+	burst := max(int(bytesPerSecond/10), 32<<10)
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+// BandwidthLimit returns the limits in the rclone --bwlimit format UP:DOWN, empty without limits
+func BandwidthLimit() string {
+	if uploadLimiter == nil && downloadLimiter == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s", bandwidth(uploadLimiter), bandwidth(downloadLimiter))
+}
+
+func bandwidth(limiter *rate.Limiter) string {
+	if limiter == nil {
+		return "off"
+	}
+	return fmt.Sprintf("%dB", int64(limiter.Limit()))
+}
+
+// UploadReader limits the rate of a reader of uploaded data
+func UploadReader(r io.Reader) io.Reader {
+	return LimitReader(r, uploadLimiter)
+}
+
+// DownloadReader limits the rate of a reader of downloaded data
+func DownloadReader(r io.Reader) io.Reader {
+	return LimitReader(r, downloadLimiter)
+}
+
+// LimitReader returns a reader limited by the limiter, r is returned when the limiter is nil
+func LimitReader(r io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &limitedReader{r: r, limiter: limiter}
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > l.limiter.Burst() {
+		p = p[:l.limiter.Burst()]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if waitErr := l.limiter.WaitN(context.Background(), n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// LimitWriter returns a writer limited by the limiter, w is returned when the limiter is nil
+func LimitWriter(w io.Writer, limiter *rate.Limiter) io.Writer {
+	if limiter == nil {
+		return w
+	}
+	return &limitedWriter{w: w, limiter: limiter}
+}
+
+type limitedWriter struct {
+	w       io.Writer
+	limiter *rate.Limiter
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:min(len(p), written+l.limiter.Burst())]
+		if err := l.limiter.WaitN(context.Background(), len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := l.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Transport returns an HTTP transport limiting the rate of its connections, writes count as uploads
+// and reads as downloads. The default transport is returned when there is no limit.
+func Transport() http.RoundTripper {
+	if uploadLimiter == nil && downloadLimiter == nil {
+		return http.DefaultTransport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = DialContext
+	return transport
+}
+
+// DialContext connects to the address, the connection is limited by the upload and download rates
+func DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil || (uploadLimiter == nil && downloadLimiter == nil) {
+		return conn, err
+	}
+	return &limitedConn{Conn: conn}, nil
+}
+
+type limitedConn struct {
+	net.Conn
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	return LimitReader(c.Conn, downloadLimiter).Read(p)
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	return LimitWriter(c.Conn, uploadLimiter).Write(p)
+}
@@ -0,0 +1,238 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package rclone
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// rclone exits with this code when the directory does not exist
+const directoryNotFound = 3
+
+type rcloneStorage struct {
+	*storage.Backend
+	config Config
+}
+
+// Config holds the rclone remote details
+type Config struct {
+	// Remote is the rclone remote and its base path, e.g: gdrive:backups
+	Remote string
+	// ConfigFile is the rclone config file, rclone default config is used when empty
+	ConfigFile string
+	// Flags are additional rclone flags
+	Flags      []string
+	LocalPath  string
+	RemotePath string
+}
+
+type lsJSONItem struct {
+	Name    string    `json:"Name"`
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+	IsDir   bool      `json:"IsDir"`
+}
+
+// NewStorage creates new Storage
+func NewStorage(conf Config) (storage.Storage, error) {
+	if _, err := exec.LookPath("rclone"); err != nil {
+		return nil, fmt.Errorf("rclone binary not found: %w", err)
+	}
+	if !strings.Contains(conf.Remote, ":") {
+		return nil, fmt.Errorf("invalid rclone remote %q, expected remote:path", conf.Remote)
+	}
+	return &rcloneStorage{
+		config: conf,
+		Backend: &storage.Backend{
+			RemotePath: conf.RemotePath,
+			LocalPath:  conf.LocalPath,
+		},
+	}, nil
+}
+
+// Copy copies file to the rclone remote
+func (s rcloneStorage) Copy(fileName string) error {
+	if _, err := s.run("copyto", filepath.Join(s.LocalPath, fileName), s.remoteFile(fileName)); err != nil {
+		return fmt.Errorf("failed to upload file %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// CopyFrom copies a file from the rclone remote to local storage
+func (s rcloneStorage) CopyFrom(fileName string) error {
+	if _, err := s.run("copyto", s.remoteFile(fileName), filepath.Join(s.LocalPath, fileName)); err != nil {
+		return fmt.Errorf("failed to download file %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// Open streams a file of the rclone remote with rclone cat
+func (s rcloneStorage) Open(fileName string) (io.ReadCloser, error) {
+	cmd := s.command("cat", s.remoteFile(fileName))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to download file %s: %w", fileName, err)
+	}
+	return &catReader{cmd: cmd, stdout: stdout, stderr: stderr}, nil
+}
+
+// Put streams r to a file of the rclone remote with rclone rcat
+func (s rcloneStorage) Put(fileName string, r io.Reader, _ int64) error {
+	cmd := s.command("rcat", s.remoteFile(fileName))
+	var stderr bytes.Buffer
+	cmd.Stdin = r
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to upload file %s: rclone rcat: %w, output: %s", fileName, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// List returns the files stored in the remote path
+func (s rcloneStorage) List() ([]storage.File, error) {
+	output, err := s.run("lsjson", "--files-only", s.remoteFile(""))
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == directoryNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	var items []lsJSONItem
+	if err = json.Unmarshal(output, &items); err != nil {
+		return nil, fmt.Errorf("failed to parse the file list: %w", err)
+	}
+	files := make([]storage.File, 0, len(items))
+	for _, item := range items {
+		if item.IsDir {
+			continue
+		}
+		files = append(files, storage.File{Name: item.Name, Size: item.Size, ModTime: item.ModTime})
+	}
+	return files, nil
+}
+
+// Delete deletes a file from the remote path
+func (s rcloneStorage) Delete(fileName string) error {
+	_, err := s.run("deletefile", s.remoteFile(fileName))
+	return err
+}
+
+// Prune deletes old backup created more than specified days
+func (s rcloneStorage) Prune(retentionDays int) error {
+	return storage.Prune(s, retentionDays)
+}
+
+// Name returns the storage name
+func (s rcloneStorage) Name() string {
+	return "rclone"
+}
+
+// remoteFile returns the rclone path of a file, the remote path is relative to the remote
+func (s rcloneStorage) remoteFile(fileName string) string {
+	remote, basePath, _ := strings.Cut(s.config.Remote, ":")
+	filePath := path.Join(basePath, s.RemotePath, fileName)
+	if basePath == "" {
+		filePath = strings.TrimPrefix(filePath, "/")
+	}
+	return remote + ":" + filePath
+}
+
+// command returns a rclone command with the config file, bandwidth limit and additional flags
+func (s rcloneStorage) command(args ...string) *exec.Cmd {
+	if s.config.ConfigFile != "" {
+		args = append(args, "--config", s.config.ConfigFile)
+	}
+	if bwLimit := storage.BandwidthLimit(); bwLimit != "" {
+		args = append(args, "--bwlimit", bwLimit)
+	}
+	args = append(args, s.config.Flags...)
+	return exec.Command("rclone", args...)
+}
+
+// run runs a rclone command and returns its output
+func (s rcloneStorage) run(args ...string) ([]byte, error) {
+	cmd := s.command(args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("rclone %s: %w, output: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// catReader reads the output of rclone cat, the exit error of rclone is returned at the end of the output
+type catReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr *bytes.Buffer
+	done   bool
+	err    error
+}
+
+func (r *catReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		if waitErr := r.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Close stops rclone when the output was not read to the end
+func (r *catReader) Close() error {
+	if !r.done {
+		_ = r.stdout.Close()
+		_ = r.wait()
+	}
+	return nil
+}
+
+func (r *catReader) wait() error {
+	if !r.done {
+		r.done = true
+		if err := r.cmd.Wait(); err != nil {
+			r.err = fmt.Errorf("rclone cat: %w, output: %s", err, strings.TrimSpace(r.stderr.String()))
+		}
+	}
+	return r.err
+}
@@ -0,0 +1,145 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy defines how failed storage operations are retried, with an exponential backoff and jitter
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, 1 disables retries
+	Attempts int
+	// Backoff is the wait before the first retry, doubled after each attempt
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// OnRetry is called before waiting for the next attempt
+	OnRetry func(operation string, attempt int, wait time.Duration, err error)
+}
+
+type retryStorage struct {
+	Storage
+	policy RetryPolicy
+}
+
+// WithRetry returns a storage retrying the failed operations of s
+func WithRetry(s Storage, policy RetryPolicy) Storage {
+	if policy.Attempts <= 1 {
+		return s
+	}
+	return &retryStorage{Storage: s, policy: policy}
+}
+
+// wait returns the wait before the next attempt, between half and the full exponential backoff
+func (r *retryStorage) wait(attempt int) time.Duration {
+	backoff := r.policy.Backoff << (attempt - 1)
+	if backoff <= 0 || (r.policy.MaxBackoff > 0 && backoff > r.policy.MaxBackoff) {
+		backoff = r.policy.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+func (r *retryStorage) retry(operation string, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		// Missing local files and unsupported operations can't be fixed by retrying
+		if attempt >= r.policy.Attempts || errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrNotSupported) || errors.Is(err, ErrRetained) {
+			return err
+		}
+		wait := r.wait(attempt)
+		if r.policy.OnRetry != nil {
+			r.policy.OnRetry(operation, attempt, wait, err)
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Retry runs fn with the retry policy of s, fn is run once when s doesn't retry its operations.
+// It retries operations made of several storage calls, e.g: a streamed copy reopening its source
+func Retry(s Storage, operation string, fn func() error) error {
+	if r, ok := s.(*retryStorage); ok {
+		return r.retry(operation, fn)
+	}
+	return fn()
+}
+
+// Copy uploads a file, retrying on failure
+func (r *retryStorage) Copy(fileName string) error {
+	return r.retry("upload of "+fileName, func() error { return r.Storage.Copy(fileName) })
+}
+
+// CopyFrom downloads a file, retrying on failure
+func (r *retryStorage) CopyFrom(fileName string) error {
+	return r.retry("download of "+fileName, func() error { return r.Storage.CopyFrom(fileName) })
+}
+
+// Open opens a file, retrying on failure. Put is not retried as its reader can't be rewound
+func (r *retryStorage) Open(fileName string) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	err := r.retry("download of "+fileName, func() error {
+		var err error
+		reader, err = r.Storage.Open(fileName)
+		return err
+	})
+	return reader, err
+}
+
+// List lists the files, retrying on failure
+func (r *retryStorage) List() ([]File, error) {
+	var files []File
+	err := r.retry("listing", func() error {
+		var err error
+		files, err = r.Storage.List()
+		return err
+	})
+	return files, err
+}
+
+// Delete deletes a file, retrying on failure
+func (r *retryStorage) Delete(fileName string) error {
+	return r.retry("deletion of "+fileName, func() error { return r.Storage.Delete(fileName) })
+}
+
+// Prune deletes old files, the listing and each deletion are retried
+func (r *retryStorage) Prune(retentionDays int) error {
+	return Prune(r, retentionDays)
+}
+
+// SetTags sets the tags of the next uploaded files when the storage supports tags
+func (r *retryStorage) SetTags(tags map[string]string) {
+	if tagger, ok := r.Storage.(Tagger); ok {
+		tagger.SetTags(tags)
+	}
+}
@@ -0,0 +1,495 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SSECustomer enables server-side encryption with a customer provided key
+const SSECustomer = "SSE-C"
+
+type s3Storage struct {
+	*storage.Backend
+	client  *session.Session
+	bucket  string
+	options Options
+	tags    map[string]string
+}
+
+// Config holds the AWS S3 config
+type Config struct {
+	Endpoint       string
+	Bucket         string
+	AccessKey      string
+	SecretKey      string
+	Region         string
+	DisableSsl     bool
+	ForcePathStyle bool
+	LocalPath      string
+	RemotePath     string
+	// RoleARN is the role assumed with STS, using the resolved credentials
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	Options         Options
+}
+
+// Options holds the options applied to uploaded objects
+type Options struct {
+	// ServerSideEncryption is AES256, aws:kms or SSE-C
+	ServerSideEncryption string
+	SSEKMSKeyID          string
+	// SSECustomerKey is the 256-bit key used with SSE-C
+	SSECustomerKey string
+	StorageClass   string
+	// ObjectLockMode is GOVERNANCE or COMPLIANCE, objects are locked for ObjectLockRetention
+	ObjectLockMode      string
+	ObjectLockRetention time.Duration
+	LegalHold           bool
+	Tags                map[string]string
+}
+
+// createSession creates a new AWS session
+func createSession(conf Config) (*session.Session, error) {
+	creds, err := resolveCredentials(conf)
+	if err != nil {
+		return nil, err
+	}
+	s3Config := &aws.Config{
+		Credentials:      creds,
+		Endpoint:         aws.String(conf.Endpoint),
+		Region:           aws.String(conf.Region),
+		DisableSSL:       aws.Bool(conf.DisableSsl),
+		S3ForcePathStyle: aws.Bool(conf.ForcePathStyle),
+		HTTPClient:       &http.Client{Transport: storage.Transport()},
+	}
+
+	return session.NewSession(s3Config)
+}
+
+// resolveCredentials returns the static credentials when the keys are set, otherwise the first
+// credentials resolved from the default chain: environment, web identity (IRSA), shared config
+// files with AWS_PROFILE, ECS task role and EC2 instance profile.
+// The credentials are resolved with a session without the S3 endpoint, so STS uses its own endpoint.
+func resolveCredentials(conf Config) (*credentials.Credentials, error) {
+	awsConfig := aws.Config{
+		Region:                        aws.String(conf.Region),
+		CredentialsChainVerboseErrors: aws.Bool(true),
+	}
+	if conf.AccessKey != "" && conf.SecretKey != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(conf.AccessKey, conf.SecretKey, "")
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve AWS credentials: %w", err)
+	}
+	creds := sess.Config.Credentials
+	if conf.RoleARN != "" {
+		creds = stscreds.NewCredentials(sess, conf.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if conf.ExternalID != "" {
+				p.ExternalID = aws.String(conf.ExternalID)
+			}
+			if conf.RoleSessionName != "" {
+				p.RoleSessionName = conf.RoleSessionName
+			}
+		})
+	}
+	if _, err = creds.Get(); err != nil {
+		return nil, fmt.Errorf("no AWS credentials found, set AWS_ACCESS_KEY and AWS_SECRET_KEY or use a role: %w", err)
+	}
+	return creds, nil
+}
+
+// NewStorage creates new Storage
+func NewStorage(conf Config) (storage.Storage, error) {
+	sess, err := createSession(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Storage{
+		client:  sess,
+		bucket:  conf.Bucket,
+		options: conf.Options,
+		Backend: &storage.Backend{
+			RemotePath: conf.RemotePath,
+			LocalPath:  conf.LocalPath,
+		},
+	}, nil
+}
+
+// Copy copies file to S3 storage
+func (s s3Storage) Copy(fileName string) error {
+	file, err := os.Open(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(filepath.Join(s.RemotePath, fileName)),
+		Body:   file,
+	}
+	s.applyOptions(input)
+	if info.Size() > storage.PartSize {
+		return s.resumableUpload(file, info, input)
+	}
+	uploader := s3manager.NewUploader(s.client)
+	_, err = uploader.Upload(input)
+	return err
+}
+
+// resumableUpload uploads a file with a multipart upload, the parts of an upload interrupted
+// after the file was created are reused. Older uploads of the same key are aborted.
+func (s s3Storage) resumableUpload(file *os.File, info os.FileInfo, input *s3manager.UploadInput) error {
+	svc := s3.New(s.client)
+	uploadID, uploaded, err := s.findUpload(svc, aws.StringValue(input.Key), info.ModTime())
+	if err != nil {
+		return err
+	}
+	if uploadID == "" {
+		out, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket:                    input.Bucket,
+			Key:                       input.Key,
+			ServerSideEncryption:      input.ServerSideEncryption,
+			SSEKMSKeyId:               input.SSEKMSKeyId,
+			SSECustomerAlgorithm:      input.SSECustomerAlgorithm,
+			SSECustomerKey:            input.SSECustomerKey,
+			StorageClass:              input.StorageClass,
+			ObjectLockMode:            input.ObjectLockMode,
+			ObjectLockRetainUntilDate: input.ObjectLockRetainUntilDate,
+			ObjectLockLegalHoldStatus: input.ObjectLockLegalHoldStatus,
+			Tagging:                   input.Tagging,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create multipart upload: %w", err)
+		}
+		uploadID = aws.StringValue(out.UploadId)
+	}
+
+	parts := storage.Parts(info.Size(), s3manager.MaxUploadParts)
+	completed := make([]*s3.CompletedPart, len(parts))
+	err = storage.UploadParts(parts, func(part storage.Part) error {
+		if p, ok := uploaded[int64(part.Number)]; ok && aws.Int64Value(p.Size) == part.Size {
+			completed[part.Number-1] = &s3.CompletedPart{PartNumber: p.PartNumber, ETag: p.ETag}
+			return nil
+		}
+		// Content-MD5 is required with object lock and checks the integrity of the part
+		hash := md5.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(file, part.Offset, part.Size)); err != nil {
+			return err
+		}
+		out, err := svc.UploadPart(&s3.UploadPartInput{
+			Bucket:               input.Bucket,
+			Key:                  input.Key,
+			UploadId:             aws.String(uploadID),
+			PartNumber:           aws.Int64(int64(part.Number)),
+			Body:                 io.NewSectionReader(file, part.Offset, part.Size),
+			ContentLength:        aws.Int64(part.Size),
+			ContentMD5:           aws.String(base64.StdEncoding.EncodeToString(hash.Sum(nil))),
+			SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+			SSECustomerKey:       input.SSECustomerKey,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %w", part.Number, err)
+		}
+		completed[part.Number-1] = &s3.CompletedPart{PartNumber: aws.Int64(int64(part.Number)), ETag: out.ETag}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// findUpload returns the last multipart upload of the key initiated after the file was created and its
+// uploaded parts, older uploads are aborted
+func (s s3Storage) findUpload(svc *s3.S3, key string, created time.Time) (string, map[int64]*s3.Part, error) {
+	var uploads []*s3.MultipartUpload
+	// Some S3 alternatives like Minio remove the leading slash of the object keys
+	for _, prefix := range []string{key, strings.TrimPrefix(key, "/")} {
+		err := svc.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+			Bucket: aws.String(s.bucket),
+			Prefix: aws.String(prefix),
+		}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+			for _, upload := range page.Uploads {
+				if aws.StringValue(upload.Key) == prefix {
+					uploads = append(uploads, upload)
+				}
+			}
+			return !lastPage
+		})
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+		}
+		if len(uploads) > 0 || !strings.HasPrefix(key, "/") {
+			break
+		}
+	}
+	var resumed *s3.MultipartUpload
+	for _, upload := range uploads {
+		initiated := aws.TimeValue(upload.Initiated)
+		if initiated.After(created.Add(-time.Minute)) && (resumed == nil || initiated.After(aws.TimeValue(resumed.Initiated))) {
+			resumed = upload
+		}
+	}
+	for _, upload := range uploads {
+		if upload != resumed {
+			_, _ = svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(s.bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+		}
+	}
+	if resumed == nil {
+		return "", nil, nil
+	}
+	parts := make(map[int64]*s3.Part)
+	err := svc.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      resumed.Key,
+		UploadId: resumed.UploadId,
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			parts[aws.Int64Value(part.PartNumber)] = part
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list uploaded parts: %w", err)
+	}
+	return aws.StringValue(resumed.UploadId), parts, nil
+}
+
+// SetTags sets the tags of the next uploaded objects, they are merged with the configured tags
+func (s *s3Storage) SetTags(tags map[string]string) {
+	s.tags = tags
+}
+
+// applyOptions applies encryption, storage class, object lock and tags to an upload
+func (s s3Storage) applyOptions(input *s3manager.UploadInput) {
+	switch s.options.ServerSideEncryption {
+	case "":
+	case SSECustomer:
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(s.options.SSECustomerKey)
+	default:
+		input.ServerSideEncryption = aws.String(s.options.ServerSideEncryption)
+		if s.options.SSEKMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(s.options.SSEKMSKeyID)
+		}
+	}
+	if s.options.StorageClass != "" {
+		input.StorageClass = aws.String(s.options.StorageClass)
+	}
+	if s.options.ObjectLockMode != "" && s.options.ObjectLockRetention > 0 {
+		input.ObjectLockMode = aws.String(s.options.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(s.options.ObjectLockRetention))
+	}
+	if s.options.LegalHold {
+		input.ObjectLockLegalHoldStatus = aws.String(s3.ObjectLockLegalHoldStatusOn)
+	}
+	tags := url.Values{}
+	for key, value := range s.options.Tags {
+		tags.Set(key, value)
+	}
+	for key, value := range s.tags {
+		tags.Set(key, value)
+	}
+	if len(tags) > 0 {
+		input.Tagging = aws.String(tags.Encode())
+	}
+}
+
+// CopyFrom copies a file from S3 to local storage
+func (s s3Storage) CopyFrom(fileName string) error {
+	file, err := os.Create(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(filepath.Join(s.RemotePath, fileName)),
+	}
+	if s.options.ServerSideEncryption == SSECustomer {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(s.options.SSECustomerKey)
+	}
+	downloader := s3manager.NewDownloader(s.client)
+	_, err = downloader.Download(file, input)
+	return err
+}
+
+// Open opens an object of the remote path for reading, the download is limited by the transport
+func (s s3Storage) Open(fileName string) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(filepath.Join(s.RemotePath, fileName)),
+	}
+	if s.options.ServerSideEncryption == SSECustomer {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(s.options.SSECustomerKey)
+	}
+	out, err := s3.New(s.client).GetObject(input)
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Put streams r to an object of the remote path with the upload options
+func (s s3Storage) Put(fileName string, r io.Reader, _ int64) error {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(filepath.Join(s.RemotePath, fileName)),
+		Body:   r,
+	}
+	s.applyOptions(input)
+	uploader := s3manager.NewUploader(s.client)
+	_, err := uploader.Upload(input)
+	return err
+}
+
+// List returns the objects stored in the remote path
+func (s s3Storage) List() ([]storage.File, error) {
+	prefix := storage.Prefix(s.RemotePath)
+	files, err := s.listObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	// Some S3 alternatives like Minio remove the leading slash of the object keys
+	if len(files) == 0 && strings.HasPrefix(prefix, "/") {
+		return s.listObjects(strings.TrimPrefix(prefix, "/"))
+	}
+	return files, nil
+}
+
+func (s s3Storage) listObjects(prefix string) ([]storage.File, error) {
+	svc := s3.New(s.client)
+	var files []storage.File
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(aws.StringValue(object.Key), prefix)
+			if name == "" {
+				continue
+			}
+			files = append(files, storage.File{
+				Name:    name,
+				Size:    aws.Int64Value(object.Size),
+				ModTime: aws.TimeValue(object.LastModified),
+			})
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	return files, nil
+}
+
+// Delete deletes an object from the remote path
+func (s s3Storage) Delete(fileName string) error {
+	svc := s3.New(s.client)
+	key := filepath.Join(s.RemotePath, fileName)
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if aErr, ok := err.(awserr.Error); ok && aErr.Code() == "AccessDenied" && s.retained(svc, key) {
+		return fmt.Errorf("%w: %s is locked by the object lock of the bucket", storage.ErrRetained, fileName)
+	}
+	return err
+}
+
+// retained reports whether an object is under a retention period or a legal hold
+func (s s3Storage) retained(svc *s3.S3, key string) bool {
+	retention, err := svc.GetObjectRetention(&s3.GetObjectRetentionInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err == nil && retention.Retention != nil && retention.Retention.RetainUntilDate != nil && retention.Retention.RetainUntilDate.After(time.Now()) {
+		return true
+	}
+	legalHold, err := svc.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	return err == nil && legalHold.LegalHold != nil && aws.StringValue(legalHold.LegalHold.Status) == s3.ObjectLockLegalHoldStatusOn
+}
+
+// Prune deletes old backup created more than specified days
+func (s s3Storage) Prune(retentionDays int) error {
+	return storage.Prune(s, retentionDays)
+}
+
+// Name returns the storage name
+func (s s3Storage) Name() string {
+	return "s3"
+}
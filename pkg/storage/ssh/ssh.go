@@ -0,0 +1,417 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bramvdbogaerde/go-scp"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type sshStorage struct {
+	*storage.Backend
+	config Config
+}
+
+// Config holds the SSH connection details
+type Config struct {
+	Host         string
+	User         string
+	Password     string
+	Port         int
+	IdentifyFile string
+	// Passphrase decrypts an encrypted identity file
+	Passphrase string
+	// AgentSocket is the SSH agent socket used for authentication
+	AgentSocket string
+	// KnownHostsFiles are used to verify the host keys of the server and the jump hosts
+	KnownHostsFiles []string
+	// HostKeyFingerprints pins the server host key, SHA256 or MD5 fingerprints as printed by ssh-keygen -l
+	HostKeyFingerprints []string
+	// StrictHostKeyChecking rejects the connection when no host key can be verified
+	StrictHostKeyChecking bool
+	// JumpHosts are the hosts to connect through, in order, ProxyJump format: [user@]host[:port]
+	JumpHosts  []string
+	LocalPath  string
+	RemotePath string
+	// Warnf logs the warnings of the connection, e.g: a host key accepted without verification
+	Warnf func(format string, args ...interface{})
+}
+
+// session holds the connections to the server through the jump hosts, the files are copied with
+// SCP and the SFTP client, only used to list and delete files, is opened on demand
+type session struct {
+	*sftp.Client
+	conns []*ssh.Client
+}
+
+// NewStorage creates new Storage
+func NewStorage(conf Config) (storage.Storage, error) {
+	if _, err := os.Stat(conf.IdentifyFile); os.IsNotExist(err) && conf.Password == "" && conf.AgentSocket == "" {
+		return nil, errors.New("ssh password, identity file or agent required")
+	}
+	if conf.StrictHostKeyChecking && len(conf.KnownHostsFiles) == 0 && len(conf.HostKeyFingerprints) == 0 {
+		return nil, errors.New("strict host key checking requires a known hosts file or a host key fingerprint")
+	}
+	return &sshStorage{
+		config: conf,
+		Backend: &storage.Backend{
+			RemotePath: conf.RemotePath,
+			LocalPath:  conf.LocalPath,
+		},
+	}, nil
+}
+
+// authMethods returns the authentication methods, tried in order: identity file, agent and password
+func (s sshStorage) authMethods() ([]ssh.AuthMethod, func(), error) {
+	var methods []ssh.AuthMethod
+	closeAgent := func() {}
+	if _, err := os.Stat(s.config.IdentifyFile); err == nil {
+		key, err := os.ReadFile(s.config.IdentifyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+		var signer ssh.Signer
+		if s.config.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(s.config.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		var missingErr *ssh.PassphraseMissingError
+		if errors.As(err, &missingErr) {
+			return nil, nil, errors.New("identity file is encrypted, passphrase required")
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse identity file: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if s.config.AgentSocket != "" {
+		// The agent is optional, the other methods are tried when it's not reachable
+		if conn, err := net.Dial("unix", s.config.AgentSocket); err != nil {
+			s.warnf("SSH agent authentication skipped, failed to connect to %s: %v", s.config.AgentSocket, err)
+		} else {
+			closeAgent = func() { _ = conn.Close() }
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	if s.config.Password != "" {
+		methods = append(methods, ssh.Password(s.config.Password))
+	}
+	return methods, closeAgent, nil
+}
+
+// hostKeyCallback verifies the host keys with the known hosts files, the pinned fingerprints
+// only apply to the server. Host keys are accepted with a warning when none of them is set.
+func (s sshStorage) hostKeyCallback(jumpHost bool) (ssh.HostKeyCallback, error) {
+	var knownHostsCallback ssh.HostKeyCallback
+	if len(s.config.KnownHostsFiles) > 0 {
+		callback, err := knownhosts.New(s.config.KnownHostsFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read known hosts: %w", err)
+		}
+		knownHostsCallback = callback
+	}
+	if !jumpHost && len(s.config.HostKeyFingerprints) > 0 {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			for _, fingerprint := range s.config.HostKeyFingerprints {
+				if fingerprint == ssh.FingerprintSHA256(key) || fingerprint == "MD5:"+ssh.FingerprintLegacyMD5(key) {
+					return nil
+				}
+			}
+			return fmt.Errorf("host key fingerprint %s of %s does not match", ssh.FingerprintSHA256(key), hostname)
+		}, nil
+	}
+	if knownHostsCallback != nil {
+		return knownHostsCallback, nil
+	}
+	if s.config.StrictHostKeyChecking {
+		return nil, errors.New("no known hosts file to verify the jump host keys")
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		s.warnf("SSH host key %s of %s is NOT verified, the connection is exposed to man-in-the-middle attacks: set SSH_KNOWN_HOSTS or SSH_HOST_KEY_FINGERPRINT", ssh.FingerprintSHA256(key), hostname)
+		return nil
+	}, nil
+}
+
+func (s sshStorage) warnf(format string, args ...interface{}) {
+	if s.config.Warnf != nil {
+		s.config.Warnf(format, args...)
+	}
+}
+
+// clientConfig returns the SSH client configuration of a host
+func (s sshStorage) clientConfig(user string, auth []ssh.AuthMethod, jumpHost bool) (*ssh.ClientConfig, error) {
+	callback, err := s.hostKeyCallback(jumpHost)
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: callback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+// parseJumpHost parses a [user@]host[:port] jump host, the user defaults to the SSH user
+func (s sshStorage) parseJumpHost(jumpHost string) (user, addr string) {
+	user = s.config.User
+	if i := strings.LastIndex(jumpHost, "@"); i >= 0 {
+		user, jumpHost = jumpHost[:i], jumpHost[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(jumpHost); err != nil {
+		jumpHost = net.JoinHostPort(strings.Trim(jumpHost, "[]"), "22")
+	}
+	return user, jumpHost
+}
+
+// connect connects to the server through the jump hosts, the caller must close the returned session
+func (s sshStorage) connect() (*session, error) {
+	auth, closeAgent, err := s.authMethods()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	sess := &session{}
+	hops := append(append([]string{}, s.config.JumpHosts...), s.config.User+"@"+net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port)))
+	for i, hop := range hops {
+		user, addr := s.parseJumpHost(hop)
+		config, err := s.clientConfig(user, auth, i < len(hops)-1)
+		if err != nil {
+			sess.close()
+			return nil, err
+		}
+		conn, err := s.dial(sess.conns, addr, config)
+		if err != nil {
+			sess.close()
+			return nil, fmt.Errorf("couldn't establish a connection to %s: %w", addr, err)
+		}
+		sess.conns = append(sess.conns, conn)
+	}
+	return sess, nil
+}
+
+// connectSFTP connects to the server and opens an SFTP session, the servers which only serve SCP
+// return storage.ErrNotSupported
+func (s sshStorage) connectSFTP() (*session, error) {
+	sess, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(sess.server())
+	if err != nil {
+		sess.close()
+		return nil, fmt.Errorf("%w: listing and deleting files require the SFTP subsystem of the server: %v", storage.ErrNotSupported, err)
+	}
+	sess.Client = client
+	return sess, nil
+}
+
+// server returns the connection to the server
+func (sess *session) server() *ssh.Client {
+	return sess.conns[len(sess.conns)-1]
+}
+
+// dial connects to addr directly or through the last established connection
+func (s sshStorage) dial(conns []*ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if len(conns) == 0 {
+		return ssh.Dial("tcp", addr, config)
+	}
+	netConn, err := conns[len(conns)-1].Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(conn, chans, reqs), nil
+}
+
+// close closes the SFTP session and the connections, the last hop first
+func (sess *session) close() {
+	if sess.Client != nil {
+		_ = sess.Client.Close()
+	}
+	for i := len(sess.conns) - 1; i >= 0; i-- {
+		_ = sess.conns[i].Close()
+	}
+}
+
+// Copy copies file to the remote server
+func (s sshStorage) Copy(fileName string) error {
+	file, err := os.Open(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", fileName, err)
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return s.Put(fileName, file, info.Size())
+}
+
+// Put streams r to a file of the remote path, SCP requires the size of the content
+func (s sshStorage) Put(fileName string, r io.Reader, size int64) error {
+	if size < 0 {
+		return errors.New("the file size is required to copy a stream with SCP")
+	}
+	sess, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+
+	client, err := scp.NewClientBySSH(sess.server())
+	if err != nil {
+		return err
+	}
+	err = client.Copy(context.Background(), storage.UploadReader(r), path.Join(s.RemotePath, fileName), "0644", size)
+	if err != nil {
+		return fmt.Errorf("failed to copy file to remote server: %w", err)
+	}
+	return nil
+}
+
+// CopyFrom copies a file from the remote server to local storage
+func (s sshStorage) CopyFrom(fileName string) error {
+	sess, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+
+	client, err := scp.NewClientBySSH(sess.server())
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return fmt.Errorf("couldn't open the output file: %w", err)
+	}
+	err = client.CopyFromRemotePassThru(context.Background(), file, path.Join(s.RemotePath, fileName), func(r io.Reader, total int64) io.Reader {
+		return storage.DownloadReader(r)
+	})
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to copy file from remote server: %w", err)
+	}
+	return file.Close()
+}
+
+// Open streams a file of the remote server through a pipe, the connection is closed with the reader
+func (s sshStorage) Open(fileName string) (io.ReadCloser, error) {
+	sess, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	client, err := scp.NewClientBySSH(sess.server())
+	if err != nil {
+		sess.close()
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := client.CopyFromRemotePassThru(context.Background(), writer, path.Join(s.RemotePath, fileName), func(r io.Reader, total int64) io.Reader {
+			return storage.DownloadReader(r)
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to copy file from remote server: %w", err)
+		}
+		_ = writer.CloseWithError(err)
+	}()
+	return storage.NewReadCloser(reader, func() error {
+		_ = reader.Close()
+		sess.close()
+		<-done
+		return nil
+	}), nil
+}
+
+// List returns the files stored in the remote path
+func (s sshStorage) List() ([]storage.File, error) {
+	client, err := s.connectSFTP()
+	if err != nil {
+		return nil, err
+	}
+	defer client.close()
+
+	entries, err := client.ReadDir(s.RemotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote directory: %w", err)
+	}
+	files := make([]storage.File, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() {
+			files = append(files, storage.File{Name: entry.Name(), Size: entry.Size(), ModTime: entry.ModTime()})
+		}
+	}
+	return files, nil
+}
+
+// Delete deletes a file from the remote path
+func (s sshStorage) Delete(fileName string) error {
+	client, err := s.connectSFTP()
+	if err != nil {
+		return err
+	}
+	defer client.close()
+	return client.Remove(path.Join(s.RemotePath, fileName))
+}
+
+// Prune deletes old backup created more than specified days
+func (s sshStorage) Prune(retentionDays int) error {
+	return storage.Prune(s, retentionDays)
+}
+
+// Name returns the storage name
+func (s sshStorage) Name() string {
+	return "ssh"
+}
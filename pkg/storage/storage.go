@@ -0,0 +1,152 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	gostorage "github.com/jkaninda/go-storage/pkg"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage is implemented by every backup storage backend, it extends the go-storage
+// interface with the listing and deletion of files
+type Storage interface {
+	gostorage.Storage
+	// List returns the files stored in the remote path
+	List() ([]File, error)
+	// Delete deletes a file from the remote path
+	Delete(fileName string) error
+	// Open opens a file of the remote path for streaming reads
+	Open(fileName string) (io.ReadCloser, error)
+	// Put streams r to a file of the remote path, size is the length of the content
+	Put(fileName string, r io.Reader, size int64) error
+}
+
+// ErrNotSupported is returned by the operations a storage can't perform
+var ErrNotSupported = errors.New("operation not supported by the storage")
+
+// ErrRetained is returned when a file can't be deleted yet, e.g. an S3 object under object lock
+var ErrRetained = errors.New("file retained by the storage")
+
+// Tagger is implemented by storages supporting object tags
+type Tagger interface {
+	// SetTags sets the tags of the next uploaded files
+	SetTags(tags map[string]string)
+}
+
+// Backend holds the paths shared by all storage backends
+type Backend = gostorage.Backend
+
+// File describes a file stored in a backend
+type File struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// NewReadCloser returns a ReadCloser reading from r and calling closer once closed
+func NewReadCloser(r io.Reader, closer func() error) io.ReadCloser {
+	return &readCloser{Reader: r, closer: closer}
+}
+
+type readCloser struct {
+	io.Reader
+	closer func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.closer()
+}
+
+// Prune deletes the files of the storage created more than retentionDays ago, the volumes of
+// a split backup are deleted together once the last one is older than retentionDays. A failed
+// deletion doesn't stop the pruning, the error wraps ErrRetained when only retained files are left
+func Prune(s Storage, retentionDays int) error {
+	files, err := s.List()
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+	backupRetentionDays := time.Now().AddDate(0, 0, -retentionDays)
+	lastModTime := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		name, _, _ := SplitVolumeName(file.Name)
+		if file.ModTime.After(lastModTime[name]) {
+			lastModTime[name] = file.ModTime
+		}
+	}
+	var errs []error
+	var retained []string
+	for _, file := range files {
+		name, _, _ := SplitVolumeName(file.Name)
+		if !lastModTime[name].Before(backupRetentionDays) {
+			continue
+		}
+		if err := s.Delete(file.Name); errors.Is(err, ErrRetained) {
+			retained = append(retained, file.Name)
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete %s: %w", file.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if len(retained) > 0 {
+		return fmt.Errorf("%w: %s", ErrRetained, strings.Join(retained, ", "))
+	}
+	return nil
+}
+
+// VolumeName returns the name of a volume of a split backup, e.g: backup.sql.gz.part0001
+func VolumeName(fileName string, number int) string {
+	return fmt.Sprintf("%s.part%04d", fileName, number)
+}
+
+// SplitVolumeName returns the backup name and the number of a volume, ok is false and
+// the name is returned unchanged when it's not a volume
+func SplitVolumeName(name string) (fileName string, number int, ok bool) {
+	i := strings.LastIndex(name, ".part")
+	if i < 0 {
+		return name, 0, false
+	}
+	suffix := name[i+len(".part"):]
+	if len(suffix) < 4 || strings.Trim(suffix, "0123456789") != "" {
+		return name, 0, false
+	}
+	number, err := strconv.Atoi(suffix)
+	if err != nil || number < 1 {
+		return name, 0, false
+	}
+	return name[:i], number, true
+}
+
+// Prefix returns the object prefix of a remote path for object storages, e.g: /backup/ or an empty string
+func Prefix(remotePath string) string {
+	return strings.TrimSuffix(filepath.Join(remotePath, "_"), "_")
+}
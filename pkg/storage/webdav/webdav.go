@@ -0,0 +1,374 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package webdav
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNotFound = errors.New("404 Not Found")
+
+type webdavStorage struct {
+	*storage.Backend
+	config  Config
+	baseURL *url.URL
+	client  *http.Client
+}
+
+// Config holds the WebDAV connection details
+type Config struct {
+	// URL of the WebDAV root, e.g: https://cloud.example.com/remote.php/dav/files/user
+	URL      string
+	User     string
+	Password string
+	// Token is used for bearer authentication instead of the user and password
+	Token  string
+	CACert string
+	// ChunkSize enables the Nextcloud chunked upload for files larger than ChunkSize, 0 disables it
+	ChunkSize  int64
+	LocalPath  string
+	RemotePath string
+}
+
+// NewStorage creates new Storage
+func NewStorage(conf Config) (storage.Storage, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(conf.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebDAV URL: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = storage.DialContext
+	if conf.CACert != "" {
+		caCert, err := os.ReadFile(conf.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificate found in %s", conf.CACert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &webdavStorage{
+		config:  conf,
+		baseURL: baseURL,
+		client:  &http.Client{Transport: transport},
+		Backend: &storage.Backend{
+			RemotePath: conf.RemotePath,
+			LocalPath:  conf.LocalPath,
+		},
+	}, nil
+}
+
+// Copy copies file to the WebDAV server
+func (s webdavStorage) Copy(fileName string) error {
+	file, err := os.Open(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", fileName, err)
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return s.Put(fileName, file, fileInfo.Size())
+}
+
+// Put streams r to a file of the remote path, files larger than the chunk size are uploaded in chunks
+func (s webdavStorage) Put(fileName string, r io.Reader, size int64) error {
+	if err := s.mkdirAll(s.RemotePath); err != nil {
+		return err
+	}
+	destination := s.fileURL(s.RemotePath, fileName)
+	if s.config.ChunkSize > 0 && size > s.config.ChunkSize {
+		return s.chunkedUpload(r, size, destination)
+	}
+	_, err := s.do(http.MethodPut, destination, r, size, nil, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("failed to upload file %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// CopyFrom copies a file from the WebDAV server to local storage
+func (s webdavStorage) CopyFrom(fileName string) error {
+	reader, err := s.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer func(reader io.ReadCloser) {
+		err := reader.Close()
+		if err != nil {
+			return
+		}
+	}(reader)
+
+	file, err := os.Create(filepath.Join(s.LocalPath, fileName))
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+
+	if _, err = io.Copy(file, reader); err != nil {
+		return fmt.Errorf("failed to download file %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// Open opens a file of the remote path for reading
+func (s webdavStorage) Open(fileName string) (io.ReadCloser, error) {
+	req, err := s.newRequest(http.MethodGet, s.fileURL(s.RemotePath, fileName), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file %s: %w", fileName, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("failed to download file %s: %s", fileName, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+type multiStatus struct {
+	Responses []struct {
+		Href string `xml:"href"`
+		Prop struct {
+			ContentLength string `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+			ResourceType  struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+		} `xml:"propstat>prop"`
+	} `xml:"response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/><d:getlastmodified/><d:resourcetype/></d:prop></d:propfind>`
+
+// List returns the files stored in the remote path
+func (s webdavStorage) List() ([]storage.File, error) {
+	header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml; charset=utf-8"}}
+	body, err := s.do("PROPFIND", s.fileURL(s.RemotePath, "")+"/", strings.NewReader(propfindBody), int64(len(propfindBody)), header, http.StatusMultiStatus)
+	if errors.Is(err, errNotFound) {
+		// The remote path does not exist yet
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	var status multiStatus
+	if err = xml.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse the file list: %w", err)
+	}
+	var files []storage.File
+	for _, response := range status.Responses {
+		if response.Prop.ResourceType.Collection != nil {
+			continue
+		}
+		href, err := url.PathUnescape(response.Href)
+		if err != nil {
+			href = response.Href
+		}
+		file := storage.File{Name: path.Base(href)}
+		file.Size, _ = strconv.ParseInt(response.Prop.ContentLength, 10, 64)
+		file.ModTime, _ = http.ParseTime(response.Prop.LastModified)
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Delete deletes a file from the remote path
+func (s webdavStorage) Delete(fileName string) error {
+	_, err := s.do(http.MethodDelete, s.fileURL(s.RemotePath, fileName), nil, 0, nil, http.StatusNoContent, http.StatusOK)
+	return err
+}
+
+// Prune deletes old backup created more than specified days
+func (s webdavStorage) Prune(retentionDays int) error {
+	return storage.Prune(s, retentionDays)
+}
+
+// Name returns the storage name
+func (s webdavStorage) Name() string {
+	return "webdav"
+}
+
+// chunkedUpload uploads a file in chunks using the Nextcloud chunking API:
+// the chunks are uploaded to an upload directory then assembled by moving it to the destination
+func (s webdavStorage) chunkedUpload(file io.Reader, size int64, destination string) error {
+	uploadsURL, err := s.uploadsURL()
+	if err != nil {
+		return err
+	}
+	uploadDir := uploadsURL.JoinPath(fmt.Sprintf("mysql-bkup-%d", time.Now().UnixNano())).String()
+	header := http.Header{"Destination": {destination}}
+	if _, err = s.do("MKCOL", uploadDir, nil, 0, header, http.StatusCreated); err != nil {
+		return fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	buf := make([]byte, s.config.ChunkSize)
+	for chunk := 1; ; chunk++ {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			chunkURL := fmt.Sprintf("%s/%05d", uploadDir, chunk)
+			if _, err := s.do(http.MethodPut, chunkURL, bytes.NewReader(buf[:n]), int64(n), header, http.StatusCreated, http.StatusNoContent); err != nil {
+				s.abortUpload(uploadDir)
+				return fmt.Errorf("failed to upload chunk %d: %w", chunk, err)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			s.abortUpload(uploadDir)
+			return err
+		}
+	}
+	header.Set("OC-Total-Length", strconv.FormatInt(size, 10))
+	if _, err = s.do("MOVE", uploadDir+"/.file", nil, 0, header, http.StatusCreated, http.StatusNoContent); err != nil {
+		s.abortUpload(uploadDir)
+		return fmt.Errorf("failed to assemble chunks: %w", err)
+	}
+	return nil
+}
+
+func (s webdavStorage) abortUpload(uploadDir string) {
+	_, _ = s.do(http.MethodDelete, uploadDir, nil, 0, nil, http.StatusNoContent)
+}
+
+// uploadsURL returns the Nextcloud uploads URL of the user, derived from the files URL
+func (s webdavStorage) uploadsURL() (*url.URL, error) {
+	const filesPath = "/remote.php/dav/files/"
+	i := strings.Index(s.baseURL.Path, filesPath)
+	if i < 0 {
+		return nil, fmt.Errorf("chunked upload requires a Nextcloud files URL (.../remote.php/dav/files/<user>)")
+	}
+	user, _, _ := strings.Cut(s.baseURL.Path[i+len(filesPath):], "/")
+	uploadsURL := *s.baseURL
+	uploadsURL.Path = s.baseURL.Path[:i] + "/remote.php/dav/uploads/" + user
+	uploadsURL.RawPath = ""
+	return &uploadsURL, nil
+}
+
+// mkdirAll creates the remote directory and its parents
+func (s webdavStorage) mkdirAll(dir string) error {
+	current := ""
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current = path.Join(current, part)
+		// 405 Method Not Allowed is returned when the directory already exists
+		_, err := s.do("MKCOL", s.fileURL(current, "")+"/", nil, 0, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", current, err)
+		}
+	}
+	return nil
+}
+
+func (s webdavStorage) fileURL(dir, fileName string) string {
+	return s.baseURL.JoinPath(dir, fileName).String()
+}
+
+func (s webdavStorage) newRequest(method, target string, body io.Reader, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if s.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.Token)
+	} else if s.config.User != "" {
+		req.SetBasicAuth(s.config.User, s.config.Password)
+	}
+	return req, nil
+}
+
+// do sends a request and returns the response body, an error is returned for unexpected status codes
+func (s webdavStorage) do(method, target string, body io.Reader, size int64, header http.Header, expected ...int) ([]byte, error) {
+	req, err := s.newRequest(method, target, body, header)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func(body io.ReadCloser) {
+		err := body.Close()
+		if err != nil {
+			return
+		}
+	}(resp.Body)
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return data, nil
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s %s: %w", method, req.URL.Path, errNotFound)
+	}
+	return nil, fmt.Errorf("%s %s: %s", method, req.URL.Path, resp.Status)
+}
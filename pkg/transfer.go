@@ -0,0 +1,279 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"github.com/spf13/cobra"
+	"io"
+	"strings"
+	"time"
+)
+
+// StartTransfer copies backups and their manifests from a storage to another
+func StartTransfer(cmd *cobra.Command) {
+	intro()
+	conf := initTransferConfig(cmd)
+	if strings.EqualFold(conf.from, conf.to) && conf.fromPath == conf.toPath {
+		utils.Fatal("Source and destination storages must be different")
+	}
+	src, err := openStorage(conf.from, conf.fromPath)
+	if err != nil {
+		utils.Fatal("Error creating %s storage: %s", conf.from, err)
+	}
+	dst, err := openStorage(conf.to, conf.toPath)
+	if err != nil {
+		utils.Fatal("Error creating %s storage: %s", conf.to, err)
+	}
+	utils.Info("Transferring backups from %s storage to %s storage...", src.Name(), dst.Name())
+
+	srcFiles, err := src.List()
+	if err != nil {
+		utils.Fatal("Error listing backups from %s storage: %s", src.Name(), err)
+	}
+	dstFiles, err := dst.List()
+	if err != nil {
+		utils.Fatal("Error listing backups from %s storage: %s", dst.Name(), err)
+	}
+	srcNames := fileNames(srcFiles)
+	dstNames := fileNames(dstFiles)
+
+	transferred, skipped, failed := 0, 0, 0
+	for _, file := range filterBackups(srcFiles, conf.dbName, conf.since) {
+		// The manifest of a split backup is uploaded after its volumes
+		if dstNames[file.Name] || (len(file.volumes) > 0 && dstNames[manifestName(file.Name)]) {
+			utils.Info("%s already exists on %s storage, skipping", file.Name, dst.Name())
+			skipped++
+			continue
+		}
+		hasManifest := srcNames[manifestName(file.Name)]
+		hasSignature := srcNames[signatureName(file.Name)]
+		if err = transferBackup(src, dst, file, hasManifest, hasSignature); err != nil {
+			utils.Error("Error transferring %s: %v", file.Name, err)
+			failed++
+			continue
+		}
+		transferred++
+		if conf.deleteSource {
+			deleteBackupFile(src, file, hasManifest, hasSignature)
+		}
+	}
+	utils.Info("Transfer completed: %d transferred, %d skipped, %d failed", transferred, skipped, failed)
+	if failed > 0 {
+		utils.Fatal("%d backups could not be transferred from %s storage to %s storage", failed, src.Name(), dst.Name())
+	}
+}
+
+// transferBackup streams a backup from the source storage to the destination storage, the stream is hashed
+// on the fly and the copy is read back from the destination to verify its size and checksum.
+// The volumes of a split backup are transferred and verified one by one
+func transferBackup(src, dst storage.Storage, file backupFile, hasManifest, hasSignature bool) error {
+	utils.Info("Transferring %s (%s)...", file.Name, utils.ConvertBytes(uint64(file.Size)))
+	if len(file.volumes) > 0 && !hasManifest {
+		return fmt.Errorf("the manifest of the split backup is missing")
+	}
+	parts := []manifestPart{{File: file.Name, Size: file.Size}}
+	var manifestData []byte
+	if hasManifest {
+		data, err := readStorageFile(src, manifestName(file.Name))
+		if err != nil {
+			return fmt.Errorf("manifest download failed: %w", err)
+		}
+		manifest, err := parseManifest(data, manifestName(file.Name))
+		if err != nil {
+			return err
+		}
+		manifestData = data
+		parts[0].Checksum = manifest.Checksum
+		if len(file.volumes) > 0 {
+			parts = manifest.Parts
+		}
+	}
+
+	for i := range parts {
+		part := &parts[i]
+		err := storage.Retry(dst, "transfer of "+part.File, func() error {
+			return streamFile(src, dst, part)
+		})
+		if err != nil {
+			return err
+		}
+		if err = verifyCopy(dst, *part); err != nil {
+			return err
+		}
+	}
+	if hasManifest {
+		if err := putStorageFile(dst, manifestName(file.Name), manifestData); err != nil {
+			return fmt.Errorf("manifest upload failed: %w", err)
+		}
+	}
+	if hasSignature {
+		data, err := readStorageFile(src, signatureName(file.Name))
+		if err != nil {
+			return fmt.Errorf("signature download failed: %w", err)
+		}
+		if err = putStorageFile(dst, signatureName(file.Name), data); err != nil {
+			return fmt.Errorf("signature upload failed: %w", err)
+		}
+	}
+	verified := fmt.Sprintf("checksum %s verified", parts[0].Checksum)
+	if len(file.volumes) > 0 {
+		verified = fmt.Sprintf("%d parts verified", len(parts))
+	}
+	utils.Info("Transferring %s...done, %s", file.Name, verified)
+	return nil
+}
+
+// streamFile pipes a file from the source storage to the destination storage while hashing it,
+// the checksum of the part is set when it's unknown. A copy not matching the checksum is deleted
+func streamFile(src, dst storage.Storage, part *manifestPart) error {
+	reader, err := src.Open(part.File)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	defer func(reader io.ReadCloser) {
+		err := reader.Close()
+		if err != nil {
+			return
+		}
+	}(reader)
+
+	hash := sha256.New()
+	if err = dst.Put(part.File, io.TeeReader(reader, hash), part.Size); err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	checksum := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	if part.Checksum != "" && part.Checksum != checksum {
+		_ = dst.Delete(part.File)
+		return fmt.Errorf("checksum mismatch of %s on %s storage: expected %s, got %s", part.File, src.Name(), part.Checksum, checksum)
+	}
+	part.Checksum = checksum
+	return nil
+}
+
+// verifyCopy reads a file back from a storage and verifies its size and checksum
+func verifyCopy(st storage.Storage, part manifestPart) error {
+	reader, err := st.Open(part.File)
+	if err != nil {
+		return fmt.Errorf("verification download failed: %w", err)
+	}
+	defer func(reader io.ReadCloser) {
+		err := reader.Close()
+		if err != nil {
+			return
+		}
+	}(reader)
+
+	checksum, size, err := readerChecksum(reader)
+	if err != nil {
+		return fmt.Errorf("verification download failed: %w", err)
+	}
+	if part.Size > 0 && size != part.Size {
+		return fmt.Errorf("size mismatch of %s on %s storage: expected %d bytes, got %d", part.File, st.Name(), part.Size, size)
+	}
+	if checksum != part.Checksum {
+		return fmt.Errorf("checksum mismatch of %s on %s storage: expected %s, got %s", part.File, st.Name(), part.Checksum, checksum)
+	}
+	return nil
+}
+
+// readStorageFile reads a small file of a storage, e.g: a manifest or a signature
+func readStorageFile(st storage.Storage, fileName string) ([]byte, error) {
+	reader, err := st.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func(reader io.ReadCloser) {
+		err := reader.Close()
+		if err != nil {
+			return
+		}
+	}(reader)
+	return io.ReadAll(reader)
+}
+
+// putStorageFile writes data to a file of a storage, retrying on failure
+func putStorageFile(st storage.Storage, fileName string, data []byte) error {
+	return storage.Retry(st, "upload of "+fileName, func() error {
+		return st.Put(fileName, bytes.NewReader(data), int64(len(data)))
+	})
+}
+
+// deleteBackupFile deletes a backup file or the volumes of a split backup, its manifest and signature from a storage
+func deleteBackupFile(st storage.Storage, file backupFile, hasManifest, hasSignature bool) {
+	utils.Info("Deleting %s from %s storage...", file.Name, st.Name())
+	names := file.volumes
+	if len(names) == 0 {
+		names = []string{file.Name}
+	}
+	for _, name := range names {
+		if err := st.Delete(name); err != nil {
+			utils.Error("Error deleting %s: %v", name, err)
+			return
+		}
+	}
+	if hasManifest {
+		if err := st.Delete(manifestName(file.Name)); err != nil {
+			utils.Error("Error deleting %s: %v", manifestName(file.Name), err)
+		}
+	}
+	if hasSignature {
+		if err := st.Delete(signatureName(file.Name)); err != nil {
+			utils.Error("Error deleting %s: %v", signatureName(file.Name), err)
+		}
+	}
+}
+
+// filterBackups returns the backups of a database created since the given duration,
+// manifests and signatures are excluded and the volumes of a split backup are grouped
+func filterBackups(files []storage.File, dbName string, since time.Duration) []backupFile {
+	backups := make([]backupFile, 0, len(files))
+	for _, file := range groupBackups(files) {
+		if isManifest(file.Name) || isSignature(file.Name) {
+			continue
+		}
+		if dbName != "" && !strings.HasPrefix(file.Name, dbName+"_") {
+			continue
+		}
+		if since > 0 && file.ModTime.Before(time.Now().Add(-since)) {
+			continue
+		}
+		backups = append(backups, file)
+	}
+	return backups
+}
+
+func fileNames(files []storage.File) map[string]bool {
+	names := make(map[string]bool, len(files))
+	for _, file := range files {
+		names[file.Name] = true
+	}
+	return names
+}
@@ -26,27 +26,26 @@ package pkg
 
 import (
 	"path/filepath"
-	"time"
 )
 
 const tmpPath = "/tmp/backup"
 const gpgHome = "/config/gnupg"
 const gpgExtension = "gpg"
+const ageExtension = "age"
+const envelopeExtension = "enc"
 const timeFormat = "2006-01-02 at 15:04:05"
+const atomicTimeFormat = "20060102150405"
 
 var (
-	storage = "local"
-	file    = ""
+	file = ""
 
-	storagePath              = "/backup"
-	workingDir               = "/config"
-	disableCompression       = false
-	encryption               = false
-	usingKey                 = false
-	backupSize         int64 = 0
-	startTime                = time.Now()
-	backupRescueMode         = false
-	mysqlClientConfig        = filepath.Join(tmpPath, "my.cnf")
+	storagePath        = "/backup"
+	workingDir         = "/config"
+	disableCompression = false
+	encryption         = false
+	usingKey           = false
+	backupRescueMode   = false
+	mysqlClientConfig  = filepath.Join(tmpPath, "my.cnf")
 )
 
 // dbHVars Required environment variables for database
@@ -65,23 +64,34 @@ var tdbRVars = []string{
 var dbConf *dbConfig
 var targetDbConf *targetDbConfig
 
+var sshVars = []string{
+	"SSH_USER",
+	"SSH_HOST",
+	"SSH_PORT",
+	"REMOTE_PATH",
+}
 var ftpVars = []string{
-	"FTP_HOST_NAME",
+	"FTP_HOST",
 	"FTP_USER",
 	"FTP_PASSWORD",
 	"FTP_PORT",
 }
 var azureVars = []string{
 	"AZURE_STORAGE_CONTAINER_NAME",
-	"AZURE_STORAGE_ACCOUNT_NAME",
-	"AZURE_STORAGE_ACCOUNT_KEY",
+}
+var gcsVars = []string{
+	"GCS_BUCKET_NAME",
+}
+var webdavVars = []string{
+	"WEBDAV_URL",
+}
+var rcloneVars = []string{
+	"RCLONE_REMOTE",
 }
 
 // AwsVars Required environment variables for AWS S3 storage
 var awsVars = []string{
 	"AWS_S3_ENDPOINT",
 	"AWS_S3_BUCKET_NAME",
-	"AWS_ACCESS_KEY",
-	"AWS_SECRET_KEY",
 	"AWS_REGION",
 }
@@ -0,0 +1,51 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"github.com/jkaninda/mysql-bkup/utils"
+	"github.com/spf13/cobra"
+)
+
+// StartVerify downloads a backup and verifies its checksum and the signature of its manifest,
+// without restoring it
+func StartVerify(cmd *cobra.Command) {
+	intro()
+	conf := initVerifyConfig(cmd)
+	st, err := openStorage(conf.storage, conf.remotePath)
+	if err != nil {
+		utils.Fatal("Error creating %s storage: %s", conf.storage, err)
+	}
+	utils.Info("Verifying %s on %s storage...", conf.file, st.Name())
+	if err = downloadBackup(st, conf.file); err != nil {
+		utils.Fatal("Error downloading backup file: %s", err)
+	}
+	if err = verifyBackup(st, conf.file, conf.verificationKeys, conf.requireSignature); err != nil {
+		deleteTemp()
+		utils.Fatal("Error verifying backup: %s", err)
+	}
+	deleteTemp()
+	utils.Info("Verifying %s on %s storage...done", conf.file, st.Name())
+}
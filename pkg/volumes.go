@@ -0,0 +1,219 @@
+/*
+MIT License
+
+Copyright (c) 2023 Jonas Kaninda
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/jkaninda/mysql-bkup/pkg/storage"
+	"github.com/jkaninda/mysql-bkup/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// backupFile is a backup stored in a storage, the volumes of a split backup are listed as one backup
+type backupFile struct {
+	storage.File
+	// volumes are the volume names of a split backup, sorted by number
+	volumes []string
+}
+
+// groupBackups groups the volumes of the split backups, the size of a split backup is the size
+// of its volumes and its time the time of its last volume
+func groupBackups(files []storage.File) []backupFile {
+	backups := make([]backupFile, 0, len(files))
+	index := make(map[string]int, len(files))
+	for _, file := range files {
+		name, _, ok := storage.SplitVolumeName(file.Name)
+		if !ok {
+			backups = append(backups, backupFile{File: file})
+			continue
+		}
+		i, found := index[name]
+		if !found {
+			i = len(backups)
+			index[name] = i
+			backups = append(backups, backupFile{File: storage.File{Name: name}})
+		}
+		backups[i].Size += file.Size
+		if file.ModTime.After(backups[i].ModTime) {
+			backups[i].ModTime = file.ModTime
+		}
+		backups[i].volumes = append(backups[i].volumes, file.Name)
+	}
+	for _, backup := range backups {
+		sort.Slice(backup.volumes, func(i, j int) bool {
+			_, a, _ := storage.SplitVolumeName(backup.volumes[i])
+			_, b, _ := storage.SplitVolumeName(backup.volumes[j])
+			return a < b
+		})
+	}
+	return backups
+}
+
+// splitBackup splits a backup file of the directory dir into volumes of volumeSize bytes,
+// the volumes are added to its manifest and the backup file is deleted
+func splitBackup(dir, fileName string, volumeSize int64) ([]string, error) {
+	filePath := filepath.Join(dir, fileName)
+	manifestPath := filepath.Join(dir, manifestName(fileName))
+	manifest, err := readManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			utils.Error("Error closing file: %v", err)
+		}
+	}(f)
+
+	utils.Info("Splitting backup into volumes of %s...", utils.ConvertBytes(uint64(volumeSize)))
+	var volumes []string
+	for number := 1; ; number++ {
+		part, err := writeVolume(dir, io.LimitReader(f, volumeSize), storage.VolumeName(fileName, number))
+		if err != nil {
+			return nil, err
+		}
+		if part.Size == 0 {
+			if err = os.Remove(filepath.Join(dir, part.File)); err != nil {
+				return nil, err
+			}
+			break
+		}
+		manifest.Parts = append(manifest.Parts, part)
+		volumes = append(volumes, part.File)
+		if part.Size < volumeSize {
+			break
+		}
+	}
+	if err = saveManifest(manifest, manifestPath); err != nil {
+		return nil, err
+	}
+	utils.Info("Splitting backup into volumes...done, %d volumes", len(volumes))
+	return volumes, os.Remove(filePath)
+}
+
+// writeVolume writes a volume in the directory dir and returns its description
+func writeVolume(dir string, r io.Reader, volumeName string) (manifestPart, error) {
+	out, err := os.Create(filepath.Join(dir, volumeName))
+	if err != nil {
+		return manifestPart{}, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), r)
+	if err != nil {
+		_ = out.Close()
+		return manifestPart{}, err
+	}
+	return manifestPart{
+		File:     volumeName,
+		Size:     size,
+		Checksum: "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+	}, out.Close()
+}
+
+// downloadBackup downloads a backup to the temporary directory, the volumes of a split backup
+// are downloaded and joined
+func downloadBackup(st storage.Storage, fileName string) error {
+	files, err := st.List()
+	if err != nil {
+		return st.CopyFrom(fileName)
+	}
+	for _, backup := range groupBackups(files) {
+		if backup.Name == fileName && len(backup.volumes) > 0 {
+			return joinVolumes(st, fileName)
+		}
+	}
+	return st.CopyFrom(fileName)
+}
+
+// joinVolumes downloads the volumes of a split backup listed in its manifest, and joins them
+// into the backup file. The checksum of each volume and of the joined file are verified.
+func joinVolumes(st storage.Storage, fileName string) error {
+	if err := st.CopyFrom(manifestName(fileName)); err != nil {
+		return fmt.Errorf("failed to download the manifest of the split backup %s: %w", fileName, err)
+	}
+	manifest, err := readManifest(filepath.Join(tmpPath, manifestName(fileName)))
+	if err != nil {
+		return err
+	}
+	if len(manifest.Parts) == 0 {
+		return fmt.Errorf("the manifest of %s has no volumes", fileName)
+	}
+	out, err := os.Create(filepath.Join(tmpPath, fileName))
+	if err != nil {
+		return err
+	}
+	defer func(out *os.File) {
+		err := out.Close()
+		if err != nil {
+			utils.Error("Error closing file: %v", err)
+		}
+	}(out)
+
+	hash := sha256.New()
+	for i, part := range manifest.Parts {
+		utils.Info("Downloading volume %d/%d %s...", i+1, len(manifest.Parts), part.File)
+		if err = st.CopyFrom(part.File); err != nil {
+			return fmt.Errorf("failed to download volume %s: %w", part.File, err)
+		}
+		if err = appendVolume(io.MultiWriter(out, hash), part); err != nil {
+			return err
+		}
+	}
+	if checksum := "sha256:" + hex.EncodeToString(hash.Sum(nil)); checksum != manifest.Checksum {
+		return fmt.Errorf("checksum mismatch of the joined backup %s: expected %s, got %s", fileName, manifest.Checksum, checksum)
+	}
+	return nil
+}
+
+// appendVolume verifies a downloaded volume, writes it to w and deletes it
+func appendVolume(w io.Writer, part manifestPart) error {
+	volumePath := filepath.Join(tmpPath, part.File)
+	checksum, err := fileChecksum(volumePath)
+	if err != nil {
+		return err
+	}
+	if checksum != part.Checksum {
+		return fmt.Errorf("checksum mismatch of volume %s: expected %s, got %s", part.File, part.Checksum, checksum)
+	}
+	f, err := os.Open(volumePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(volumePath)
+}
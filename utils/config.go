@@ -24,7 +24,10 @@ SOFTWARE.
 
 package utils
 
-import "os"
+import (
+	"os"
+	"sync"
+)
 
 type MailConfig struct {
 	MailHost     string
@@ -82,7 +85,10 @@ func backupReference() string {
 
 const templatePath = "/config/templates"
 
-var DatabaseName = ""
+var (
+	databaseName   = ""
+	databaseNameMu sync.Mutex
+)
 var vars = []string{
 	"TG_TOKEN",
 	"TG_CHAT_ID",
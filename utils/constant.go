@@ -28,6 +28,18 @@ const RestoreExample = "restore --dbname database --file db_20231219_022941.sql.
 	"restore --dbname database --storage s3 --path /custom-path --file db_20231219_022941.sql.gz"
 const BackupExample = "backup --dbname database --disable-compression\n" +
 	"backup --dbname database --storage s3 --path /custom-path --disable-compression"
+const TransferExample = "transfer --from local --to s3 --to-path /custom-path\n" +
+	"transfer --from s3 --to ssh --dbname database --since 7d --delete-source"
+const ListExample = "list --storage s3 --path /custom-path\n" +
+	"list --dbname database --since 24h"
+const ReencryptExample = "reencrypt --storage s3 --path /custom-path\n" +
+	"reencrypt --dbname database --since 30d --encryption age"
+const VerifyExample = "verify --file db_20231219_022941.sql.gz\n" +
+	"verify --storage s3 --path /custom-path --file db_20231219_022941.sql.gz --require-signature"
+const ConfigValidateExample = "config validate --config /backup/config.yaml\n" +
+	"config validate --storage s3"
+const DoctorExample = "doctor --config /backup/config.yaml\n" +
+	"doctor --storage s3 --min-free-space 10GiB"
 
 const MainExample = "mysql-bkup backup --dbname database --disable-compression\n" +
 	"backup --dbname database --storage s3 --path /custom-path\n" +
@@ -39,6 +39,15 @@ func Info(msg string, args ...interface{}) {
 
 }
 
+// Debug logs debug messages, they are only logged when LOG_LEVEL is debug
+func Debug(msg string, args ...interface{}) {
+	if !strings.EqualFold(os.Getenv("LOG_LEVEL"), "debug") {
+		return
+	}
+	log.SetOutput(getStd("/dev/stdout"))
+	logWithCaller("DEBUG", msg, args...)
+}
+
 // Warn returns warning log
 func Warn(msg string, args ...interface{}) {
 	log.SetOutput(getStd("/dev/stdout"))
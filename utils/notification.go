@@ -28,6 +28,7 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-mail/mail"
 	"html/template"
@@ -133,7 +134,23 @@ func NotifySuccess(notificationData *NotificationData) {
 		}
 	}
 }
+
+// SetDatabaseName sets the database name of the error notifications
+func SetDatabaseName(name string) {
+	databaseNameMu.Lock()
+	defer databaseNameMu.Unlock()
+	databaseName = name
+}
+
 func NotifyError(error string) {
+	databaseNameMu.Lock()
+	name := databaseName
+	databaseNameMu.Unlock()
+	NotifyDatabaseError(name, error)
+}
+
+// NotifyDatabaseError sends the error notification of the backup of a database
+func NotifyDatabaseError(database, error string) {
 
 	// Email notification
 	err := CheckEnvVars(mailVars)
@@ -142,7 +159,7 @@ func NotifyError(error string) {
 			Error:           error,
 			EndTime:         time.Now().Format(TimeFormat()),
 			BackupReference: os.Getenv("BACKUP_REFERENCE"),
-			DatabaseName:    DatabaseName,
+			DatabaseName:    database,
 		}, "email-error.tmpl")
 		if err != nil {
 			Error("Could not parse error template: %v", err)
@@ -159,7 +176,7 @@ func NotifyError(error string) {
 			Error:           error,
 			EndTime:         time.Now().Format(TimeFormat()),
 			BackupReference: os.Getenv("BACKUP_REFERENCE"),
-			DatabaseName:    DatabaseName,
+			DatabaseName:    database,
 		}, "telegram-error.tmpl")
 		if err != nil {
 			Error("Could not parse error template: %v", err)
@@ -173,6 +190,44 @@ func NotifyError(error string) {
 	}
 }
 
+// MailEnabled checks if the mail notification is configured
+func MailEnabled() bool {
+	return CheckEnvVars(mailVars) == nil
+}
+
+// TelegramEnabled checks if the Telegram notification is configured
+func TelegramEnabled() bool {
+	return CheckEnvVars(vars) == nil
+}
+
+// CheckMail connects and authenticates to the SMTP server of the mail notification
+func CheckMail() error {
+	config := loadMailConfig()
+	d := mail.NewDialer(config.MailHost, config.MailPort, config.MailUserName, config.MailPassword)
+	d.TLSConfig = &tls.Config{InsecureSkipVerify: config.SkipTls}
+	sender, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	return sender.Close()
+}
+
+// CheckTelegram checks the Telegram bot token with the getMe method
+func CheckTelegram() error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(fmt.Sprintf("%s/getMe", getTgUrl()))
+	if err != nil {
+		// The error contains the URL with the bot token
+		return fmt.Errorf("could not reach the Telegram API: %w", errors.Unwrap(err))
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("telegram API returned %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
 func getTgUrl() string {
 	return fmt.Sprintf("https://api.telegram.org/bot%s", os.Getenv("TG_TOKEN"))
 
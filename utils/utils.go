@@ -32,6 +32,7 @@ import (
 	"io/fs"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -255,6 +256,27 @@ func CronNextTime(cronExpr string) time.Time {
 	return next
 }
 
+// ParseDuration parses a duration string such as 30m or 24h, it also accepts a number of days (e.g. 7d or 1d12h)
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if i := strings.Index(value, "d"); i > 0 {
+		days, err := strconv.Atoi(value[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		duration := time.Duration(days) * 24 * time.Hour
+		if rest := value[i+1:]; rest != "" {
+			d, err := time.ParseDuration(rest)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			duration += d
+		}
+		return duration, nil
+	}
+	return time.ParseDuration(value)
+}
+
 // ConvertBytes converts bytes to a human-readable string with the appropriate unit (bytes, MiB, or GiB).
 func ConvertBytes(bytes uint64) string {
 	const (